@@ -23,11 +23,11 @@ func ConnectDB() {
 	port := os.Getenv("DB_PORT")
 
 	// Create database connection string
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=UTC",
-		host, user, password, dbname, port)
+	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s TimeZone=UTC",
+		host, user, password, dbname, port, databaseSSLMode())
 
-	// Connect to database
-	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	// Connect to database, translating constraint violations to GORM errors like gorm.ErrDuplicatedKey
+	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
@@ -35,6 +35,14 @@ func ConnectDB() {
 	log.Println("Database connection established")
 }
 
+// databaseSSLMode returns the PostgreSQL sslmode (DB_SSLMODE, default disable)
+func databaseSSLMode() string {
+	if mode := os.Getenv("DB_SSLMODE"); mode != "" {
+		return mode
+	}
+	return "disable"
+}
+
 // GetDB returns the database instance
 func GetDB() *gorm.DB {
 	return DB
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// OIDCProvider holds the settings of an external OpenID Connect identity provider.
+// Providers are listed in OIDC_PROVIDERS and configured with OIDC_<NAME>_* variables.
+type OIDCProvider struct {
+	Name         string
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// AuthURL overrides the discovered authorization endpoint, for IdPs whose
+	// browser-facing address differs from the one the backend reaches (e.g. a mock server in docker)
+	AuthURL string
+	// TrustEmail treats the email claim as verified for IdPs that don't send email_verified
+	TrustEmail bool
+}
+
+// OIDCProviders returns the identity providers configured in the environment
+func OIDCProviders() []OIDCProvider {
+	var providers []OIDCProvider
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		providers = append(providers, loadOIDCProvider(name))
+	}
+	return providers
+}
+
+// FindOIDCProvider returns the configured identity provider with the given name
+func FindOIDCProvider(name string) (OIDCProvider, bool) {
+	for _, provider := range OIDCProviders() {
+		if provider.Name == name {
+			return provider, true
+		}
+	}
+	return OIDCProvider{}, false
+}
+
+// loadOIDCProvider reads the OIDC_<NAME>_* variables of a provider
+func loadOIDCProvider(name string) OIDCProvider {
+	env := func(key string) string {
+		return os.Getenv("OIDC_" + strings.ToUpper(name) + "_" + key)
+	}
+
+	provider := OIDCProvider{
+		Name:         name,
+		DisplayName:  env("DISPLAY_NAME"),
+		Issuer:       env("ISSUER"),
+		ClientID:     env("CLIENT_ID"),
+		ClientSecret: env("CLIENT_SECRET"),
+		RedirectURL:  env("REDIRECT_URL"),
+		Scopes:       strings.Fields(env("SCOPES")),
+		AuthURL:      env("AUTH_URL"),
+		TrustEmail:   env("TRUST_EMAIL") == "true",
+	}
+	if provider.DisplayName == "" {
+		provider.DisplayName = name
+	}
+	if len(provider.Scopes) == 0 {
+		provider.Scopes = []string{"openid", "email", "profile"}
+	}
+	return provider
+}
+
+// checkOIDCProviders makes sure every listed identity provider is fully configured
+func checkOIDCProviders() Check {
+	check := Check{Name: "oidc_providers", Critical: true, Passed: true}
+
+	var problems []string
+	for _, provider := range OIDCProviders() {
+		if provider.Issuer == "" || provider.ClientID == "" || provider.RedirectURL == "" {
+			problems = append(problems, fmt.Sprintf("%s needs an issuer, client ID and redirect URL", provider.Name))
+		}
+	}
+	if len(problems) > 0 {
+		check.Passed = false
+		check.Message = strings.Join(problems, "; ")
+	}
+	return check
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// defaultSearchLanguage is the text search configuration used when SEARCH_LANGUAGE is unset
+const defaultSearchLanguage = "portuguese"
+
+// searchLanguages are the PostgreSQL text search configurations product search can use
+var searchLanguages = []string{"portuguese", "english", "simple"}
+
+// SearchLanguage returns the PostgreSQL text search configuration used to stem product
+// names, descriptions and search queries (SEARCH_LANGUAGE: portuguese, english or simple)
+func SearchLanguage() string {
+	language := strings.ToLower(strings.TrimSpace(os.Getenv("SEARCH_LANGUAGE")))
+	for _, known := range searchLanguages {
+		if language == known {
+			return language
+		}
+	}
+	return defaultSearchLanguage
+}
+
+// checkSearchLanguage makes sure SEARCH_LANGUAGE, if set, is a supported configuration
+func checkSearchLanguage() Check {
+	check := Check{Name: "search_language", Passed: true}
+
+	language := strings.ToLower(strings.TrimSpace(os.Getenv("SEARCH_LANGUAGE")))
+	if language != "" && language != SearchLanguage() {
+		check.Passed = false
+		check.Message = "SEARCH_LANGUAGE must be one of " + strings.Join(searchLanguages, ", ") + "; using " + defaultSearchLanguage
+	}
+	return check
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"math"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Minimum JWT_SECRET requirements
+const (
+	minJWTSecretLength  = 32
+	minJWTSecretEntropy = 128 // bits, estimated from the character distribution
+)
+
+// Placeholder values that must never be used in production
+var weakSecrets = []string{"your_jwt_secret_here", "secret", "changeme", "jwt_secret", "password"}
+var weakDBPasswords = []string{"", "postgres", "password", "changeme", "admin"}
+
+// Check is the result of a configuration check.
+// Critical checks must pass for the server to start in release mode.
+type Check struct {
+	Name     string `json:"name"`
+	Passed   bool   `json:"passed"`
+	Critical bool   `json:"critical"`
+	Message  string `json:"message,omitempty"`
+}
+
+var (
+	checksMu sync.RWMutex
+	checks   []Check
+)
+
+// ValidateConfig checks the security-critical configuration read from the environment
+func ValidateConfig() []Check {
+	return []Check{
+		checkGinMode(),
+		checkJWTSecret(),
+		checkDatabaseSettings(),
+		checkDatabasePassword(),
+		checkDatabaseSSL(),
+		checkOIDCProviders(),
+		checkSearchLanguage(),
+	}
+}
+
+// SetChecks stores the configuration checks reported by the health endpoint
+func SetChecks(results []Check) {
+	checksMu.Lock()
+	defer checksMu.Unlock()
+	checks = results
+}
+
+// Checks returns the stored configuration checks
+func Checks() []Check {
+	checksMu.RLock()
+	defer checksMu.RUnlock()
+	return append([]Check(nil), checks...)
+}
+
+// IsReleaseMode reports whether GIN_MODE is release
+func IsReleaseMode() bool {
+	return os.Getenv("GIN_MODE") == "release"
+}
+
+// checkGinMode makes sure GIN_MODE is a known mode
+func checkGinMode() Check {
+	check := Check{Name: "gin_mode", Critical: true, Passed: true}
+	switch os.Getenv("GIN_MODE") {
+	case "release":
+	case "", "debug", "test":
+		check.Message = "not running in release mode"
+	default:
+		check.Passed = false
+		check.Message = "GIN_MODE must be debug, release or test"
+	}
+	return check
+}
+
+// checkJWTSecret makes sure the HMAC secret, when used, is long and random enough
+func checkJWTSecret() Check {
+	check := Check{Name: "jwt_secret", Critical: true}
+	secret := os.Getenv("JWT_SECRET")
+	algorithm := os.Getenv("JWT_ALGORITHM")
+
+	switch {
+	case secret == "" && (algorithm == "" || algorithm == "HS256"):
+		check.Message = "JWT_SECRET is required for HS256"
+	case secret == "" || (algorithm != "" && algorithm != "HS256" && os.Getenv("JWT_HMAC_ACCEPT_UNTIL") == ""):
+		check.Passed = true
+		check.Message = "not used, tokens are signed with " + algorithm
+	case isWeakValue(secret, weakSecrets):
+		check.Message = "JWT_SECRET is a well-known placeholder"
+	case len(secret) < minJWTSecretLength:
+		check.Message = "JWT_SECRET must be at least 32 characters long"
+	case estimateEntropy(secret) < minJWTSecretEntropy:
+		check.Message = "JWT_SECRET is not random enough"
+	default:
+		check.Passed = true
+	}
+	return check
+}
+
+// checkDatabaseSettings makes sure the database connection is configured
+func checkDatabaseSettings() Check {
+	check := Check{Name: "database_settings", Critical: true, Passed: true}
+
+	var missing []string
+	for _, name := range []string{"DB_HOST", "DB_USER", "DB_NAME", "DB_PORT"} {
+		if os.Getenv(name) == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		check.Passed = false
+		check.Message = "missing " + strings.Join(missing, ", ")
+	}
+	return check
+}
+
+// checkDatabasePassword warns about empty or default database passwords
+func checkDatabasePassword() Check {
+	check := Check{Name: "database_password", Passed: true}
+	if isWeakValue(os.Getenv("DB_PASSWORD"), weakDBPasswords) {
+		check.Passed = false
+		check.Message = "DB_PASSWORD is empty or a default value"
+	}
+	return check
+}
+
+// checkDatabaseSSL warns when the database connection is not encrypted
+func checkDatabaseSSL() Check {
+	check := Check{Name: "database_ssl", Passed: true}
+	if mode := databaseSSLMode(); mode == "disable" || mode == "allow" || mode == "prefer" {
+		check.Passed = false
+		check.Message = "DB_SSLMODE " + mode + " does not guarantee an encrypted connection"
+	}
+	return check
+}
+
+// isWeakValue checks a value against a list of known weak values, ignoring case
+func isWeakValue(value string, weak []string) bool {
+	for _, w := range weak {
+		if strings.EqualFold(value, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// estimateEntropy estimates the entropy of a string in bits from its character frequencies
+func estimateEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	total := 0
+	for _, r := range s {
+		counts[r]++
+		total++
+	}
+
+	perChar := 0.0
+	for _, count := range counts {
+		p := float64(count) / float64(total)
+		perChar -= p * math.Log2(p)
+	}
+	return perChar * float64(total)
+}
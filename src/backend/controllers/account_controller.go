@@ -0,0 +1,230 @@
+package controllers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"backend/config"
+	"backend/mailer"
+	"backend/models"
+)
+
+// AccountController handles the current user's personal data: exporting it and deleting the account
+type AccountController struct {
+	DB     *gorm.DB
+	Mailer mailer.Mailer
+}
+
+// accountExport is the profile part of a personal data export
+type accountExport struct {
+	User       models.UserResponse      `json:"user"`
+	Identities []models.UserIdentity    `json:"identities"`
+	Sessions   []models.SessionResponse `json:"sessions"`
+	APIKeys    []models.APIKeyResponse  `json:"apiKeys"`
+	ExportedAt time.Time                `json:"exportedAt"`
+}
+
+// NewAccountController creates a new AccountController
+func NewAccountController() *AccountController {
+	return &AccountController{
+		DB:     config.GetDB(),
+		Mailer: mailer.NewFromEnv(),
+	}
+}
+
+// ExportData sends the current user's personal data as a ZIP file: the profile with linked
+// accounts, sessions and API keys, the profile image and the audit history. Products have no
+// owner, so the product images the user uploaded belong to the catalog and are left out;
+// the uploads show up in the audit history.
+func (ac *AccountController) ExportData(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Find user by ID
+	var user models.User
+	if err := ac.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Gather everything before streaming, so failures can still be reported
+	export := accountExport{
+		User:       user.ToResponse(),
+		Sessions:   []models.SessionResponse{},
+		APIKeys:    []models.APIKeyResponse{},
+		ExportedAt: time.Now(),
+	}
+	if err := ac.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&export.Identities).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
+		return
+	}
+
+	var sessions []models.Session
+	if err := ac.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
+		return
+	}
+	currentID := c.GetString("sessionId")
+	for _, session := range sessions {
+		export.Sessions = append(export.Sessions, session.ToResponse(session.ID == currentID))
+	}
+
+	var apiKeys []models.APIKey
+	if err := ac.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&apiKeys).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
+		return
+	}
+	for _, apiKey := range apiKeys {
+		export.APIKeys = append(export.APIKeys, apiKey.ToResponse())
+	}
+
+	// The audit history covers changes to the user and changes the user made
+	var events []models.AuditEvent
+	if err := ac.DB.Where("(entity_type = ? AND entity_id = ?) OR actor_id = ?", models.AuditEntityUser, user.ID, user.ID).
+		Order("created_at, id").Find(&events).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
+		return
+	}
+	eventResponses := make([]models.AuditEventResponse, 0, len(events))
+	for _, event := range events {
+		eventResponses = append(eventResponses, event.ToResponse())
+	}
+
+	// Stream the archive
+	filename := fmt.Sprintf("account-%d-%s.zip", user.ID, export.ExportedAt.Format("20060102"))
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Status(http.StatusOK)
+
+	archive := zip.NewWriter(c.Writer)
+	err := writeZipJSON(archive, "profile.json", export)
+	if err == nil {
+		err = writeZipJSON(archive, "audit.json", eventResponses)
+	}
+	if err == nil && user.ImagePath != "" {
+		err = writeZipFile(archive, "images/"+path.Base(user.ImagePath), user.ImagePath)
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		// The status is already sent; the client gets a truncated archive
+		log.Printf("Failed to export data of user %d: %v", user.ID, err)
+	}
+}
+
+// DeleteAccount schedules the deletion of the current user's account after re-authentication.
+// The user is signed out everywhere; signing in again during the grace period cancels the
+// deletion, and once it's over the account is erased.
+func (ac *AccountController) DeleteAccount(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Parse deletion data
+	var deleteData reauthData
+	if err := c.ShouldBindJSON(&deleteData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Find user by ID
+	var user models.User
+	if err := ac.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.DeleteAfter != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Account deletion already requested"})
+		return
+	}
+
+	// Check the current password or MFA code
+	if !reauthenticate(c, ac.DB, &user, deleteData) {
+		return
+	}
+
+	// Schedule the deletion, revoke API keys and record it in the audit log
+	before := user.ToResponse()
+	now := time.Now()
+	deleteAfter := now.Add(accountDeletionGracePeriod())
+	err := ac.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("delete_after", deleteAfter).Error; err != nil {
+			return err
+		}
+		if err := revokeUserAPIKeys(tx, user.ID); err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionUpdate, models.AuditEntityUser, user.ID, before, user.ToResponse())
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+
+	// Sign the user out everywhere
+	if err := revokeUserTokens(ac.DB, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke tokens"})
+		return
+	}
+
+	// Tell the user how to change their mind
+	if err := sendAccountDeletionNotice(ac.Mailer, &user); err != nil {
+		log.Printf("Failed to send account deletion notice: %v", err)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":     "Account scheduled for deletion",
+		"deleteAfter": deleteAfter,
+	})
+}
+
+// writeZipJSON adds a JSON file to a ZIP archive
+func writeZipJSON(archive *zip.Writer, name string, value interface{}) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// writeZipFile adds an uploaded file to a ZIP archive, skipping it if it no longer exists
+func writeZipFile(archive *zip.Writer, name, filePath string) error {
+	f, err := os.Open(strings.TrimPrefix(filePath, "/"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"backend/models"
+	"backend/utils"
+)
+
+// erasedValue replaces personal data kept in other tables once an account is deleted
+const erasedValue = "[deleted]"
+
+// auditPersonalFields are the user fields whose values are erased from audit changes
+var auditPersonalFields = []string{"name", "email", "pendingEmail", "imagePath"}
+
+// errUserNotDeleted is returned when the user to erase is gone or no longer due for deletion
+var errUserNotDeleted = errors.New("user not deleted")
+
+// accountDeletionGracePeriod returns how long a deleted account can still be restored
+// (ACCOUNT_DELETION_GRACE_PERIOD, default 30 days)
+func accountDeletionGracePeriod() time.Duration {
+	if period, err := time.ParseDuration(os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD")); err == nil && period > 0 {
+		return period
+	}
+	return 30 * 24 * time.Hour
+}
+
+// StartAccountDeletionJob erases the accounts whose deletion grace period is over, now and
+// then every interval
+func StartAccountDeletionJob(db *gorm.DB, interval time.Duration) {
+	go func() {
+		for {
+			deleted, err := deleteExpiredAccounts(db)
+			if err != nil {
+				log.Printf("Failed to delete accounts: %v", err)
+			}
+			if deleted > 0 {
+				log.Printf("Deleted %d accounts after their grace period", deleted)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// cancelAccountDeletion cancels the pending deletion of the user's account, if any, and
+// records it in the audit log. Signing in during the grace period keeps the account.
+func cancelAccountDeletion(c *gin.Context, db *gorm.DB, user *models.User) error {
+	if user.DeleteAfter == nil {
+		return nil
+	}
+
+	before := user.ToResponse()
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("delete_after", nil).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionUpdate, models.AuditEntityUser, user.ID, before, user.ToResponse())
+	})
+}
+
+// deleteExpiredAccounts erases every account whose deletion grace period is over and
+// returns how many were erased
+func deleteExpiredAccounts(db *gorm.DB) (int, error) {
+	var users []models.User
+	if err := db.Where("delete_after <= ?", time.Now()).Find(&users).Error; err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for i := range users {
+		user := &users[i]
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// Another replica may have erased the user, or the user restored the account
+			result := tx.Where("id = ? AND delete_after <= ?", user.ID, time.Now()).Delete(&models.User{})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errUserNotDeleted
+			}
+			if err := recordAudit(tx, nil, models.AuditActionDelete, models.AuditEntityUser, user.ID, user.ToResponse(), nil); err != nil {
+				return err
+			}
+			return eraseUserData(tx, user.ID)
+		})
+		if errors.Is(err, errUserNotDeleted) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to delete user %d: %v", user.ID, err)
+			continue
+		}
+
+		deleted++
+		if user.ImagePath != "" {
+			utils.DeleteFile(user.ImagePath)
+		}
+		if err := revokeUserTokens(db, user.ID); err != nil {
+			log.Printf("Failed to revoke tokens of deleted user %d: %v", user.ID, err)
+		}
+	}
+
+	return deleted, nil
+}
+
+// eraseUserData removes the rows that belong to a deleted user and anonymises the
+// references to them that are kept for accountability, including the user's audit events
+// recorded so far
+func eraseUserData(tx *gorm.DB, userID uint) error {
+	// Credentials, sessions and linked accounts only matter to the user
+	for _, model := range []interface{}{
+		&models.RefreshToken{},
+		&models.UserToken{},
+		&models.RecoveryCode{},
+		&models.APIKey{},
+		&models.UserIdentity{},
+		&models.Session{},
+	} {
+		if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+
+	// Impersonation records stay. Their IP address is the impersonating admin's, so it's only
+	// erased from the requests the deleted user made as an admin.
+	if err := tx.Model(&models.ImpersonationRequest{}).
+		Where("impersonator_id = ?", userID).
+		Update("ip_address", "").Error; err != nil {
+		return err
+	}
+
+	// Audit events are append-only, so erasing personal data has to skip their hooks and
+	// be allowed by the database. The events keep the user ID, which no longer identifies anyone.
+	if err := models.AllowAuditErasure(tx, userID); err != nil {
+		return err
+	}
+	audit := tx.Session(&gorm.Session{SkipHooks: true})
+	if err := audit.Model(&models.AuditEvent{}).
+		Where("actor_id = ? OR impersonator_id = ?", userID, userID).
+		Updates(map[string]interface{}{"ip_address": "", "user_agent": ""}).Error; err != nil {
+		return err
+	}
+
+	var events []models.AuditEvent
+	if err := tx.Where("entity_type = ? AND entity_id = ?", models.AuditEntityUser, userID).Find(&events).Error; err != nil {
+		return err
+	}
+	for _, event := range events {
+		changes, err := eraseAuditChanges(event.Changes)
+		if err != nil {
+			return err
+		}
+		if changes == event.Changes {
+			continue
+		}
+		if err := audit.Model(&event).Update("changes", changes).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// eraseAuditChanges replaces the personal values in the JSON changes of a user audit event
+func eraseAuditChanges(changes string) (string, error) {
+	if changes == "" {
+		return changes, nil
+	}
+
+	var fields map[string]auditChange
+	if err := json.Unmarshal([]byte(changes), &fields); err != nil {
+		return "", err
+	}
+
+	erased := false
+	for _, name := range auditPersonalFields {
+		change, ok := fields[name]
+		if !ok {
+			continue
+		}
+		if change.From != nil {
+			change.From = erasedValue
+		}
+		if change.To != nil {
+			change.To = erasedValue
+		}
+		fields[name] = change
+		erased = true
+	}
+	if !erased {
+		return changes, nil
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+
+	"backend/models"
+)
+
+func TestDeleteExpiredAccounts(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(models.All()...); err != nil {
+		t.Fatal(err)
+	}
+
+	// One account past its grace period, one still in it
+	expired := time.Now().Add(-time.Minute)
+	pending := time.Now().Add(time.Hour)
+	deleted := models.User{Name: "Deleted User", Email: "deleted@example.com", Password: "secret-pass-1", DeleteAfter: &expired}
+	kept := models.User{Name: "Kept User", Email: "kept@example.com", Password: "secret-pass-1", DeleteAfter: &pending}
+	for _, user := range []*models.User{&deleted, &kept} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, row := range []interface{}{
+		&models.APIKey{UserID: deleted.ID, Name: "script", Prefix: "pk_1", KeyHash: "hash-1", Scopes: models.ScopeProfileRead},
+		&models.APIKey{UserID: kept.ID, Name: "script", Prefix: "pk_2", KeyHash: "hash-2", Scopes: models.ScopeProfileRead},
+		&models.Session{ID: "session-1", UserID: deleted.ID, ExpiresAt: pending},
+		&models.RefreshToken{UserID: deleted.ID, TokenHash: "refresh-1", FamilyID: "session-1", ExpiresAt: pending},
+		&models.UserIdentity{UserID: deleted.ID, Provider: "idp", Subject: "subject-1", Email: deleted.Email},
+		&models.ImpersonationRequest{ImpersonatorID: kept.ID, UserID: deleted.ID, Path: "/users/me", IPAddress: "192.0.2.1"},
+		&models.ImpersonationRequest{ImpersonatorID: deleted.ID, UserID: kept.ID, Path: "/users/me", IPAddress: "192.0.2.2"},
+	} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The user's profile changes and a change the user made to a product
+	before := deleted.ToResponse()
+	after := before
+	after.Name = "Deleted User Renamed"
+	if err := recordAudit(db, nil, models.AuditActionUpdate, models.AuditEntityUser, deleted.ID, before, after); err != nil {
+		t.Fatal(err)
+	}
+	actor := deleted.ID
+	productEvent := models.AuditEvent{ActorID: &actor, Action: models.AuditActionCreate, EntityType: models.AuditEntityProduct, EntityID: 1,
+		Changes: `{"name":{"to":"Shirt"}}`, IPAddress: "192.0.2.1", UserAgent: "curl/8.0"}
+	if err := db.Create(&productEvent).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := deleteExpiredAccounts(db)
+	if err != nil || count != 1 {
+		t.Fatalf("deleteExpiredAccounts() = %d, %v; want 1", count, err)
+	}
+
+	// The account and what only matters to it are gone
+	if err := db.First(&models.User{}, deleted.ID).Error; err == nil {
+		t.Error("deleted user still exists")
+	}
+	for _, model := range []interface{}{&models.APIKey{}, &models.Session{}, &models.RefreshToken{}, &models.UserIdentity{}} {
+		var rows int64
+		db.Model(model).Where("user_id = ?", deleted.ID).Count(&rows)
+		if rows != 0 {
+			t.Errorf("%T: %d rows left for the deleted user", model, rows)
+		}
+	}
+
+	// Records kept for accountability no longer say who the user was
+	var asAdmin, asUser models.ImpersonationRequest
+	if err := db.Where("impersonator_id = ?", deleted.ID).First(&asAdmin).Error; err != nil || asAdmin.IPAddress != "" {
+		t.Errorf("request made by the deleted user as an admin: %+v, %v", asAdmin, err)
+	}
+
+	// The admin who impersonated the deleted user keeps their record
+	if err := db.Where("user_id = ?", deleted.ID).First(&asUser).Error; err != nil || asUser.IPAddress != "192.0.2.1" {
+		t.Errorf("request made as the deleted user: %+v, %v", asUser, err)
+	}
+	if err := db.First(&productEvent, productEvent.ID).Error; err != nil || productEvent.IPAddress != "" || productEvent.UserAgent != "" {
+		t.Errorf("event by the user: %+v, %v", productEvent, err)
+	}
+	var userEvents []models.AuditEvent
+	if err := db.Where("entity_type = ? AND entity_id = ?", models.AuditEntityUser, deleted.ID).Order("id").Find(&userEvents).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(userEvents) != 2 || userEvents[1].Action != models.AuditActionDelete {
+		t.Fatalf("user events: %+v", userEvents)
+	}
+	for _, event := range userEvents {
+		var changes map[string]auditChange
+		if err := json.Unmarshal([]byte(event.Changes), &changes); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := changes["name"]; !ok {
+			t.Errorf("%s event has no name change: %s", event.Action, event.Changes)
+		}
+		for _, field := range []string{"name", "email"} {
+			change, ok := changes[field]
+			if !ok {
+				continue
+			}
+			if (change.From != nil && change.From != erasedValue) || (change.To != nil && change.To != erasedValue) {
+				t.Errorf("%s event: %s not erased: %+v", event.Action, field, change)
+			}
+		}
+	}
+
+	// The account still in its grace period is left alone, and nothing is deleted twice
+	if err := db.First(&models.User{}, kept.ID).Error; err != nil {
+		t.Errorf("kept user: %v", err)
+	}
+	var keys int64
+	db.Model(&models.APIKey{}).Where("user_id = ?", kept.ID).Count(&keys)
+	if keys != 1 {
+		t.Errorf("kept user has %d API keys, want 1", keys)
+	}
+	if count, err := deleteExpiredAccounts(db); err != nil || count != 0 {
+		t.Errorf("second run: %d, %v; want 0", count, err)
+	}
+}
@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"backend/models"
+)
+
+// Reasons why BootstrapAdmin didn't promote anyone
+var (
+	ErrAdminExists            = errors.New("an admin already exists")
+	ErrBootstrapAdminNotFound = errors.New("user not found or email not verified")
+	ErrBootstrapAdminDemoted  = errors.New("user's role has been changed before")
+)
+
+// BootstrapAdmin promotes the user with the given email to admin so the first admin can be
+// created. It only acts while there is no admin, and only on a verified email whose role was
+// never changed, so whoever registers the address first can't claim it and a demoted admin
+// isn't promoted again.
+func BootstrapAdmin(db *gorm.DB, email string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var admins int64
+		if err := tx.Model(&models.User{}).Where("role = ?", models.RoleAdmin).Count(&admins).Error; err != nil {
+			return err
+		}
+		if admins > 0 {
+			return ErrAdminExists
+		}
+
+		var user models.User
+		if err := tx.Where("email = ? AND email_verified_at IS NOT NULL", email).First(&user).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrBootstrapAdminNotFound
+			}
+			return err
+		}
+
+		var roleChanges int64
+		if err := tx.Model(&models.AuditEvent{}).
+			Where("action = ? AND entity_type = ? AND entity_id = ?", models.AuditActionRoleChange, models.AuditEntityUser, user.ID).
+			Count(&roleChanges).Error; err != nil {
+			return err
+		}
+		if roleChanges > 0 {
+			return ErrBootstrapAdminDemoted
+		}
+
+		before := user.ToResponse()
+		if err := tx.Model(&user).Update("role", models.RoleAdmin).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, nil, models.AuditActionRoleChange, models.AuditEntityUser, user.ID, before, user.ToResponse())
+	})
+}
@@ -0,0 +1,244 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+)
+
+// APIKeyController handles personal API key operations
+type APIKeyController struct {
+	DB *gorm.DB
+}
+
+// NewAPIKeyController creates a new APIKeyController
+func NewAPIKeyController() *APIKeyController {
+	return &APIKeyController{
+		DB: config.GetDB(),
+	}
+}
+
+// ListAPIKeys lists the current user's active API keys
+func (kc *APIKeyController) ListAPIKeys(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var apiKeys []models.APIKey
+	if err := kc.DB.Where("user_id = ? AND revoked_at IS NULL", userID).Order("created_at DESC").Find(&apiKeys).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get API keys"})
+		return
+	}
+
+	// Convert API keys to responses
+	apiKeyResponses := make([]models.APIKeyResponse, 0, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		apiKeyResponses = append(apiKeyResponses, apiKey.ToResponse())
+	}
+
+	c.JSON(http.StatusOK, gin.H{"apiKeys": apiKeyResponses})
+}
+
+// CreateAPIKey creates an API key for the current user. The key itself is only returned once.
+func (kc *APIKeyController) CreateAPIKey(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Parse API key data
+	var keyData struct {
+		Name      string     `json:"name" binding:"required,max=100"`
+		Scopes    []string   `json:"scopes" binding:"required,min=1"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+
+	if err := c.ShouldBindJSON(&keyData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scopes, ok := validateScopes(c, keyData.Scopes)
+	if !ok {
+		return
+	}
+	if keyData.ExpiresAt != nil && !keyData.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry must be in the future"})
+		return
+	}
+
+	// Generate the key: "pk_" + a public prefix that identifies it + the secret
+	prefix, err := utils.GenerateRandomToken(6)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+	secret, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+	prefix = "pk_" + prefix
+	key := prefix + "." + secret
+
+	// Create API key and record it in the audit log
+	apiKey := models.APIKey{
+		UserID:    userID.(uint),
+		Name:      keyData.Name,
+		Prefix:    prefix,
+		KeyHash:   utils.HashToken(key),
+		Scopes:    strings.Join(scopes, " "),
+		MFA:       c.GetBool("mfa"),
+		ExpiresAt: keyData.ExpiresAt,
+	}
+	err = kc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&apiKey).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionCreate, models.AuditEntityAPIKey, apiKey.ID, nil, apiKey.ToResponse())
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	// Return API key response with the key, which can't be retrieved again
+	c.JSON(http.StatusCreated, gin.H{
+		"apiKey": apiKey.ToResponse(),
+		"key":    key,
+	})
+}
+
+// UpdateAPIKey renames an API key or changes its scopes
+func (kc *APIKeyController) UpdateAPIKey(c *gin.Context) {
+	apiKey, ok := kc.findAPIKey(c)
+	if !ok {
+		return
+	}
+
+	// Parse update data
+	var updateData struct {
+		Name   string   `json:"name" binding:"max=100"`
+		Scopes []string `json:"scopes"`
+	}
+
+	if err := c.ShouldBindJSON(&updateData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Keep a snapshot for the audit log
+	before := apiKey.ToResponse()
+
+	// Update fields if provided
+	if updateData.Name != "" {
+		apiKey.Name = updateData.Name
+	}
+	if updateData.Scopes != nil {
+		scopes, ok := validateScopes(c, updateData.Scopes)
+		if !ok {
+			return
+		}
+		apiKey.Scopes = strings.Join(scopes, " ")
+	}
+
+	// Save API key and record the change in the audit log
+	err := kc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(apiKey).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionUpdate, models.AuditEntityAPIKey, apiKey.ID, before, apiKey.ToResponse())
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update API key"})
+		return
+	}
+
+	// Return API key response
+	c.JSON(http.StatusOK, gin.H{"apiKey": apiKey.ToResponse()})
+}
+
+// RevokeAPIKey revokes an API key of the current user
+func (kc *APIKeyController) RevokeAPIKey(c *gin.Context) {
+	apiKey, ok := kc.findAPIKey(c)
+	if !ok {
+		return
+	}
+
+	// Revoke API key and record it in the audit log
+	err := kc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(apiKey).Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionRevoke, models.AuditEntityAPIKey, apiKey.ID, nil, nil)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
+
+// findAPIKey finds an active API key of the current user from the URL parameter,
+// writing the error response if there is none
+func (kc *APIKeyController) findAPIKey(c *gin.Context) (*models.APIKey, bool) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, false
+	}
+
+	// Get API key ID from URL parameter
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return nil, false
+	}
+
+	// Find API key by ID, only among the user's own keys
+	var apiKey models.APIKey
+	if err := kc.DB.Where("user_id = ? AND revoked_at IS NULL", userID).First(&apiKey, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return nil, false
+	}
+
+	return &apiKey, true
+}
+
+// validateScopes checks and de-duplicates requested scopes, writing the error response if
+// there are none or one is unknown
+func validateScopes(c *gin.Context, requested []string) ([]string, bool) {
+	if len(requested) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one scope is required", "validScopes": models.APIKeyScopes})
+		return nil, false
+	}
+
+	seen := make(map[string]bool)
+	scopes := make([]string, 0, len(requested))
+	for _, scope := range requested {
+		if !models.IsValidScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope: " + scope, "validScopes": models.APIKeyScopes})
+			return nil, false
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, true
+}
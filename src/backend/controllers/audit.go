@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"backend/models"
+)
+
+// auditChange is the value of a field before and after a change
+type auditChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// auditIgnoredFields change with every update and aren't worth recording
+var auditIgnoredFields = map[string]bool{"createdAt": true, "updatedAt": true}
+
+// recordAudit writes an audit event for a change, using the transaction that makes the change.
+// before and after are snapshots of the entity, nil when it's created or deleted; only the
+// fields that differ are stored. The actor and request details are taken from c, if given.
+func recordAudit(tx *gorm.DB, c *gin.Context, action, entityType string, entityID uint, before, after interface{}) error {
+	changes, err := auditChanges(before, after)
+	if err != nil {
+		return err
+	}
+
+	event := models.AuditEvent{
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Changes:    changes,
+	}
+	if c != nil {
+		event.ActorID = contextUint(c, "userId")
+		event.ImpersonatorID = contextUint(c, "impersonatorId")
+		event.APIKeyID = contextUint(c, "apiKeyId")
+		event.Method = c.Request.Method
+		event.Path = c.Request.URL.Path
+		event.IPAddress = c.ClientIP()
+		event.UserAgent = c.Request.UserAgent()
+	}
+
+	return tx.Create(&event).Error
+}
+
+// auditChanges returns the fields that differ between two snapshots as JSON
+func auditChanges(before, after interface{}) (string, error) {
+	beforeFields, err := auditFields(before)
+	if err != nil {
+		return "", err
+	}
+	afterFields, err := auditFields(after)
+	if err != nil {
+		return "", err
+	}
+
+	changes := make(map[string]auditChange)
+	for field, value := range beforeFields {
+		if !reflect.DeepEqual(value, afterFields[field]) {
+			changes[field] = auditChange{From: value, To: afterFields[field]}
+		}
+	}
+	for field, value := range afterFields {
+		if _, ok := beforeFields[field]; !ok && value != nil {
+			changes[field] = auditChange{To: value}
+		}
+	}
+
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// auditFields converts a snapshot to a map of its JSON fields
+func auditFields(snapshot interface{}) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	if snapshot == nil {
+		return fields, nil
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	for field := range auditIgnoredFields {
+		delete(fields, field)
+	}
+	return fields, nil
+}
+
+// contextUint returns a pointer to a uint context value, or nil if it isn't set
+func contextUint(c *gin.Context, key string) *uint {
+	if value := c.GetUint(key); value != 0 {
+		return &value
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"backend/config"
+	"backend/models"
+)
+
+// Audit log page sizes
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 200
+)
+
+// AuditController handles reading the audit log and the requests made while impersonating
+type AuditController struct {
+	DB *gorm.DB
+}
+
+// NewAuditController creates a new AuditController
+func NewAuditController() *AuditController {
+	return &AuditController{
+		DB: config.GetDB(),
+	}
+}
+
+// ListAuditEvents lists audit events, newest first. Events can be filtered by actorId,
+// action, entityType, entityId and a from/to time range (RFC 3339), and are paginated
+// with page and pageSize.
+func (ac *AuditController) ListAuditEvents(c *gin.Context) {
+	if !checkQueryParams(c, "actorId", "action", "entityType", "entityId", "from", "to", pageParam, pageSizeParam) {
+		return
+	}
+
+	query := ac.DB.Model(&models.AuditEvent{})
+
+	// Apply filters
+	for _, param := range []struct{ name, column string }{
+		{"actorId", "actor_id"},
+		{"entityId", "entity_id"},
+	} {
+		if value := c.Query(param.name); value != "" {
+			id, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param.name})
+				return
+			}
+			query = query.Where(param.column+" = ?", id)
+		}
+	}
+	if action := c.Query("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+	if entityType := c.Query("entityType"); entityType != "" {
+		query = query.Where("entity_type = ?", entityType)
+	}
+	query, ok := filterCreatedAt(c, query)
+	if !ok {
+		return
+	}
+	page, pageSize, ok := auditPage(c)
+	if !ok {
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get audit events"})
+		return
+	}
+
+	var events []models.AuditEvent
+	if err := query.Order("created_at DESC, id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&events).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get audit events"})
+		return
+	}
+
+	// Convert events to responses
+	eventResponses := make([]models.AuditEventResponse, 0, len(events))
+	for _, event := range events {
+		eventResponses = append(eventResponses, event.ToResponse())
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events":     eventResponses,
+		"pagination": auditPagination(page, pageSize, total),
+	})
+}
+
+// ListImpersonationRequests lists the requests admins made while impersonating users, newest
+// first. Requests can be filtered by impersonatorId, userId, tokenId and a from/to time range
+// (RFC 3339), and are paginated with page and pageSize.
+func (ac *AuditController) ListImpersonationRequests(c *gin.Context) {
+	if !checkQueryParams(c, "impersonatorId", "userId", "tokenId", "from", "to", pageParam, pageSizeParam) {
+		return
+	}
+
+	query := ac.DB.Model(&models.ImpersonationRequest{})
+
+	// Apply filters
+	for _, param := range []struct{ name, column string }{
+		{"impersonatorId", "impersonator_id"},
+		{"userId", "user_id"},
+	} {
+		if value := c.Query(param.name); value != "" {
+			id, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param.name})
+				return
+			}
+			query = query.Where(param.column+" = ?", id)
+		}
+	}
+	if tokenID := c.Query("tokenId"); tokenID != "" {
+		query = query.Where("token_id = ?", tokenID)
+	}
+	query, ok := filterCreatedAt(c, query)
+	if !ok {
+		return
+	}
+	page, pageSize, ok := auditPage(c)
+	if !ok {
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get impersonation requests"})
+		return
+	}
+
+	requests := []models.ImpersonationRequest{}
+	if err := query.Order("created_at DESC, id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&requests).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get impersonation requests"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"requests":   requests,
+		"pagination": auditPagination(page, pageSize, total),
+	})
+}
+
+// filterCreatedAt applies the from/to query parameters (RFC 3339) to the creation time.
+// On failure the error response has been written.
+func filterCreatedAt(c *gin.Context, query *gorm.DB) (*gorm.DB, bool) {
+	if from := c.Query("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from time, expected RFC 3339"})
+			return nil, false
+		}
+		query = query.Where("created_at >= ?", t)
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to time, expected RFC 3339"})
+			return nil, false
+		}
+		query = query.Where("created_at < ?", t)
+	}
+	return query, true
+}
+
+// auditPage parses the page and pageSize query parameters. On failure the error response
+// has been written.
+func auditPage(c *gin.Context) (int, int, bool) {
+	page, err := strconv.Atoi(c.DefaultQuery(pageParam, "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page"})
+		return 0, 0, false
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery(pageSizeParam, strconv.Itoa(defaultAuditPageSize)))
+	if err != nil || pageSize < 1 || pageSize > maxAuditPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "pageSize must be between 1 and " + strconv.Itoa(maxAuditPageSize)})
+		return 0, 0, false
+	}
+	return page, pageSize, true
+}
+
+// auditPagination describes the returned page
+func auditPagination(page, pageSize int, total int64) gin.H {
+	return gin.H{
+		"page":       page,
+		"pageSize":   pageSize,
+		"total":      total,
+		"totalPages": (total + int64(pageSize) - 1) / int64(pageSize),
+	}
+}
@@ -0,0 +1,454 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"backend/config"
+	"backend/mailer"
+	"backend/middleware"
+	"backend/models"
+	"backend/utils"
+)
+
+// errEmailTaken is returned when another user has the email
+var errEmailTaken = errors.New("email taken")
+
+// isEmailTaken checks if saving an email failed because another user has it, either found
+// beforehand or, when two requests race, by the unique index
+func isEmailTaken(err error) bool {
+	return errors.Is(err, errEmailTaken) || errors.Is(err, gorm.ErrDuplicatedKey)
+}
+
+// AuthController handles token and account recovery operations
+type AuthController struct {
+	DB     *gorm.DB
+	Mailer mailer.Mailer
+}
+
+// NewAuthController creates a new AuthController
+func NewAuthController() *AuthController {
+	return &AuthController{
+		DB:     config.GetDB(),
+		Mailer: mailer.NewFromEnv(),
+	}
+}
+
+// Refresh exchanges a refresh token for a new access token and a rotated refresh token.
+// Presenting a refresh token that was already used revokes its whole family.
+func (ac *AuthController) Refresh(c *gin.Context) {
+	// Parse refresh data
+	var refreshData struct {
+		RefreshToken string `json:"refreshToken" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&refreshData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Find refresh token by hash
+	var stored models.RefreshToken
+	if err := ac.DB.Where("token_hash = ?", utils.HashToken(refreshData.RefreshToken)).First(&stored).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	// Reuse of a rotated token means it may have been stolen, so revoke the whole family
+	if stored.RevokedAt != nil {
+		ac.endSession(stored.FamilyID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected"})
+		return
+	}
+
+	if !stored.IsActive() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token expired"})
+		return
+	}
+
+	// Find the token owner
+	var user models.User
+	if err := ac.DB.First(&user, stored.UserID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	// Rotate the refresh token
+	var tokens *authTokens
+	reused := false
+	err := ac.DB.Transaction(func(tx *gorm.DB) error {
+		// Mark the token as used; losing this race to a concurrent request counts as reuse
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", stored.ID).
+			Update("revoked_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			reused = true
+			return nil
+		}
+
+		var err error
+		tokens, err = issueTokens(c, tx, &user, stored.FamilyID, stored.MFA)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+
+	if reused {
+		ac.endSession(stored.FamilyID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected"})
+		return
+	}
+
+	// Return new tokens
+	c.JSON(http.StatusOK, gin.H{
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
+	})
+}
+
+// Logout revokes the current access token and ends its session, or the session of the
+// given refresh token. With "all" set, every token of the user is revoked.
+func (ac *AuthController) Logout(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Parse logout data (optional)
+	var logoutData struct {
+		RefreshToken string `json:"refreshToken"`
+		All          bool   `json:"all"`
+	}
+
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&logoutData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if logoutData.All {
+		// Signing the user out everywhere is up to the user, not an impersonating admin
+		if c.GetUint("impersonatorId") != 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed while impersonating a user"})
+			return
+		}
+
+		if err := revokeUserTokens(ac.DB, userID.(uint)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out from all sessions"})
+		return
+	}
+
+	// Revoke the current access token
+	if store := middleware.Revocations(); store != nil {
+		tokenID := c.GetString("tokenId")
+		expiresAt := c.GetTime("tokenExpiresAt")
+		if err := store.RevokeToken(tokenID, userID.(uint), expiresAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
+			return
+		}
+	}
+
+	// End the current session
+	if sessionID := c.GetString("sessionId"); sessionID != "" {
+		ac.endSession(sessionID)
+	}
+
+	// End the refresh token's session, if the refresh token belongs to the user
+	if logoutData.RefreshToken != "" {
+		var stored models.RefreshToken
+		err := ac.DB.Where("token_hash = ? AND user_id = ?", utils.HashToken(logoutData.RefreshToken), userID).First(&stored).Error
+		if err == nil {
+			ac.endSession(stored.FamilyID)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// ForgotPassword emails a password reset link to the user.
+// The response is the same whether or not the email is registered, and the token is created
+// and sent in the background so the response time doesn't tell either.
+func (ac *AuthController) ForgotPassword(c *gin.Context) {
+	// Parse request data
+	var forgotData struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&forgotData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Find user by email and send the reset email, answering as usual if either fails
+	var user models.User
+	if err := ac.DB.Where("email = ?", forgotData.Email).First(&user).Error; err == nil {
+		go func() {
+			if err := sendPasswordResetEmail(ac.DB, ac.Mailer, &user); err != nil {
+				log.Printf("Failed to send password reset email: %v", err)
+			}
+		}()
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the email is registered, a reset link has been sent"})
+}
+
+// ResetPassword sets a new password using a reset token and revokes the user's tokens and API keys
+func (ac *AuthController) ResetPassword(c *gin.Context) {
+	// Parse reset data
+	var resetData struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&resetData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Consume the reset token, then check the new password of its owner against the password
+	// policy and update it (hashed by the BeforeUpdate hook), revoking the user's API keys and
+	// recording it in the audit log. A password that breaks the policy rolls the transaction
+	// back, so the token can be used again.
+	var user models.User
+	err := ac.DB.Transaction(func(tx *gorm.DB) error {
+		userToken, err := consumeUserToken(tx, resetData.Token, models.TokenPurposePasswordReset)
+		if err != nil {
+			return err
+		}
+		if err := tx.First(&user, userToken.UserID).Error; err != nil {
+			return errInvalidUserToken
+		}
+		if !checkPasswordPolicy(c, resetData.Password, user.Name, user.Email) {
+			return errPasswordPolicy
+		}
+		if err := tx.Model(&user).Update("password", resetData.Password).Error; err != nil {
+			return err
+		}
+		if err := revokeUserAPIKeys(tx, user.ID); err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionPasswordReset, models.AuditEntityUser, user.ID, nil, nil)
+	})
+	if errors.Is(err, errPasswordPolicy) {
+		return
+	}
+	if errors.Is(err, errInvalidUserToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	// Sign out every existing session
+	if err := revokeUserTokens(ac.DB, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+}
+
+// VerifyEmail marks the user's email as verified using a verification token
+func (ac *AuthController) VerifyEmail(c *gin.Context) {
+	// Parse verification data
+	var verifyData struct {
+		Token string `json:"token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&verifyData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Consume the verification token
+	userToken, err := consumeUserToken(ac.DB, verifyData.Token, models.TokenPurposeEmailVerification)
+	if errors.Is(err, errInvalidUserToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	// Find user by ID
+	var user models.User
+	if err := ac.DB.First(&user, userToken.UserID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+		return
+	}
+
+	// Mark email as verified and record it in the audit log
+	before := user.ToResponse()
+	err = ac.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("email_verified_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionUpdate, models.AuditEntityUser, user.ID, before, user.ToResponse())
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	// Return user response
+	c.JSON(http.StatusOK, gin.H{"user": user.ToResponse()})
+}
+
+// ConfirmEmailChange switches the user's email to the pending address using an email change token
+func (ac *AuthController) ConfirmEmailChange(c *gin.Context) {
+	// Parse confirmation data
+	var confirmData struct {
+		Token string `json:"token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&confirmData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Consume the email change token and switch its owner to the confirmed address, recording
+	// it in the audit log. If the address was taken since the change was requested, the
+	// transaction rolls back, so the token isn't used up.
+	var user models.User
+	var oldEmail string
+	err := ac.DB.Transaction(func(tx *gorm.DB) error {
+		userToken, err := consumeUserToken(tx, confirmData.Token, models.TokenPurposeEmailChange)
+		if err != nil {
+			return err
+		}
+		if err := tx.First(&user, userToken.UserID).Error; err != nil || user.PendingEmail == "" {
+			return errInvalidUserToken
+		}
+
+		var existingUser models.User
+		if err := tx.Where("email = ?", user.PendingEmail).First(&existingUser).Error; err == nil {
+			return errEmailTaken
+		}
+
+		before := user.ToResponse()
+		oldEmail = user.Email
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"email":             user.PendingEmail,
+			"pending_email":     "",
+			"email_verified_at": time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionUpdate, models.AuditEntityUser, user.ID, before, user.ToResponse())
+	})
+	if errors.Is(err, errInvalidUserToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired confirmation token"})
+		return
+	}
+	if isEmailTaken(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email"})
+		return
+	}
+
+	// Let the previous address know, in case the change wasn't made by its owner
+	if err := sendEmailChangedNotice(ac.Mailer, &user, oldEmail); err != nil {
+		log.Printf("Failed to send email change notice: %v", err)
+	}
+
+	// Return user response
+	c.JSON(http.StatusOK, gin.H{"user": user.ToResponse()})
+}
+
+// ResendVerification emails a new verification link to the current user.
+// Requests are throttled per user.
+func (ac *AuthController) ResendVerification(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Find user by ID
+	var user models.User
+	if err := ac.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.EmailVerifiedAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email already verified"})
+		return
+	}
+
+	// Throttle based on when the last verification email was sent
+	var lastToken models.UserToken
+	err := ac.DB.Where("user_id = ? AND purpose = ?", user.ID, models.TokenPurposeEmailVerification).
+		Order("created_at DESC").
+		First(&lastToken).Error
+	if err == nil {
+		if wait := time.Until(lastToken.CreatedAt.Add(verificationResendInterval())); wait > 0 {
+			middleware.SetRetryAfter(c, wait)
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Please wait before requesting another verification email"})
+			return
+		}
+	}
+
+	// Send verification email
+	if err := sendVerificationEmail(ac.DB, ac.Mailer, &user); err != nil {
+		log.Printf("Failed to send verification email: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
+// verificationResendInterval returns the minimum time between verification emails (EMAIL_VERIFICATION_RESEND_INTERVAL, default 1 minute)
+func verificationResendInterval() time.Duration {
+	if interval, err := time.ParseDuration(os.Getenv("EMAIL_VERIFICATION_RESEND_INTERVAL")); err == nil && interval > 0 {
+		return interval
+	}
+	return time.Minute
+}
+
+// JWKS publishes the public keys used to verify access tokens
+func (ac *AuthController) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, middleware.Keys().JWKS())
+}
+
+// passwordResetTTL returns how long reset tokens are valid (PASSWORD_RESET_TTL, default 1 hour)
+func passwordResetTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("PASSWORD_RESET_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return time.Hour
+}
+
+// endSession ends the session of a refresh token family, logging failures
+func (ac *AuthController) endSession(sessionID string) {
+	if err := endSession(ac.DB, sessionID); err != nil {
+		log.Printf("Failed to end session %s: %v", sessionID, err)
+	}
+}
@@ -0,0 +1,481 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"backend/config"
+	"backend/models"
+)
+
+// maxCategoryNameLength limits category names
+const maxCategoryNameLength = 100
+
+// errUnknownCategory is returned when assigning a category that doesn't exist
+var errUnknownCategory = errors.New("unknown category")
+
+// errCategoryNameTaken is returned when another category under the same parent has the name
+var errCategoryNameTaken = errors.New("category name taken")
+
+// errCategoryCycle is returned when moving a category under itself or its subcategories
+var errCategoryCycle = errors.New("category moved under itself")
+
+// categoryRequest is the body for creating or replacing a category; without a parent
+// the category is a root category
+type categoryRequest struct {
+	Name     string `json:"name" binding:"required"`
+	ParentID *uint  `json:"parentId"`
+}
+
+// CategoryController handles product category operations
+type CategoryController struct {
+	DB *gorm.DB
+}
+
+// NewCategoryController creates a new CategoryController
+func NewCategoryController() *CategoryController {
+	return &CategoryController{
+		DB: config.GetDB(),
+	}
+}
+
+// ListCategories returns the category tree, with siblings sorted by name
+func (cc *CategoryController) ListCategories(c *gin.Context) {
+	var categories []models.Category
+	if err := cc.DB.Order("LOWER(name)").Order("id").Find(&categories).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get categories"})
+		return
+	}
+
+	// Group the categories by parent, 0 being the root
+	children := make(map[uint][]models.Category)
+	for _, category := range categories {
+		var parentID uint
+		if category.ParentID != nil {
+			parentID = *category.ParentID
+		}
+		children[parentID] = append(children[parentID], category)
+	}
+
+	var tree func(parentID uint) []models.CategoryNode
+	tree = func(parentID uint) []models.CategoryNode {
+		nodes := make([]models.CategoryNode, 0, len(children[parentID]))
+		for _, category := range children[parentID] {
+			nodes = append(nodes, models.CategoryNode{ID: category.ID, Name: category.Name, Children: tree(category.ID)})
+		}
+		return nodes
+	}
+
+	c.JSON(http.StatusOK, gin.H{"categories": tree(0)})
+}
+
+// GetCategory gets a category with its breadcrumbs and the categories right under it
+func (cc *CategoryController) GetCategory(c *gin.Context) {
+	category, ok := cc.findCategory(c)
+	if !ok {
+		return
+	}
+
+	var children []models.Category
+	if err := cc.DB.Where("parent_id = ?", category.ID).Order("LOWER(name)").Order("id").Find(&children).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get category"})
+		return
+	}
+
+	childRefs := make([]models.CategoryRef, 0, len(children))
+	for _, child := range children {
+		childRefs = append(childRefs, models.CategoryRef{ID: child.ID, Name: child.Name})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"category": category.ToResponse(), "children": childRefs})
+}
+
+// CreateCategory creates a category, under parentId if given
+func (cc *CategoryController) CreateCategory(c *gin.Context) {
+	var request categoryRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	name, ok := validCategoryName(c, request.Name)
+	if !ok {
+		return
+	}
+	parent, ok := cc.findParent(c, request.ParentID)
+	if !ok {
+		return
+	}
+
+	// Create the category, then set its path, which contains its ID
+	category := models.Category{Name: name, ParentID: request.ParentID}
+	err := cc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkSiblingName(tx, request.ParentID, name, 0); err != nil {
+			return err
+		}
+		if err := tx.Create(&category).Error; err != nil {
+			return err
+		}
+		placeCategory(&category, parent)
+		if err := tx.Model(&category).Update("path", category.Path).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionCreate, models.AuditEntityCategory, category.ID, nil, category.ToResponse())
+	})
+	if errors.Is(err, errCategoryNameTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A category with this name already exists here"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create category"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"category": category.ToResponse()})
+}
+
+// UpdateCategory renames a category and moves it, with everything under it, to parentId
+// (the root if not given)
+func (cc *CategoryController) UpdateCategory(c *gin.Context) {
+	category, ok := cc.findCategory(c)
+	if !ok {
+		return
+	}
+
+	var request categoryRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	name, ok := validCategoryName(c, request.Name)
+	if !ok {
+		return
+	}
+	parent, ok := cc.findParent(c, request.ParentID)
+	if !ok {
+		return
+	}
+	if parent != nil && category.IsAncestorOf(parent) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A category can't be moved under itself or its subcategories"})
+		return
+	}
+
+	// Save the category and move the categories under it along
+	var before models.CategoryResponse
+	err := cc.DB.Transaction(func(tx *gorm.DB) error {
+		// Check the move again on the current paths, locked until the move is saved, so two
+		// concurrent moves can't put categories under each other
+		if err := lockCategories(tx, category, parent); err != nil {
+			return err
+		}
+		if parent != nil && category.IsAncestorOf(parent) {
+			return errCategoryCycle
+		}
+
+		// Keep a snapshot for the audit log
+		before = category.ToResponse()
+
+		oldPath := category.Path
+		category.Name = name
+		placeCategory(category, parent)
+
+		if err := checkSiblingName(tx, category.ParentID, name, category.ID); err != nil {
+			return err
+		}
+		if err := tx.Save(category).Error; err != nil {
+			return err
+		}
+		if category.Path != oldPath {
+			if err := tx.Model(&models.Category{}).Where("path LIKE ? AND id <> ?", oldPath+"%", category.ID).
+				Update("path", gorm.Expr("? || SUBSTR(path, ?)", category.Path, len(oldPath)+1)).Error; err != nil {
+				return err
+			}
+		}
+		return recordAudit(tx, c, models.AuditActionUpdate, models.AuditEntityCategory, category.ID, before, category.ToResponse())
+	})
+	if errors.Is(err, errCategoryCycle) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A category can't be moved under itself or its subcategories"})
+		return
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return
+	}
+	if errors.Is(err, errCategoryNameTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A category with this name already exists here"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update category"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"category": category.ToResponse()})
+}
+
+// DeleteCategory deletes a category without subcategories, removing it from its products
+func (cc *CategoryController) DeleteCategory(c *gin.Context) {
+	category, ok := cc.findCategory(c)
+	if !ok {
+		return
+	}
+
+	var children int64
+	if err := cc.DB.Model(&models.Category{}).Where("parent_id = ?", category.ID).Count(&children).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
+		return
+	}
+	if children > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Move or delete the subcategories first"})
+		return
+	}
+
+	// Delete the category and record it in the audit log
+	err := cc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM product_categories WHERE category_id = ?", category.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(category).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionDelete, models.AuditEntityCategory, category.ID, category.ToResponse(), nil)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Category deleted successfully"})
+}
+
+// findCategory loads the category in the id URL parameter with its ancestors. On failure
+// the error response has been written.
+func (cc *CategoryController) findCategory(c *gin.Context) (*models.Category, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+		return nil, false
+	}
+
+	var category models.Category
+	if err := cc.DB.First(&category, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return nil, false
+	}
+	if err := loadCategoryAncestors(cc.DB, &category); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get category"})
+		return nil, false
+	}
+	return &category, true
+}
+
+// findParent loads the parent category with its ancestors; there's none for a root category.
+// On failure the error response has been written.
+func (cc *CategoryController) findParent(c *gin.Context, parentID *uint) (*models.Category, bool) {
+	if parentID == nil {
+		return nil, true
+	}
+
+	var parent models.Category
+	if err := cc.DB.First(&parent, *parentID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Parent category not found"})
+		return nil, false
+	}
+	if err := loadCategoryAncestors(cc.DB, &parent); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get parent category"})
+		return nil, false
+	}
+	return &parent, true
+}
+
+// validCategoryName trims a category name and checks its length. On failure the error
+// response has been written.
+func validCategoryName(c *gin.Context, name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxCategoryNameLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must have between 1 and " + strconv.Itoa(maxCategoryNameLength) + " characters"})
+		return "", false
+	}
+	return name, true
+}
+
+// checkSiblingName fails with errCategoryNameTaken if another category under the same
+// parent has the name, ignoring case
+func checkSiblingName(db *gorm.DB, parentID *uint, name string, excludeID uint) error {
+	query := db.Model(&models.Category{}).Where("LOWER(name) = ? AND id <> ?", strings.ToLower(name), excludeID)
+	if parentID == nil {
+		query = query.Where("parent_id IS NULL")
+	} else {
+		query = query.Where("parent_id = ?", *parentID)
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errCategoryNameTaken
+	}
+	return nil
+}
+
+// placeCategory puts a category under parent, or at the root if nil, setting its parent,
+// path and ancestors
+func placeCategory(category, parent *models.Category) {
+	if parent == nil {
+		category.ParentID = nil
+		category.Path = models.CategoryPath("", category.ID)
+		category.Ancestors = nil
+		return
+	}
+	category.ParentID = &parent.ID
+	category.Path = models.CategoryPath(parent.Path, category.ID)
+	category.Ancestors = append(append([]models.Category{}, parent.Ancestors...), *parent)
+}
+
+// lockCategories reloads the categories, skipping nil ones, with their ancestors, and locks
+// their rows until the transaction ends. Rows are locked in ID order so that concurrent
+// transactions don't deadlock. It fails with gorm.ErrRecordNotFound if one was deleted.
+func lockCategories(tx *gorm.DB, categories ...*models.Category) error {
+	var ids []uint
+	var loaded []*models.Category
+	for _, category := range categories {
+		if category != nil {
+			ids = append(ids, category.ID)
+			loaded = append(loaded, category)
+		}
+	}
+
+	var locked []models.Category
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN ?", ids).Order("id").Find(&locked).Error; err != nil {
+		return err
+	}
+	byID := make(map[uint]models.Category, len(locked))
+	for _, category := range locked {
+		byID[category.ID] = category
+	}
+	for _, category := range loaded {
+		current, ok := byID[category.ID]
+		if !ok {
+			return gorm.ErrRecordNotFound
+		}
+		*category = current
+	}
+
+	return loadCategoryAncestors(tx, loaded...)
+}
+
+// loadCategoryAncestors loads the ancestors of the categories, for their breadcrumbs
+func loadCategoryAncestors(db *gorm.DB, categories ...*models.Category) error {
+	var ids []uint
+	for _, category := range categories {
+		ids = append(ids, category.AncestorIDs()...)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var ancestors []models.Category
+	if err := db.Where("id IN ?", ids).Find(&ancestors).Error; err != nil {
+		return err
+	}
+	byID := make(map[uint]models.Category, len(ancestors))
+	for _, ancestor := range ancestors {
+		byID[ancestor.ID] = ancestor
+	}
+
+	for _, category := range categories {
+		category.Ancestors = nil
+		for _, id := range category.AncestorIDs() {
+			if ancestor, ok := byID[id]; ok {
+				category.Ancestors = append(category.Ancestors, ancestor)
+			}
+		}
+	}
+	return nil
+}
+
+// loadProductCategories loads the categories of the products, ordered by their place in the
+// tree, with the ancestors needed for the breadcrumbs
+func loadProductCategories(db *gorm.DB, products ...*models.Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+
+	productIndex := make(map[uint]int, len(products))
+	productIDs := make([]uint, 0, len(products))
+	for i, product := range products {
+		product.Categories = nil
+		productIndex[product.ID] = i
+		productIDs = append(productIDs, product.ID)
+	}
+
+	var links []struct {
+		ProductID  uint
+		CategoryID uint
+	}
+	if err := db.Table("product_categories").Where("product_id IN ?", productIDs).Scan(&links).Error; err != nil {
+		return err
+	}
+	if len(links) == 0 {
+		return nil
+	}
+
+	categoryProducts := make(map[uint][]uint)
+	categoryIDs := make([]uint, 0, len(links))
+	for _, link := range links {
+		if _, ok := categoryProducts[link.CategoryID]; !ok {
+			categoryIDs = append(categoryIDs, link.CategoryID)
+		}
+		categoryProducts[link.CategoryID] = append(categoryProducts[link.CategoryID], link.ProductID)
+	}
+
+	var categories []models.Category
+	if err := db.Where("id IN ?", categoryIDs).Order("path").Find(&categories).Error; err != nil {
+		return err
+	}
+	pointers := make([]*models.Category, len(categories))
+	for i := range categories {
+		pointers[i] = &categories[i]
+	}
+	if err := loadCategoryAncestors(db, pointers...); err != nil {
+		return err
+	}
+
+	for _, category := range categories {
+		for _, productID := range categoryProducts[category.ID] {
+			product := products[productIndex[productID]]
+			product.Categories = append(product.Categories, category)
+		}
+	}
+	return nil
+}
+
+// setProductCategories replaces the categories of a product, failing with
+// errUnknownCategory if one doesn't exist
+func setProductCategories(tx *gorm.DB, product *models.Product, categoryIDs []uint) error {
+	unique := make(map[uint]bool, len(categoryIDs))
+	for _, id := range categoryIDs {
+		unique[id] = true
+	}
+
+	categories := []models.Category{}
+	if len(unique) > 0 {
+		if err := tx.Where("id IN ?", categoryIDs).Find(&categories).Error; err != nil {
+			return err
+		}
+		if len(categories) != len(unique) {
+			return errUnknownCategory
+		}
+	}
+
+	if err := tx.Model(product).Association("Categories").Replace(categories); err != nil {
+		return err
+	}
+	return loadProductCategories(tx, product)
+}
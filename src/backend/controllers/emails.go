@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+
+	"backend/mailer"
+	"backend/models"
+)
+
+// emailVerificationTTL returns how long verification links are valid (EMAIL_VERIFICATION_TTL, default 48 hours)
+func emailVerificationTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("EMAIL_VERIFICATION_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return 48 * time.Hour
+}
+
+// sendVerificationEmail creates a verification token and emails the verification link to the user
+func sendVerificationEmail(db *gorm.DB, m mailer.Mailer, user *models.User) error {
+	token, err := createUserToken(db, user.ID, models.TokenPurposeEmailVerification, emailVerificationTTL())
+	if err != nil {
+		return err
+	}
+
+	link := appURL() + "/verify-email?token=" + url.QueryEscape(token)
+	return m.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hello %s,\n\nPlease confirm your email address by opening the link below. It expires in %s.\n\n%s\n",
+			user.Name, emailVerificationTTL(), link),
+	})
+}
+
+// sendPasswordResetEmail creates a password reset token and emails the reset link to the user
+func sendPasswordResetEmail(db *gorm.DB, m mailer.Mailer, user *models.User) error {
+	token, err := createUserToken(db, user.ID, models.TokenPurposePasswordReset, passwordResetTTL())
+	if err != nil {
+		return err
+	}
+
+	link := appURL() + "/reset-password?token=" + url.QueryEscape(token)
+	return m.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\nUse the link below to choose a new password. It expires in %s.\n\n%s\n\nIf you did not ask for a password reset, you can ignore this email.\n",
+			user.Name, passwordResetTTL(), link),
+	})
+}
+
+// sendEmailChangeConfirmation creates an email change token and emails the confirmation link
+// to the user's pending address
+func sendEmailChangeConfirmation(db *gorm.DB, m mailer.Mailer, user *models.User) error {
+	token, err := createUserToken(db, user.ID, models.TokenPurposeEmailChange, emailVerificationTTL())
+	if err != nil {
+		return err
+	}
+
+	link := appURL() + "/confirm-email-change?token=" + url.QueryEscape(token)
+	return m.Send(mailer.Message{
+		To:      user.PendingEmail,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Hello %s,\n\nPlease confirm that you want to use this address for your account by opening the link below. It expires in %s.\n\n%s\n\nYour email address won't change until you confirm.\n",
+			user.Name, emailVerificationTTL(), link),
+	})
+}
+
+// sendEmailChangedNotice tells the previous address that the account's email was changed
+func sendEmailChangedNotice(m mailer.Mailer, user *models.User, oldEmail string) error {
+	return m.Send(mailer.Message{
+		To:      oldEmail,
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf("Hello %s,\n\nThe email address of your account was changed from %s to %s.\n\nIf you didn't make this change, contact support immediately.\n",
+			user.Name, oldEmail, user.Email),
+	})
+}
+
+// sendAccountDeletionNotice tells the user when their account will be deleted and that signing in keeps it
+func sendAccountDeletionNotice(m mailer.Mailer, user *models.User) error {
+	return m.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Your account will be deleted",
+		Body: fmt.Sprintf("Hello %s,\n\nYour account and personal data will be deleted on %s.\n\nIf you want to keep your account, sign in before then; signing in cancels the deletion. You have been signed out everywhere and your API keys were revoked, so create new keys for anything that still needs one.\n",
+			user.Name, user.DeleteAfter.Format(time.RFC1123)),
+	})
+}
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"backend/config"
+)
+
+// HealthController reports the health of the server
+type HealthController struct {
+	DB *gorm.DB
+}
+
+// NewHealthController creates a new HealthController
+func NewHealthController() *HealthController {
+	return &HealthController{
+		DB: config.GetDB(),
+	}
+}
+
+// Health reports whether the server is healthy, without details, for load balancers and
+// monitoring. It responds with 503 when the database is unreachable or a critical check failed.
+func (hc *HealthController) Health(c *gin.Context) {
+	status, _ := hc.runChecks(c)
+	c.JSON(status, gin.H{"status": healthStatusText(status)})
+}
+
+// HealthDetails reports the database status and which configuration checks passed (for admins)
+func (hc *HealthController) HealthDetails(c *gin.Context) {
+	status, checks := hc.runChecks(c)
+	c.JSON(status, gin.H{
+		"status": healthStatusText(status),
+		"checks": checks,
+	})
+}
+
+// runChecks checks the database connection and returns the HTTP status along with the
+// configuration checks and the database check
+func (hc *HealthController) runChecks(c *gin.Context) (int, []config.Check) {
+	status := http.StatusOK
+	checks := config.Checks()
+
+	// Check database connection
+	databaseCheck := config.Check{Name: "database_connection", Critical: true, Passed: true}
+	if sqlDB, err := hc.DB.DB(); err != nil || sqlDB.PingContext(c.Request.Context()) != nil {
+		databaseCheck.Passed = false
+		databaseCheck.Message = "database is unreachable"
+	}
+	checks = append(checks, databaseCheck)
+
+	for _, check := range checks {
+		if check.Critical && !check.Passed {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	return status, checks
+}
+
+// healthStatusText describes the health status
+func healthStatusText(status int) string {
+	if status != http.StatusOK {
+		return "unhealthy"
+	}
+	return "ok"
+}
@@ -0,0 +1,324 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Query parameters shared by paginated listings
+const (
+	pageParam     = "page"
+	pageSizeParam = "pageSize"
+	cursorParam   = "cursor"
+	sortParam     = "sort"
+)
+
+// sortKind is the type of a sortable field, used to decode cursor values
+type sortKind int
+
+const (
+	sortString sortKind = iota
+	sortNumber
+	sortTime
+)
+
+// sortField is a field a listing can be sorted by, named after its JSON field
+type sortField struct {
+	Column string
+	Kind   sortKind
+}
+
+// sortKey is one field of a requested sort
+type sortKey struct {
+	Name string
+	sortField
+	Desc bool
+}
+
+// listPage is the requested page of a listing: either a page number or a cursor
+type listPage struct {
+	Page     int
+	PageSize int
+	// Cursor holds the sort values of the last item of the previous page
+	Cursor []interface{}
+}
+
+// listCursor is the decoded form of a cursor; Sort ties it to the sort it was made for
+type listCursor struct {
+	Sort   string            `json:"s"`
+	Values []json.RawMessage `json:"v"`
+}
+
+// checkQueryParams rejects query parameters a listing doesn't know, so typos aren't
+// silently ignored. On failure the error response has been written.
+func checkQueryParams(c *gin.Context, allowed ...string) bool {
+	known := make(map[string]bool, len(allowed))
+	for _, name := range allowed {
+		known[name] = true
+	}
+
+	var unknown []string
+	for name := range c.Request.URL.Query() {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":         "Unknown query parameters: " + strings.Join(unknown, ", "),
+			"allowedParams": allowed,
+		})
+		return false
+	}
+	return true
+}
+
+// parseSort parses the sort parameter, a comma-separated list of fields prefixed with "-"
+// for descending order. The ID is appended as a tie-breaker so the order is stable.
+// On failure the error response has been written.
+func parseSort(c *gin.Context, fields map[string]sortField, defaultSort string) ([]sortKey, string, bool) {
+	spec := c.DefaultQuery(sortParam, defaultSort)
+
+	var keys []sortKey
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		desc := strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+
+		field, ok := fields[name]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":        fmt.Sprintf("Invalid sort field %q", name),
+				"sortableKeys": sortableNames(fields),
+			})
+			return nil, "", false
+		}
+		if seen[name] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Duplicate sort field %q", name)})
+			return nil, "", false
+		}
+		seen[name] = true
+		keys = append(keys, sortKey{Name: name, sortField: field, Desc: desc})
+	}
+
+	if !seen["id"] {
+		keys = append(keys, sortKey{Name: "id", sortField: sortField{Column: "id", Kind: sortNumber}})
+	}
+	return keys, spec, true
+}
+
+// sortableNames returns the names of the sortable fields in order
+func sortableNames(fields map[string]sortField) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parsePage parses page and pageSize, or a cursor from a previous page. A cursor only
+// works with the sort it was made for. On failure the error response has been written.
+func parsePage(c *gin.Context, keys []sortKey, sortSpec string, defaultSize, maxSize int) (listPage, bool) {
+	page := listPage{Page: 1}
+
+	pageSize, err := strconv.Atoi(c.DefaultQuery(pageSizeParam, strconv.Itoa(defaultSize)))
+	if err != nil || pageSize < 1 || pageSize > maxSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "pageSize must be between 1 and " + strconv.Itoa(maxSize)})
+		return page, false
+	}
+	page.PageSize = pageSize
+
+	encoded := c.Query(cursorParam)
+	if encoded == "" {
+		page.Page, err = strconv.Atoi(c.DefaultQuery(pageParam, "1"))
+		if err != nil || page.Page < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page"})
+			return page, false
+		}
+		return page, true
+	}
+
+	if c.Query(pageParam) != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use either page or cursor, not both"})
+		return page, false
+	}
+	page.Cursor, err = decodeCursor(encoded, keys, sortSpec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor: " + err.Error()})
+		return page, false
+	}
+	return page, true
+}
+
+// decodeCursor decodes a cursor made by encodeCursor into values for the sort keys
+func decodeCursor(encoded string, keys []sortKey, sortSpec string) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("malformed")
+	}
+
+	var cursor listCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, errors.New("malformed")
+	}
+	if cursor.Sort != sortSpec || len(cursor.Values) != len(keys) {
+		return nil, errors.New("made for a different sort")
+	}
+
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		var err error
+		switch key.Kind {
+		case sortString:
+			var s string
+			err = json.Unmarshal(cursor.Values[i], &s)
+			values[i] = s
+		case sortNumber:
+			var n float64
+			err = json.Unmarshal(cursor.Values[i], &n)
+			values[i] = n
+		case sortTime:
+			var t time.Time
+			err = json.Unmarshal(cursor.Values[i], &t)
+			values[i] = t
+		}
+		if err != nil {
+			return nil, errors.New("malformed")
+		}
+	}
+	return values, nil
+}
+
+// encodeCursor makes the cursor pointing after an item, taking the sort values from the
+// JSON fields of its response
+func encodeCursor(item interface{}, keys []sortKey, sortSpec string) (string, error) {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return "", err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", err
+	}
+
+	cursor := listCursor{Sort: sortSpec}
+	for _, key := range keys {
+		value, ok := fields[key.Name]
+		if !ok {
+			return "", fmt.Errorf("sort field %q not in response", key.Name)
+		}
+		cursor.Values = append(cursor.Values, value)
+	}
+
+	data, err = json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// applySort orders the query by the sort keys
+func applySort(query *gorm.DB, keys []sortKey) *gorm.DB {
+	for _, key := range keys {
+		if key.Desc {
+			query = query.Order(key.Column + " DESC")
+		} else {
+			query = query.Order(key.Column)
+		}
+	}
+	return query
+}
+
+// applyPage restricts the query to the requested page, fetching one extra row to tell
+// whether there's a next page
+func applyPage(query *gorm.DB, keys []sortKey, page listPage) *gorm.DB {
+	if page.Cursor == nil {
+		return query.Offset((page.Page - 1) * page.PageSize).Limit(page.PageSize + 1)
+	}
+
+	// Rows after the cursor: (a > x) OR (a = x AND b > y) OR ...
+	var clauses []string
+	var args []interface{}
+	for i, key := range keys {
+		var parts []string
+		for j := 0; j < i; j++ {
+			parts = append(parts, keys[j].Column+" = ?")
+			args = append(args, page.Cursor[j])
+		}
+		op := " > ?"
+		if key.Desc {
+			op = " < ?"
+		}
+		parts = append(parts, key.Column+op)
+		args = append(args, page.Cursor[i])
+		clauses = append(clauses, "("+strings.Join(parts, " AND ")+")")
+	}
+	return query.Where(strings.Join(clauses, " OR "), args...).Limit(page.PageSize + 1)
+}
+
+// paginationResponse describes the returned page; nextCursor is empty on the last page
+func paginationResponse(page listPage, total int64, nextCursor string) gin.H {
+	pagination := gin.H{
+		"pageSize":   page.PageSize,
+		"total":      total,
+		"nextCursor": nil,
+	}
+	if page.Cursor == nil {
+		pagination["page"] = page.Page
+		pagination["totalPages"] = (total + int64(page.PageSize) - 1) / int64(page.PageSize)
+	}
+	if nextCursor != "" {
+		pagination["nextCursor"] = nextCursor
+	}
+	return pagination
+}
+
+// parseTimeParam parses an optional RFC 3339 query parameter. On failure the error
+// response has been written.
+func parseTimeParam(c *gin.Context, name string) (*time.Time, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, true
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name + ", expected RFC 3339"})
+		return nil, false
+	}
+	return &t, true
+}
+
+// parseBoolParam parses an optional boolean query parameter. On failure the error
+// response has been written.
+func parseBoolParam(c *gin.Context, name string) (*bool, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, true
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name + ", expected true or false"})
+		return nil, false
+	}
+	return &b, true
+}
+
+// containsPattern returns a LIKE pattern matching values that contain s, lowercased and
+// with wildcards escaped; use it with "LOWER(column) LIKE ? ESCAPE '\'"
+func containsPattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(s))
+	return "%" + s + "%"
+}
@@ -0,0 +1,505 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp/totp"
+	"gorm.io/gorm"
+
+	"backend/config"
+	"backend/middleware"
+	"backend/models"
+	"backend/utils"
+)
+
+// Number of recovery codes generated for a user
+const recoveryCodeCount = 10
+
+// Failed MFA codes allowed per user within mfaFailureWindow
+const (
+	mfaFailureLimit  = 5
+	mfaFailureWindow = 5 * time.Minute
+)
+
+// Length in seconds of a TOTP time step
+const totpPeriod = 30
+
+// MFAController handles TOTP multi-factor authentication
+type MFAController struct {
+	DB *gorm.DB
+}
+
+// NewMFAController creates a new MFAController
+func NewMFAController() *MFAController {
+	return &MFAController{
+		DB: config.GetDB(),
+	}
+}
+
+// Setup generates a new TOTP secret for the current user after checking their password.
+// MFA is only enabled once the secret is confirmed with a valid code.
+func (mc *MFAController) Setup(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Parse setup data
+	var setupData struct {
+		CurrentPassword string `json:"currentPassword" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&setupData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Find user by ID
+	var user models.User
+	if err := mc.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.MFAEnabledAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "MFA is already enabled"})
+		return
+	}
+
+	// Check the current password, so a stolen token alone can't bind an authenticator
+	if !reauthenticate(c, mc.DB, &user, reauthData{CurrentPassword: setupData.CurrentPassword}) {
+		return
+	}
+
+	// Generate TOTP key
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      mfaIssuer(),
+		AccountName: user.Email,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate MFA secret"})
+		return
+	}
+
+	// Render the otpauth URI as a QR code PNG
+	img, err := key.Image(256, 256)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
+		return
+	}
+	var qr bytes.Buffer
+	if err := png.Encode(&qr, img); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
+		return
+	}
+
+	// Store the pending secret and record it in the audit log
+	err = mc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("mfa_secret", key.Secret()).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionMFASetup, models.AuditEntityUser, user.ID, nil, nil)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save MFA secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":     key.Secret(),
+		"otpauthUrl": key.URL(),
+		"qrCode":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(qr.Bytes()),
+	})
+}
+
+// Confirm enables MFA after checking the current password and a code for the pending secret,
+// and returns the recovery codes
+func (mc *MFAController) Confirm(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Parse confirmation data
+	var confirmData struct {
+		CurrentPassword string `json:"currentPassword" binding:"required"`
+		Code            string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&confirmData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Find user by ID
+	var user models.User
+	if err := mc.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.MFAEnabledAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "MFA is already enabled"})
+		return
+	}
+	if user.MFASecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "MFA setup has not been started"})
+		return
+	}
+
+	// Check the current password
+	if !reauthenticate(c, mc.DB, &user, reauthData{CurrentPassword: confirmData.CurrentPassword}) {
+		return
+	}
+
+	// Check code
+	step, ok := validateTOTP(confirmData.Code, user.MFASecret)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid MFA code"})
+		return
+	}
+
+	// Enable MFA, using up the code, and generate recovery codes
+	var codes []string
+	err := mc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{"mfa_enabled_at": time.Now(), "mfa_last_step": step}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = generateRecoveryCodes(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionMFAEnable, models.AuditEntityUser, user.ID, nil, nil)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable MFA"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":          user.ToResponse(),
+		"recoveryCodes": codes,
+	})
+}
+
+// RegenerateRecoveryCodes replaces the current user's recovery codes after checking an MFA code
+func (mc *MFAController) RegenerateRecoveryCodes(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Parse request data
+	var regenerateData struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&regenerateData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Find user by ID
+	var user models.User
+	if err := mc.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.MFAEnabledAt == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "MFA is not enabled"})
+		return
+	}
+
+	// Check code
+	if !checkMFACode(c, mc.DB, &user, regenerateData.Code, "") {
+		return
+	}
+
+	// Generate new recovery codes
+	var codes []string
+	err := mc.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = generateRecoveryCodes(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionRecoveryCodesRegenerate, models.AuditEntityUser, user.ID, nil, nil)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+// Disable turns off MFA for the current user after checking the password and an MFA or recovery code
+func (mc *MFAController) Disable(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Parse request data
+	var disableData struct {
+		Password     string `json:"password" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recoveryCode"`
+	}
+
+	if err := c.ShouldBindJSON(&disableData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Find user by ID
+	var user models.User
+	if err := mc.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.MFAEnabledAt == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "MFA is not enabled"})
+		return
+	}
+
+	// Check password, counting failures towards the login lockout
+	if !reauthenticate(c, mc.DB, &user, reauthData{CurrentPassword: disableData.Password}) {
+		return
+	}
+
+	// Check code
+	if !checkMFACode(c, mc.DB, &user, disableData.Code, disableData.RecoveryCode) {
+		return
+	}
+
+	// Remove secret and recovery codes
+	err := mc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{"mfa_secret": "", "mfa_enabled_at": nil}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionMFADisable, models.AuditEntityUser, user.ID, nil, nil)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable MFA"})
+		return
+	}
+
+	// Sign out the other sessions, which may have been opened by whoever got the second
+	// factor, and issue new tokens for this client
+	if err := revokeUserTokens(mc.DB, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke tokens"})
+		return
+	}
+	tokens, err := issueTokens(c, mc.DB, &user, "", false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":         user.ToResponse(),
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
+	})
+}
+
+// Verify completes a two-step login, exchanging the MFA token from Login and an MFA or recovery code for tokens
+func (mc *MFAController) Verify(c *gin.Context) {
+	// Parse verification data
+	var verifyData struct {
+		MFAToken     string `json:"mfaToken" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recoveryCode"`
+	}
+
+	if err := c.ShouldBindJSON(&verifyData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validate the MFA token
+	claims, err := middleware.ParseMFAToken(verifyData.MFAToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	// Find user by ID
+	var user models.User
+	if err := mc.DB.First(&user, claims.UserID).Error; err != nil || user.MFAEnabledAt == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	// Check code
+	if !checkMFACode(c, mc.DB, &user, verifyData.Code, verifyData.RecoveryCode) {
+		return
+	}
+
+	// The MFA token can only be exchanged once
+	if store := middleware.Revocations(); store != nil {
+		if err := store.RevokeToken(claims.ID, user.ID, claims.ExpiresAt.Time); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke MFA token"})
+			return
+		}
+	}
+
+	// Signing in again keeps an account whose deletion is pending
+	if err := cancelAccountDeletion(c, mc.DB, &user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore account"})
+		return
+	}
+
+	// Generate access and refresh tokens
+	tokens, err := issueTokens(c, mc.DB, &user, "", true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	// Return user response and tokens
+	c.JSON(http.StatusOK, gin.H{
+		"user":         user.ToResponse(),
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
+	})
+}
+
+// checkMFACode validates a TOTP code or, if given, a recovery code, which is then used up.
+// Attempts are limited per user; on failure the error response has been written.
+func checkMFACode(c *gin.Context, db *gorm.DB, user *models.User, code, recoveryCode string) bool {
+	if code == "" && recoveryCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "An MFA code or recovery code is required"})
+		return false
+	}
+
+	// Limit guesses, since a code only has a million possible values. The attempt is counted
+	// before the code is checked, so parallel guesses can't get past the limit.
+	failureKey := "mfa-failures:" + strconv.FormatUint(uint64(user.ID), 10)
+	counter, err := middleware.Counters().Increment(failureKey, mfaFailureWindow)
+	if err != nil {
+		log.Printf("Failed to count MFA attempt: %v", err)
+	} else if counter.Count > mfaFailureLimit {
+		middleware.SetRetryAfter(c, time.Until(counter.ExpiresAt))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many invalid MFA codes, please try again later"})
+		return false
+	}
+
+	valid := false
+	if code != "" {
+		step, ok := validateTOTP(code, user.MFASecret)
+		valid = ok && useTOTPStep(db, user.ID, step)
+	} else {
+		valid = useRecoveryCode(db, user.ID, recoveryCode)
+	}
+
+	if !valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid MFA code"})
+		return false
+	}
+
+	middleware.Counters().Reset(failureKey)
+	return true
+}
+
+// validateTOTP checks a TOTP code against the secret, allowing one period of clock skew either
+// way, and returns the time step the code belongs to
+func validateTOTP(code, secret string) (int64, bool) {
+	code = strings.TrimSpace(code)
+	now := time.Now().Unix() / totpPeriod
+	for step := now - 1; step <= now+1; step++ {
+		expected, err := totp.GenerateCode(secret, time.Unix(step*totpPeriod, 0))
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(expected)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// useTOTPStep records the time step of an accepted TOTP code, returning false if a code of
+// that step or a later one was already used, so a code can't be replayed while it's valid
+func useTOTPStep(db *gorm.DB, userID uint, step int64) bool {
+	result := db.Model(&models.User{}).
+		Where("id = ? AND mfa_last_step < ?", userID, step).
+		Update("mfa_last_step", step)
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// generateRecoveryCodes replaces the user's recovery codes and returns the new codes
+func generateRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+
+		// Format 10 base32 characters as xxxxx-xxxxx for readability
+		encoded := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+		code := encoded[:5] + "-" + encoded[5:10]
+		recoveryCode := models.RecoveryCode{
+			UserID:   userID,
+			CodeHash: utils.HashToken(code),
+		}
+		if err := tx.Create(&recoveryCode).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// useRecoveryCode marks an unused recovery code of the user as used, returning false if there is none
+func useRecoveryCode(db *gorm.DB, userID uint, code string) bool {
+	hash := utils.HashToken(strings.ToLower(strings.TrimSpace(code)))
+	result := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// mfaIssuer returns the issuer name shown in authenticator apps (MFA_ISSUER, default "ProductApp")
+func mfaIssuer() string {
+	if issuer := os.Getenv("MFA_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "ProductApp"
+}
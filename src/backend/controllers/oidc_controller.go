@@ -0,0 +1,472 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+
+	"backend/config"
+	"backend/middleware"
+	"backend/models"
+	"backend/utils"
+)
+
+// oidcLoginTTL is how long a user has to sign in at the identity provider
+const oidcLoginTTL = 10 * time.Minute
+
+// oidcExchangeTTL is how long the frontend has to exchange the login code for tokens
+const oidcExchangeTTL = time.Minute
+
+// oidcStateCookie binds a login to the browser that started it
+const oidcStateCookie = "oidc_state"
+
+// errEmailNotVerified is returned when the identity provider doesn't vouch for the user's email
+var errEmailNotVerified = errors.New("email not verified by the identity provider")
+
+// OIDCController handles login through external OpenID Connect identity providers
+type OIDCController struct {
+	DB *gorm.DB
+
+	mu sync.Mutex
+	// providers caches the discovery documents, by provider name
+	providers map[string]*oidc.Provider
+}
+
+// oidcClaims holds the ID token claims used to find or create the user
+type oidcClaims struct {
+	Email string `json:"email"`
+	// EmailVerified is a boolean, but some providers send it as a string
+	EmailVerified interface{} `json:"email_verified"`
+	Name          string      `json:"name"`
+}
+
+// NewOIDCController creates a new OIDC controller
+func NewOIDCController() *OIDCController {
+	return &OIDCController{
+		DB:        config.GetDB(),
+		providers: make(map[string]*oidc.Provider),
+	}
+}
+
+// ListProviders lists the configured identity providers
+func (oc *OIDCController) ListProviders(c *gin.Context) {
+	providers := []gin.H{}
+	for _, provider := range config.OIDCProviders() {
+		providers = append(providers, gin.H{
+			"name":        provider.Name,
+			"displayName": provider.DisplayName,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"providers": providers})
+}
+
+// Login redirects the user to the identity provider to sign in
+func (oc *OIDCController) Login(c *gin.Context) {
+	// Find the provider
+	provider, ok := config.FindOIDCProvider(c.Query("provider"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown identity provider"})
+		return
+	}
+
+	oauthConfig, _, err := oc.client(provider)
+	if err != nil {
+		log.Printf("Failed to discover identity provider %s: %v", provider.Name, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider unavailable"})
+		return
+	}
+
+	// Generate the state, nonce and PKCE code verifier
+	state, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	nonce, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	codeVerifier, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	// Drop logins that were never completed
+	if err := oc.DB.Where("expires_at < ?", time.Now()).Delete(&models.OIDCLoginState{}).Error; err != nil {
+		log.Printf("Failed to delete expired OIDC login states: %v", err)
+	}
+
+	// Keep the secrets until the provider redirects back
+	loginState := models.OIDCLoginState{
+		StateHash:    utils.HashToken(state),
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    time.Now().Add(oidcLoginTTL),
+	}
+	if err := oc.DB.Create(&loginState).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, int(oidcLoginTTL.Seconds()), "/", "", c.Request.TLS != nil, true)
+
+	c.Redirect(http.StatusFound, oauthConfig.AuthCodeURL(state,
+		oidc.Nonce(nonce),
+		oauth2.SetAuthURLParam("code_challenge", pkceChallenge(codeVerifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	))
+}
+
+// Callback completes the login when the identity provider redirects back, then
+// sends the user to the frontend with a short-lived code to exchange for tokens
+func (oc *OIDCController) Callback(c *gin.Context) {
+	// The provider reports failures, e.g. a cancelled login, in the error parameter
+	if providerErr := c.Query("error"); providerErr != "" {
+		oc.redirectToApp(c, "oidcError", providerErr)
+		return
+	}
+
+	// Check that the login was started by this browser
+	state := c.Query("state")
+	cookie, _ := c.Cookie(oidcStateCookie)
+	c.SetCookie(oidcStateCookie, "", -1, "/", "", c.Request.TLS != nil, true)
+	if state == "" || cookie != state {
+		oc.redirectToApp(c, "oidcError", "invalid_state")
+		return
+	}
+
+	loginState, err := oc.consumeLoginState(state)
+	if err != nil {
+		oc.redirectToApp(c, "oidcError", "invalid_state")
+		return
+	}
+
+	provider, ok := config.FindOIDCProvider(loginState.Provider)
+	if !ok {
+		oc.redirectToApp(c, "oidcError", "invalid_state")
+		return
+	}
+
+	oauthConfig, verifier, err := oc.client(provider)
+	if err != nil {
+		log.Printf("Failed to discover identity provider %s: %v", provider.Name, err)
+		oc.redirectToApp(c, "oidcError", "provider_unavailable")
+		return
+	}
+
+	// Exchange the code, proving with the verifier that we started the login
+	ctx := c.Request.Context()
+	token, err := oauthConfig.Exchange(ctx, c.Query("code"), oauth2.SetAuthURLParam("code_verifier", loginState.CodeVerifier))
+	if err != nil {
+		log.Printf("OIDC code exchange with %s failed: %v", provider.Name, err)
+		oc.redirectToApp(c, "oidcError", "login_failed")
+		return
+	}
+
+	// Verify the ID token
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		log.Printf("OIDC token response from %s has no ID token", provider.Name)
+		oc.redirectToApp(c, "oidcError", "login_failed")
+		return
+	}
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil || idToken.Nonce != loginState.Nonce {
+		log.Printf("Invalid ID token from %s: %v", provider.Name, err)
+		oc.redirectToApp(c, "oidcError", "login_failed")
+		return
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		oc.redirectToApp(c, "oidcError", "login_failed")
+		return
+	}
+
+	// Find, link or create the user
+	user, err := oc.findOrProvisionUser(c, provider, idToken.Subject, claims)
+	if errors.Is(err, errEmailNotVerified) {
+		oc.redirectToApp(c, "oidcError", "email_not_verified")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to sign in user from %s: %v", provider.Name, err)
+		oc.redirectToApp(c, "oidcError", "login_failed")
+		return
+	}
+
+	// Tokens never appear in the URL; the frontend exchanges this code for them
+	code, err := createUserToken(oc.DB, user.ID, models.TokenPurposeOIDCLogin, oidcExchangeTTL)
+	if err != nil {
+		oc.redirectToApp(c, "oidcError", "login_failed")
+		return
+	}
+
+	oc.redirectToApp(c, "oidcCode", code)
+}
+
+// Exchange trades the code from the callback for the app's tokens
+func (oc *OIDCController) Exchange(c *gin.Context) {
+	// Parse exchange data
+	var exchangeData struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&exchangeData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validate and consume the code
+	userToken, err := consumeUserToken(oc.DB, exchangeData.Code, models.TokenPurposeOIDCLogin)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired code"})
+		return
+	}
+
+	// Find user by ID
+	var user models.User
+	if err := oc.DB.First(&user, userToken.UserID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired code"})
+		return
+	}
+
+	// With MFA enabled, the identity provider only replaces the password step
+	if user.MFAEnabledAt != nil {
+		mfaToken, err := middleware.GenerateMFAToken(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"mfaRequired": true,
+			"mfaToken":    mfaToken,
+		})
+		return
+	}
+
+	// Signing in again keeps an account whose deletion is pending
+	if err := cancelAccountDeletion(c, oc.DB, &user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore account"})
+		return
+	}
+
+	// Generate access and refresh tokens
+	tokens, err := issueTokens(c, oc.DB, &user, "", false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	// Return user response and tokens
+	c.JSON(http.StatusOK, gin.H{
+		"user":         user.ToResponse(),
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
+	})
+}
+
+// client returns the OAuth2 configuration and ID token verifier of a provider,
+// fetching its discovery document on first use
+func (oc *OIDCController) client(provider config.OIDCProvider) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	discovered, ok := oc.providers[provider.Name]
+	if !ok {
+		// The provider keeps this context to refresh its signing keys, so it must outlive the request
+		ctx := oidc.ClientContext(context.Background(), &http.Client{Timeout: 10 * time.Second})
+
+		var err error
+		discovered, err = oidc.NewProvider(ctx, provider.Issuer)
+		if err != nil {
+			return nil, nil, err
+		}
+		oc.providers[provider.Name] = discovered
+	}
+
+	endpoint := discovered.Endpoint()
+	if provider.AuthURL != "" {
+		endpoint.AuthURL = provider.AuthURL
+	}
+
+	oauthConfig := &oauth2.Config{
+		ClientID:     provider.ClientID,
+		ClientSecret: provider.ClientSecret,
+		RedirectURL:  provider.RedirectURL,
+		Endpoint:     endpoint,
+		Scopes:       provider.Scopes,
+	}
+	verifier := discovered.Verifier(&oidc.Config{ClientID: provider.ClientID})
+
+	return oauthConfig, verifier, nil
+}
+
+// consumeLoginState deletes the login state and returns it, so a state can only be used once
+func (oc *OIDCController) consumeLoginState(state string) (*models.OIDCLoginState, error) {
+	var loginState models.OIDCLoginState
+	stateHash := utils.HashToken(state)
+	if err := oc.DB.Where("state_hash = ? AND expires_at > ?", stateHash, time.Now()).First(&loginState).Error; err != nil {
+		return nil, err
+	}
+
+	result := oc.DB.Where("state_hash = ?", stateHash).Delete(&models.OIDCLoginState{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return &loginState, nil
+}
+
+// findOrProvisionUser returns the user linked to the provider account. An unlinked
+// account is linked to the user with the same email, or a new user is created.
+func (oc *OIDCController) findOrProvisionUser(c *gin.Context, provider config.OIDCProvider, subject string, claims oidcClaims) (*models.User, error) {
+	// Already linked
+	var identity models.UserIdentity
+	err := oc.DB.Where("provider = ? AND subject = ?", provider.Name, subject).First(&identity).Error
+	if err == nil {
+		var user models.User
+		if err := oc.DB.First(&user, identity.UserID).Error; err != nil {
+			return nil, err
+		}
+		return &user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// Only an email the provider vouches for may be matched to an account
+	email := strings.TrimSpace(claims.Email)
+	if email == "" || !(provider.TrustEmail || isTrue(claims.EmailVerified)) {
+		return nil, errEmailNotVerified
+	}
+
+	var user models.User
+	takeover := false
+	err = oc.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		err := tx.Where("email = ?", email).First(&user).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			// Provision a new user; the random password is never shown, so only the provider can sign in
+			password, err := utils.GenerateRandomToken(32)
+			if err != nil {
+				return err
+			}
+
+			name := strings.TrimSpace(claims.Name)
+			if name == "" {
+				name = email
+			}
+
+			user = models.User{
+				Name:            name,
+				Email:           email,
+				Password:        password,
+				Role:            models.RoleUser,
+				EmailVerifiedAt: &now,
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+			if err := recordAudit(tx, c, models.AuditActionCreate, models.AuditEntityUser, user.ID, nil, user.ToResponse()); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case user.EmailVerifiedAt == nil:
+			// The unverified account may have been registered by someone else with this email,
+			// so its password, MFA and API keys can't be trusted
+			takeover = true
+			before := user.ToResponse()
+			password, err := utils.GenerateRandomToken(32)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&user).Updates(map[string]interface{}{
+				"password":          password,
+				"email_verified_at": now,
+				"mfa_secret":        "",
+				"mfa_enabled_at":    nil,
+			}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+				return err
+			}
+			if err := revokeUserAPIKeys(tx, user.ID); err != nil {
+				return err
+			}
+			if err := recordAudit(tx, c, models.AuditActionUpdate, models.AuditEntityUser, user.ID, before, user.ToResponse()); err != nil {
+				return err
+			}
+		}
+
+		// Link the provider account
+		identity = models.UserIdentity{
+			UserID:   user.ID,
+			Provider: provider.Name,
+			Subject:  subject,
+			Email:    email,
+		}
+		return tx.Create(&identity).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Sign out whoever was using the unverified account
+	if takeover {
+		if err := revokeUserTokens(oc.DB, user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// redirectToApp sends the browser back to the frontend login page with the result in the fragment
+func (oc *OIDCController) redirectToApp(c *gin.Context, key, value string) {
+	fragment := url.Values{key: {value}}.Encode()
+	c.Redirect(http.StatusFound, appURL()+"/login#"+fragment)
+}
+
+// pkceChallenge derives the S256 PKCE code challenge from a code verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// isTrue reports whether a claim is the boolean true or the string "true"
+func isTrue(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/passwords"
+)
+
+// errPasswordPolicy is returned from a transaction to roll it back once checkPasswordPolicy
+// has written its error response
+var errPasswordPolicy = errors.New("password does not meet the password policy")
+
+// checkPasswordPolicy validates a new password of the user with the given name and email
+// against the password policy, writing the error response with every broken rule if it fails
+func checkPasswordPolicy(c *gin.Context, password, name, email string) bool {
+	violations := passwords.CurrentPolicy().Validate(password, name, email)
+	if len(violations) == 0 {
+		return true
+	}
+
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":          "Password does not meet the password policy",
+		"passwordErrors": violations,
+	})
+	return false
+}
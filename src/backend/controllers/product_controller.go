@@ -1,17 +1,35 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"backend/config"
 	"backend/models"
 	"backend/utils"
 )
 
+// Product list page sizes
+const (
+	defaultProductPageSize = 20
+	maxProductPageSize     = 100
+)
+
+// productSortFields are the fields products can be sorted by
+var productSortFields = map[string]sortField{
+	"id":        {Column: "id", Kind: sortNumber},
+	"name":      {Column: "name", Kind: sortString},
+	"price":     {Column: "price", Kind: sortNumber},
+	"quantity":  {Column: "quantity", Kind: sortNumber},
+	"createdAt": {Column: "created_at", Kind: sortTime},
+	"updatedAt": {Column: "updated_at", Kind: sortTime},
+}
+
 // ProductController handles product-related operations
 type ProductController struct {
 	DB *gorm.DB
@@ -24,17 +42,43 @@ func NewProductController() *ProductController {
 	}
 }
 
-// CreateProduct creates a new product
+// CreateProduct creates a new product, in the categories listed in categoryIds and labeled
+// with tags
 func (pc *ProductController) CreateProduct(c *gin.Context) {
 	// Parse product data from form
-	var product models.Product
-	if err := c.ShouldBindJSON(&product); err != nil {
+	var productData struct {
+		models.Product
+		CategoryIDs []uint   `json:"categoryIds"`
+		Tags        []string `json:"tags"`
+	}
+	if err := c.ShouldBindJSON(&productData); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	// Create product
-	if err := pc.DB.Create(&product).Error; err != nil {
+	product := productData.Product
+
+	// Create product and record it in the audit log
+	err := pc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&product).Error; err != nil {
+			return err
+		}
+		if err := setProductCategories(tx, &product, productData.CategoryIDs); err != nil {
+			return err
+		}
+		if err := setProductTags(tx, c, &product, productData.Tags); err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionCreate, models.AuditEntityProduct, product.ID, nil, product.ToResponse())
+	})
+	if errors.Is(err, errUnknownCategory) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Category not found"})
+		return
+	}
+	if errors.Is(err, errInvalidTags) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product"})
 		return
 	}
@@ -43,21 +87,154 @@ func (pc *ProductController) CreateProduct(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{"product": product.ToResponse()})
 }
 
-// GetAllProducts gets all products
+// GetAllProducts lists products, paginated with page and pageSize or with the cursor from
+// the previous page. Products can be filtered by name (contains), category (including the
+// categories under it), tag (repeated for products with all of them), minPrice, maxPrice,
+// inStock and a createdFrom/createdTo range (RFC 3339), and sorted by a comma-separated
+// list of fields, prefixed with "-" for descending order. The facets count the products
+// matching the filters by tag, category and price range.
 func (pc *ProductController) GetAllProducts(c *gin.Context) {
+	// Reject unknown parameters
+	if !checkQueryParams(c, "name", "category", "tag", "minPrice", "maxPrice", "inStock", "createdFrom", "createdTo",
+		sortParam, pageParam, pageSizeParam, cursorParam) {
+		return
+	}
+
+	query := pc.DB.Model(&models.Product{})
+
+	// Apply filters
+	if name := c.Query("name"); name != "" {
+		query = query.Where(`LOWER(name) LIKE ? ESCAPE '\'`, containsPattern(name))
+	}
+	if value := c.Query("category"); value != "" {
+		var category models.Category
+		categoryID, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || pc.DB.First(&category, categoryID).Error != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category"})
+			return
+		}
+		query = query.Where(`id IN (SELECT product_categories.product_id FROM product_categories
+			JOIN categories ON categories.id = product_categories.category_id WHERE categories.path LIKE ?)`, category.Path+"%")
+	}
+	for _, tag := range c.QueryArray("tag") {
+		query = query.Where(`id IN (SELECT product_tags.product_id FROM product_tags
+			JOIN tags ON tags.id = product_tags.tag_id WHERE tags.name = ?)`, models.NormalizeTagName(tag))
+	}
+	var minPrice, maxPrice *float64
+	for _, param := range []struct {
+		name  string
+		value **float64
+	}{
+		{"minPrice", &minPrice},
+		{"maxPrice", &maxPrice},
+	} {
+		value := c.Query(param.name)
+		if value == "" {
+			continue
+		}
+		price, err := strconv.ParseFloat(value, 64)
+		if err != nil || price < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param.name})
+			return
+		}
+		*param.value = &price
+	}
+	if minPrice != nil && maxPrice != nil && *minPrice > *maxPrice {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "minPrice must not be greater than maxPrice"})
+		return
+	}
+	if minPrice != nil {
+		query = query.Where("price >= ?", *minPrice)
+	}
+	if maxPrice != nil {
+		query = query.Where("price <= ?", *maxPrice)
+	}
+	inStock, ok := parseBoolParam(c, "inStock")
+	if !ok {
+		return
+	}
+	if inStock != nil && *inStock {
+		query = query.Where("quantity > 0")
+	} else if inStock != nil {
+		query = query.Where("quantity = 0")
+	}
+	createdFrom, ok := parseTimeParam(c, "createdFrom")
+	if !ok {
+		return
+	}
+	if createdFrom != nil {
+		query = query.Where("created_at >= ?", *createdFrom)
+	}
+	createdTo, ok := parseTimeParam(c, "createdTo")
+	if !ok {
+		return
+	}
+	if createdTo != nil {
+		query = query.Where("created_at < ?", *createdTo)
+	}
+	matching := query.Session(&gorm.Session{}).Select("id")
+
+	// Parse sorting and pagination
+	keys, sortSpec, ok := parseSort(c, productSortFields, "id")
+	if !ok {
+		return
+	}
+	page, ok := parsePage(c, keys, sortSpec, defaultProductPageSize, maxProductPageSize)
+	if !ok {
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get products"})
+		return
+	}
+	facets, err := productFacets(pc.DB, matching)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get products"})
+		return
+	}
+
 	var products []models.Product
-	if err := pc.DB.Find(&products).Error; err != nil {
+	if err := applyPage(applySort(query, keys), keys, page).Find(&products).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get products"})
 		return
 	}
 
-	// Convert products to responses
-	var productResponses []models.ProductResponse
+	// The extra row only tells that there's a next page
+	hasMore := len(products) > page.PageSize
+	if hasMore {
+		products = products[:page.PageSize]
+	}
+
+	// Load details and convert products to responses
+	pointers := make([]*models.Product, len(products))
+	for i := range products {
+		pointers[i] = &products[i]
+	}
+	if err := loadProductDetails(pc.DB, pointers...); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get products"})
+		return
+	}
+	productResponses := make([]models.ProductResponse, 0, len(products))
 	for _, product := range products {
 		productResponses = append(productResponses, product.ToResponse())
 	}
 
-	c.JSON(http.StatusOK, gin.H{"products": productResponses})
+	nextCursor := ""
+	if hasMore {
+		nextCursor, err = encodeCursor(productResponses[len(productResponses)-1], keys, sortSpec)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get products"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"products":   productResponses,
+		"pagination": paginationResponse(page, total, nextCursor),
+		"facets":     facets,
+	})
 }
 
 // GetProductByID gets a product by ID
@@ -76,12 +253,28 @@ func (pc *ProductController) GetProductByID(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 		return
 	}
+	if err := loadProductDetails(pc.DB, &product); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get product"})
+		return
+	}
 
 	// Return product response
 	c.JSON(http.StatusOK, gin.H{"product": product.ToResponse()})
 }
 
-// UpdateProduct updates a product
+// loadProductDetails loads the categories, tags, options and variants shown with products
+func loadProductDetails(db *gorm.DB, products ...*models.Product) error {
+	if err := loadProductCategories(db, products...); err != nil {
+		return err
+	}
+	if err := loadProductTags(db, products...); err != nil {
+		return err
+	}
+	return loadProductVariants(db, products...)
+}
+
+// UpdateProduct updates a product; categoryIds and tags, if given, replace its categories
+// and tags
 func (pc *ProductController) UpdateProduct(c *gin.Context) {
 	// Get product ID from URL parameter
 	productID := c.Param("id")
@@ -100,10 +293,12 @@ func (pc *ProductController) UpdateProduct(c *gin.Context) {
 
 	// Parse update data
 	var updateData struct {
-		Name        string  `json:"name"`
-		Description string  `json:"description"`
-		Price       float64 `json:"price"`
-		Quantity    int     `json:"quantity"`
+		Name        string    `json:"name"`
+		Description string    `json:"description"`
+		Price       float64   `json:"price"`
+		Quantity    int       `json:"quantity"`
+		CategoryIDs *[]uint   `json:"categoryIds"`
+		Tags        *[]string `json:"tags"`
 	}
 
 	if err := c.ShouldBindJSON(&updateData); err != nil {
@@ -111,6 +306,13 @@ func (pc *ProductController) UpdateProduct(c *gin.Context) {
 		return
 	}
 
+	// Keep a snapshot for the audit log
+	if err := loadProductDetails(pc.DB, &product); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+		return
+	}
+	before := product.ToResponse()
+
 	// Update fields if provided
 	if updateData.Name != "" {
 		product.Name = updateData.Name
@@ -125,8 +327,32 @@ func (pc *ProductController) UpdateProduct(c *gin.Context) {
 		product.Quantity = updateData.Quantity
 	}
 
-	// Save product
-	if err := pc.DB.Save(&product).Error; err != nil {
+	// Save product and record the change in the audit log
+	err = pc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Save(&product).Error; err != nil {
+			return err
+		}
+		if updateData.CategoryIDs != nil {
+			if err := setProductCategories(tx, &product, *updateData.CategoryIDs); err != nil {
+				return err
+			}
+		}
+		if updateData.Tags != nil {
+			if err := setProductTags(tx, c, &product, *updateData.Tags); err != nil {
+				return err
+			}
+		}
+		return recordAudit(tx, c, models.AuditActionUpdate, models.AuditEntityProduct, product.ID, before, product.ToResponse())
+	})
+	if errors.Is(err, errUnknownCategory) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Category not found"})
+		return
+	}
+	if errors.Is(err, errInvalidTags) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
 		return
 	}
@@ -152,17 +378,45 @@ func (pc *ProductController) DeleteProduct(c *gin.Context) {
 		return
 	}
 
-	// Delete product's image if it exists
-	if product.ImagePath != "" {
-		utils.DeleteFile(product.ImagePath)
+	// Keep a snapshot for the audit log
+	if err := loadProductDetails(pc.DB, &product); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete product"})
+		return
 	}
-
-	// Delete product
-	if err := pc.DB.Delete(&product).Error; err != nil {
+	before := product.ToResponse()
+
+	// Delete product with its variants, removing it from its categories and tags, and record
+	// it in the audit log
+	err = pc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := deleteProductVariants(tx, product.ID); err != nil {
+			return err
+		}
+		if err := tx.Model(&product).Association("Categories").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Model(&product).Association("Tags").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Delete(&product).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionDelete, models.AuditEntityProduct, product.ID, before, nil)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete product"})
 		return
 	}
 
+	// Delete product's and variants' images if they exist
+	if product.ImagePath != "" {
+		utils.DeleteFile(product.ImagePath)
+	}
+	for _, variant := range product.Variants {
+		if variant.ImagePath != "" {
+			utils.DeleteFile(variant.ImagePath)
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Product deleted successfully"})
 }
 
@@ -197,6 +451,13 @@ func (pc *ProductController) UploadProductImage(c *gin.Context) {
 		return
 	}
 
+	// Keep a snapshot for the audit log
+	if err := loadProductDetails(pc.DB, &product); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+		return
+	}
+	before := product.ToResponse()
+
 	// Delete old image if it exists
 	if product.ImagePath != "" {
 		utils.DeleteFile(product.ImagePath)
@@ -209,13 +470,19 @@ func (pc *ProductController) UploadProductImage(c *gin.Context) {
 		return
 	}
 
-	// Update product with new image path
+	// Update product with new image path and record the change in the audit log
 	product.ImagePath = imagePath
-	if err := pc.DB.Save(&product).Error; err != nil {
+	err = pc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Save(&product).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionUpdate, models.AuditEntityProduct, product.ID, before, product.ToResponse())
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
 		return
 	}
 
 	// Return product response
 	c.JSON(http.StatusOK, gin.H{"product": product.ToResponse()})
-} 
\ No newline at end of file
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"backend/models"
+)
+
+// maxTagFacets limits the tag facets to the most used tags
+const maxTagFacets = 50
+
+// priceFacetBounds are the bounds between the price facet ranges
+var priceFacetBounds = []float64{50, 100, 200, 500}
+
+// productFacets counts the products selected by productIDs, a subquery returning product
+// IDs, by tag, by category (including the categories under it, like the category filter)
+// and by price range
+func productFacets(db *gorm.DB, productIDs *gorm.DB) (models.ProductFacets, error) {
+	facets := models.ProductFacets{
+		Tags:       []models.TagFacet{},
+		Categories: []models.CategoryFacet{},
+		Prices:     []models.PriceFacet{},
+	}
+
+	if err := db.Table("product_tags").
+		Select("tags.name, COUNT(*) AS count").
+		Joins("JOIN tags ON tags.id = product_tags.tag_id").
+		Where("product_tags.product_id IN (?)", productIDs).
+		Group("tags.id, tags.name").Order("count DESC").Order("tags.name").Limit(maxTagFacets).
+		Scan(&facets.Tags).Error; err != nil {
+		return facets, err
+	}
+
+	if err := db.Raw(`SELECT categories.id, categories.name, categories.parent_id,
+			COUNT(DISTINCT product_categories.product_id) AS count
+		FROM categories
+		JOIN categories AS descendants ON descendants.path LIKE categories.path || '%'
+		JOIN product_categories ON product_categories.category_id = descendants.id
+		WHERE product_categories.product_id IN (?)
+		GROUP BY categories.id, categories.name, categories.parent_id, categories.path
+		ORDER BY categories.path`, productIDs).Scan(&facets.Categories).Error; err != nil {
+		return facets, err
+	}
+
+	// Number the price ranges in SQL and count the products in each
+	var bucket strings.Builder
+	args := make([]interface{}, 0, len(priceFacetBounds))
+	bucket.WriteString("CASE")
+	for i, bound := range priceFacetBounds {
+		bucket.WriteString(" WHEN price < ? THEN " + strconv.Itoa(i))
+		args = append(args, bound)
+	}
+	bucket.WriteString(" ELSE " + strconv.Itoa(len(priceFacetBounds)) + " END")
+
+	var buckets []struct {
+		Bucket int
+		Count  int64
+	}
+	if err := db.Model(&models.Product{}).
+		Select(bucket.String()+" AS bucket, COUNT(*) AS count", args...).
+		Where("id IN (?)", productIDs).
+		Group("bucket").Scan(&buckets).Error; err != nil {
+		return facets, err
+	}
+	counts := make(map[int]int64, len(buckets))
+	for _, b := range buckets {
+		counts[b.Bucket] = b.Count
+	}
+
+	// List every range, empty ones included
+	min := 0.0
+	for i := 0; i <= len(priceFacetBounds); i++ {
+		facet := models.PriceFacet{Min: min, Count: counts[i]}
+		if i < len(priceFacetBounds) {
+			max := priceFacetBounds[i]
+			facet.Max = &max
+			min = max
+		}
+		facets.Prices = append(facets.Prices, facet)
+	}
+	return facets, nil
+}
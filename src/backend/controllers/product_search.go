@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"html"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/config"
+	"backend/models"
+)
+
+// Product search page sizes and query limits
+const (
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 100
+	maxSearchTerms        = 10
+)
+
+// Highlight delimiters from the Unicode private use area, so they can't come from the
+// text itself; they're replaced by <mark> tags after escaping
+const (
+	highlightStart = "\ue000"
+	highlightStop  = "\ue001"
+)
+
+// productSearchRow is a product with the ranking and highlights computed by the database
+type productSearchRow struct {
+	models.Product
+	Rank                 float64
+	NameHighlight        string
+	DescriptionHighlight string
+}
+
+// SearchProducts finds products matching the words in q by full-text search, best matches
+// first. Every word also matches as a prefix, so results show up while typing. The facets
+// count the matching products by tag, category and price range.
+func (pc *ProductController) SearchProducts(c *gin.Context) {
+	// Reject unknown parameters
+	if !checkQueryParams(c, "q", pageParam, pageSizeParam) {
+		return
+	}
+
+	tsQuery := prefixTSQuery(c.Query("q"))
+	if tsQuery == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q must contain at least one word"})
+		return
+	}
+
+	page, ok := parsePage(c, nil, "", defaultSearchPageSize, maxSearchPageSize)
+	if !ok {
+		return
+	}
+
+	if pc.DB.Dialector.Name() != "postgres" {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Search is not available"})
+		return
+	}
+
+	language := config.SearchLanguage()
+
+	matching := pc.DB.Model(&models.Product{}).Select("id").
+		Where("search_vector @@ to_tsquery(?::regconfig, ?)", language, tsQuery)
+
+	var total int64
+	if err := pc.DB.Raw(`SELECT count(*) FROM products WHERE search_vector @@ to_tsquery(?::regconfig, ?)`,
+		language, tsQuery).Scan(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search products"})
+		return
+	}
+	facets, err := productFacets(pc.DB, matching)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search products"})
+		return
+	}
+
+	// Rank by cover density, normalized by document length, and highlight the matches
+	nameOptions := "StartSel=" + highlightStart + ", StopSel=" + highlightStop + ", HighlightAll=true"
+	descriptionOptions := "StartSel=" + highlightStart + ", StopSel=" + highlightStop + ", MaxFragments=2, MaxWords=20, MinWords=5"
+	var rows []productSearchRow
+	if err := pc.DB.Raw(`SELECT products.*,
+			ts_rank_cd(search_vector, query, 1) AS rank,
+			ts_headline(?::regconfig, name, query, ?) AS name_highlight,
+			ts_headline(?::regconfig, description, query, ?) AS description_highlight
+		FROM products, to_tsquery(?::regconfig, ?) AS query
+		WHERE search_vector @@ query
+		ORDER BY rank DESC, id
+		LIMIT ? OFFSET ?`,
+		language, nameOptions, language, descriptionOptions, language, tsQuery,
+		page.PageSize, (page.Page-1)*page.PageSize).Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search products"})
+		return
+	}
+
+	// Load details and convert rows to results
+	products := make([]*models.Product, len(rows))
+	for i := range rows {
+		products[i] = &rows[i].Product
+	}
+	if err := loadProductDetails(pc.DB, products...); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search products"})
+		return
+	}
+	results := make([]models.ProductSearchResult, 0, len(rows))
+	for _, row := range rows {
+		results = append(results, models.ProductSearchResult{
+			ProductResponse: row.Product.ToResponse(),
+			Rank:            row.Rank,
+			Highlights: models.ProductHighlights{
+				Name:        highlightHTML(row.NameHighlight),
+				Description: highlightHTML(row.DescriptionHighlight),
+			},
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"products": results,
+		"pagination": gin.H{
+			"page":       page.Page,
+			"pageSize":   page.PageSize,
+			"total":      total,
+			"totalPages": (total + int64(page.PageSize) - 1) / int64(page.PageSize),
+		},
+		"facets": facets,
+	})
+}
+
+// prefixTSQuery turns free text into a tsquery matching every word, each also as a prefix.
+// Only letters and digits are kept, so the text can't inject tsquery operators.
+func prefixTSQuery(q string) string {
+	words := strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) > maxSearchTerms {
+		words = words[:maxSearchTerms]
+	}
+
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// highlightHTML escapes highlighted text and turns the delimiters into <mark> tags
+func highlightHTML(text string) string {
+	escaped := html.EscapeString(text)
+	return strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>").Replace(escaped)
+}
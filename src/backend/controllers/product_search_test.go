@@ -0,0 +1,47 @@
+package controllers
+
+import "testing"
+
+func TestPrefixTSQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		q    string
+		want string
+	}{
+		{"single word", "camisa", "camisa:*"},
+		{"several words", "camisa  azul", "camisa:* & azul:*"},
+		{"accents and digits", "calção 42", "calção:* & 42:*"},
+		{"operators are dropped", "camisa & !azul | (verde:*)", "camisa:* & azul:* & verde:*"},
+		{"quotes are dropped", `'a' "b"`, "a:* & b:*"},
+		{"no words", " &|!() ", ""},
+		{"empty", "", ""},
+		{"too many words", "a b c d e f g h i j k l", "a:* & b:* & c:* & d:* & e:* & f:* & g:* & h:* & i:* & j:*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := prefixTSQuery(tt.q); got != tt.want {
+				t.Errorf("prefixTSQuery(%q) = %q, want %q", tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHighlightHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"no matches", "Camisa azul", "Camisa azul"},
+		{"matches", highlightStart + "Camisa" + highlightStop + " " + highlightStart + "azul" + highlightStop, "<mark>Camisa</mark> <mark>azul</mark>"},
+		{"markup in the text is escaped", "<b>" + highlightStart + "Camisa" + highlightStop + "</b> & co", "&lt;b&gt;<mark>Camisa</mark>&lt;/b&gt; &amp; co"},
+		{"mark tags in the text are escaped", "<mark>Camisa</mark>", "&lt;mark&gt;Camisa&lt;/mark&gt;"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := highlightHTML(tt.text); got != tt.want {
+				t.Errorf("highlightHTML(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"backend/models"
+)
+
+// Product suggestion limits
+const (
+	defaultSuggestLimit = 8
+	maxSuggestLimit     = 20
+	maxSuggestLength    = 100
+	// suggestSimilarity is the minimum word similarity between the typed text and a name
+	suggestSimilarity = "0.4"
+)
+
+// trigramSuggest tells whether suggestions use pg_trgm similarity, see InitProductSuggest
+var trigramSuggest bool
+
+// InitProductSuggest sets up typo-tolerant product suggestions with pg_trgm. Without the
+// extension, suggestions only match names containing the typed text.
+func InitProductSuggest(db *gorm.DB) {
+	if err := models.MigrateProductSuggest(db); err != nil {
+		trigramSuggest = false
+		log.Printf("Product suggestions won't tolerate typos: %v", err)
+		return
+	}
+	trigramSuggest = true
+	log.Println("Product suggestions use trigram similarity")
+}
+
+// suggestTimeout is the latency budget of a suggestion query, set by SUGGEST_TIMEOUT
+func suggestTimeout() time.Duration {
+	if timeout, err := time.ParseDuration(os.Getenv("SUGGEST_TIMEOUT")); err == nil && timeout > 0 {
+		return timeout
+	}
+	return 200 * time.Millisecond
+}
+
+// SuggestProducts suggests product names for the text typed so far, best matches first.
+// A query that runs out of time returns no suggestions rather than slowing the search box.
+func (pc *ProductController) SuggestProducts(c *gin.Context) {
+	// Reject unknown parameters
+	if !checkQueryParams(c, "q", "limit") {
+		return
+	}
+
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" || len(q) > maxSuggestLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q must have between 1 and " + strconv.Itoa(maxSuggestLength) + " characters"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultSuggestLimit)))
+	if err != nil || limit < 1 || limit > maxSuggestLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxSuggestLimit)})
+		return
+	}
+
+	// Cancel the query once the latency budget is spent
+	timeout := suggestTimeout()
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+	db := pc.DB.WithContext(ctx)
+
+	suggestions := []models.ProductSuggestion{}
+	if trigramSuggest {
+		err = suggestBySimilarity(db, q, limit, timeout, &suggestions)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Trigram product suggestions failed, matching names instead: %v", err)
+			err = suggestByPattern(db, q, limit, &suggestions)
+		}
+	} else {
+		err = suggestByPattern(db, q, limit, &suggestions)
+	}
+
+	if err != nil {
+		if ctx.Err() == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to suggest products"})
+			return
+		}
+		log.Printf("Product suggestions exceeded their %v budget", timeout)
+		suggestions = []models.ProductSuggestion{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"suggestions": suggestions})
+}
+
+// suggestBySimilarity matches names by trigram word similarity, so misspelled or partial
+// words still find the product. The threshold and the statement timeout only apply to the
+// transaction.
+func suggestBySimilarity(db *gorm.DB, q string, limit int, timeout time.Duration, suggestions *[]models.ProductSuggestion) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		statementTimeout := strconv.FormatInt(timeout.Milliseconds()+1, 10) + "ms"
+		if err := tx.Exec(`SELECT set_config('pg_trgm.word_similarity_threshold', ?, true), set_config('statement_timeout', ?, true)`,
+			suggestSimilarity, statementTimeout).Error; err != nil {
+			return err
+		}
+
+		return tx.Raw(`SELECT id, name FROM products
+			WHERE ? <% name
+			ORDER BY word_similarity(?, name) DESC, length(name), id
+			LIMIT ?`, q, q, limit).Scan(suggestions).Error
+	})
+}
+
+// suggestByPattern matches names containing the typed text, those starting with it first
+func suggestByPattern(db *gorm.DB, q string, limit int, suggestions *[]models.ProductSuggestion) error {
+	contains := containsPattern(q)
+	prefix := strings.TrimPrefix(contains, "%")
+	return db.Raw(`SELECT id, name FROM products
+		WHERE LOWER(name) LIKE ? ESCAPE '\'
+		ORDER BY CASE WHEN LOWER(name) LIKE ? ESCAPE '\' THEN 0 ELSE 1 END, length(name), id
+		LIMIT ?`, contains, prefix, limit).Scan(suggestions).Error
+}
@@ -0,0 +1,606 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"backend/models"
+	"backend/utils"
+)
+
+// Product option and variant limits
+const (
+	maxProductOptions  = 3
+	maxOptionValues    = 50
+	maxOptionLength    = 50
+	maxProductVariants = 100
+)
+
+// skuPattern matches valid SKUs
+var skuPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// errOptionValueInUse is returned when removing an option value variants still use
+var errOptionValueInUse = errors.New("value is used by variants")
+
+// errSKUTaken is returned when another variant has the SKU
+var errSKUTaken = errors.New("SKU taken")
+
+// isSKUTaken checks if saving a variant failed because another variant has the SKU, either
+// found beforehand or, when two requests race, by the unique index
+func isSKUTaken(err error) bool {
+	return errors.Is(err, errSKUTaken) || errors.Is(err, gorm.ErrDuplicatedKey)
+}
+
+// optionRequest is an option type and its values, in display order
+type optionRequest struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// variantRequest is the body for creating or updating a variant; Options maps each option
+// name to the variant's value. Fields left out of an update are kept.
+type variantRequest struct {
+	SKU      *string           `json:"sku"`
+	Price    *float64          `json:"price" binding:"omitempty,min=0"`
+	Quantity *int              `json:"quantity" binding:"omitempty,min=0"`
+	Options  map[string]string `json:"options"`
+}
+
+// SetProductOptions replaces the option types of a product, like size and color, and their
+// values, in the given order. Options can only be added or removed while the product has no
+// variants, and values used by variants can't be removed.
+func (pc *ProductController) SetProductOptions(c *gin.Context) {
+	product, ok := pc.findProduct(c)
+	if !ok {
+		return
+	}
+
+	var optionData struct {
+		Options []optionRequest `json:"options"`
+	}
+	if err := c.ShouldBindJSON(&optionData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	options, ok := validOptions(c, optionData.Options)
+	if !ok {
+		return
+	}
+
+	// Every variant needs a value for every option
+	if len(product.Variants) > 0 && !sameOptionNames(product.Options, options) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Options can't be added or removed while the product has variants"})
+		return
+	}
+
+	// Keep a snapshot for the audit log
+	before := product.ToResponse()
+
+	usedValues := make(map[uint]bool)
+	for _, variant := range product.Variants {
+		for _, value := range variant.OptionValues {
+			usedValues[value.ID] = true
+		}
+	}
+
+	// Update the options in place, so the values variants use keep their IDs
+	err := pc.DB.Transaction(func(tx *gorm.DB) error {
+		existing := make(map[string]models.ProductOption, len(product.Options))
+		for _, option := range product.Options {
+			existing[strings.ToLower(option.Name)] = option
+		}
+
+		for position, request := range options {
+			option, ok := existing[strings.ToLower(request.Name)]
+			delete(existing, strings.ToLower(request.Name))
+			if !ok {
+				option = models.ProductOption{ProductID: product.ID}
+			}
+			option.Name = request.Name
+			option.Position = position
+			if err := tx.Omit(clause.Associations).Save(&option).Error; err != nil {
+				return err
+			}
+
+			existingValues := make(map[string]models.ProductOptionValue, len(option.Values))
+			for _, value := range option.Values {
+				existingValues[strings.ToLower(value.Value)] = value
+			}
+			for valuePosition, name := range request.Values {
+				value, ok := existingValues[strings.ToLower(name)]
+				delete(existingValues, strings.ToLower(name))
+				if !ok {
+					value = models.ProductOptionValue{OptionID: option.ID}
+				}
+				value.Value = name
+				value.Position = valuePosition
+				if err := tx.Save(&value).Error; err != nil {
+					return err
+				}
+			}
+			for _, value := range existingValues {
+				if usedValues[value.ID] {
+					return fmt.Errorf("%s %q: %w", option.Name, value.Value, errOptionValueInUse)
+				}
+				if err := tx.Delete(&value).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		// Remove the options left out; without variants, none of their values are used
+		for _, option := range existing {
+			if err := tx.Where("option_id = ?", option.ID).Delete(&models.ProductOptionValue{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Delete(&option).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := loadProductVariants(tx, product); err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionUpdate, models.AuditEntityProduct, product.ID, before, product.ToResponse())
+	})
+	if errors.Is(err, errOptionValueInUse) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product options"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"product": product.ToResponse()})
+}
+
+// CreateVariant adds a variant to a product, with a value for each of its options
+func (pc *ProductController) CreateVariant(c *gin.Context) {
+	product, ok := pc.findProduct(c)
+	if !ok {
+		return
+	}
+
+	var request variantRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.SKU == nil || request.Price == nil || request.Quantity == nil || request.Options == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sku, price, quantity and options are required"})
+		return
+	}
+	if len(product.Options) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set the product options before adding variants"})
+		return
+	}
+	if len(product.Variants) >= maxProductVariants {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A product can have at most " + strconv.Itoa(maxProductVariants) + " variants"})
+		return
+	}
+
+	variant := models.ProductVariant{ProductID: product.ID, Price: *request.Price, Quantity: *request.Quantity}
+	if !applyVariantSKUAndOptions(c, product, &variant, request) {
+		return
+	}
+
+	// Create variant and record it in the audit log
+	err := pc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkSKU(tx, variant.SKU, 0); err != nil {
+			return err
+		}
+		if err := tx.Create(&variant).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionCreate, models.AuditEntityVariant, variant.ID, nil, variant.ToResponse(product.Options))
+	})
+	if isSKUTaken(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "SKU already in use"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create variant"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"variant": variant.ToResponse(product.Options)})
+}
+
+// UpdateVariant updates a variant's SKU, price, stock or option values
+func (pc *ProductController) UpdateVariant(c *gin.Context) {
+	product, ok := pc.findProduct(c)
+	if !ok {
+		return
+	}
+	variant, ok := findVariant(c, product)
+	if !ok {
+		return
+	}
+
+	var request variantRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Keep a snapshot for the audit log
+	before := variant.ToResponse(product.Options)
+
+	if request.Price != nil {
+		variant.Price = *request.Price
+	}
+	if request.Quantity != nil {
+		variant.Quantity = *request.Quantity
+	}
+	if !applyVariantSKUAndOptions(c, product, variant, request) {
+		return
+	}
+
+	// Save variant and record the change in the audit log
+	err := pc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkSKU(tx, variant.SKU, variant.ID); err != nil {
+			return err
+		}
+		if err := tx.Omit(clause.Associations).Save(variant).Error; err != nil {
+			return err
+		}
+		if request.Options != nil {
+			if err := tx.Model(variant).Association("OptionValues").Replace(variant.OptionValues); err != nil {
+				return err
+			}
+		}
+		return recordAudit(tx, c, models.AuditActionUpdate, models.AuditEntityVariant, variant.ID, before, variant.ToResponse(product.Options))
+	})
+	if isSKUTaken(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "SKU already in use"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update variant"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"variant": variant.ToResponse(product.Options)})
+}
+
+// DeleteVariant deletes a variant
+func (pc *ProductController) DeleteVariant(c *gin.Context) {
+	product, ok := pc.findProduct(c)
+	if !ok {
+		return
+	}
+	variant, ok := findVariant(c, product)
+	if !ok {
+		return
+	}
+
+	// Delete variant and record it in the audit log
+	err := pc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(variant).Association("OptionValues").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Delete(variant).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionDelete, models.AuditEntityVariant, variant.ID, variant.ToResponse(product.Options), nil)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete variant"})
+		return
+	}
+
+	// Delete variant's image if it exists
+	if variant.ImagePath != "" {
+		utils.DeleteFile(variant.ImagePath)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Variant deleted successfully"})
+}
+
+// UploadVariantImage uploads an image for a variant
+func (pc *ProductController) UploadVariantImage(c *gin.Context) {
+	product, ok := pc.findProduct(c)
+	if !ok {
+		return
+	}
+	variant, ok := findVariant(c, product)
+	if !ok {
+		return
+	}
+
+	// Get file from request
+	file, err := c.FormFile("image")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+		return
+	}
+
+	// Validate file type
+	allowedTypes := []string{"image/jpeg", "image/png", "image/gif"}
+	if err := utils.ValidateFileType(file, allowedTypes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Keep a snapshot for the audit log
+	before := variant.ToResponse(product.Options)
+
+	// Delete old image if it exists
+	if variant.ImagePath != "" {
+		utils.DeleteFile(variant.ImagePath)
+	}
+
+	// Upload new image
+	imagePath, err := utils.UploadFile(c, file, "products")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload image"})
+		return
+	}
+
+	// Update variant with new image path and record the change in the audit log
+	variant.ImagePath = imagePath
+	err = pc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Save(variant).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionUpdate, models.AuditEntityVariant, variant.ID, before, variant.ToResponse(product.Options))
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update variant"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"variant": variant.ToResponse(product.Options)})
+}
+
+// findProduct loads the product in the id URL parameter with its details. On failure the
+// error response has been written.
+func (pc *ProductController) findProduct(c *gin.Context) (*models.Product, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return nil, false
+	}
+
+	var product models.Product
+	if err := pc.DB.First(&product, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return nil, false
+	}
+	if err := loadProductDetails(pc.DB, &product); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get product"})
+		return nil, false
+	}
+	return &product, true
+}
+
+// findVariant finds the variant in the variantId URL parameter among the product's variants.
+// On failure the error response has been written.
+func findVariant(c *gin.Context, product *models.Product) (*models.ProductVariant, bool) {
+	id, err := strconv.ParseUint(c.Param("variantId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid variant ID"})
+		return nil, false
+	}
+
+	for i := range product.Variants {
+		if product.Variants[i].ID == uint(id) {
+			return &product.Variants[i], true
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found"})
+	return nil, false
+}
+
+// validOptions trims the option names and values and checks their number, length and
+// uniqueness, ignoring case. On failure the error response has been written.
+func validOptions(c *gin.Context, options []optionRequest) ([]optionRequest, bool) {
+	if len(options) > maxProductOptions {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A product can have at most " + strconv.Itoa(maxProductOptions) + " options"})
+		return nil, false
+	}
+
+	validLength := func(s string) bool {
+		return s != "" && len(s) <= maxOptionLength
+	}
+
+	names := make(map[string]bool, len(options))
+	valid := make([]optionRequest, 0, len(options))
+	for _, option := range options {
+		name := strings.TrimSpace(option.Name)
+		if !validLength(name) || names[strings.ToLower(name)] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Option names must be unique and have between 1 and " + strconv.Itoa(maxOptionLength) + " characters"})
+			return nil, false
+		}
+		names[strings.ToLower(name)] = true
+
+		if len(option.Values) == 0 || len(option.Values) > maxOptionValues {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Option %q must have between 1 and %d values", name, maxOptionValues)})
+			return nil, false
+		}
+		values := make([]string, 0, len(option.Values))
+		seen := make(map[string]bool, len(option.Values))
+		for _, value := range option.Values {
+			value = strings.TrimSpace(value)
+			if !validLength(value) || seen[strings.ToLower(value)] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Values of option %q must be unique and have between 1 and %d characters", name, maxOptionLength)})
+				return nil, false
+			}
+			seen[strings.ToLower(value)] = true
+			values = append(values, value)
+		}
+		valid = append(valid, optionRequest{Name: name, Values: values})
+	}
+	return valid, true
+}
+
+// sameOptionNames checks if the requested options are the product's options, ignoring case
+// and order
+func sameOptionNames(current []models.ProductOption, requested []optionRequest) bool {
+	if len(current) != len(requested) {
+		return false
+	}
+	names := make(map[string]bool, len(current))
+	for _, option := range current {
+		names[strings.ToLower(option.Name)] = true
+	}
+	for _, option := range requested {
+		if !names[strings.ToLower(option.Name)] {
+			return false
+		}
+	}
+	return true
+}
+
+// applyVariantSKUAndOptions sets the requested SKU and option values on a variant, checking
+// that the values cover the product's options and that no other variant has the same ones.
+// On failure the error response has been written.
+func applyVariantSKUAndOptions(c *gin.Context, product *models.Product, variant *models.ProductVariant, request variantRequest) bool {
+	if request.SKU != nil {
+		sku := strings.TrimSpace(*request.SKU)
+		if !skuPattern.MatchString(sku) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "sku must have up to 64 letters, digits, dots, dashes or underscores"})
+			return false
+		}
+		variant.SKU = sku
+	}
+	if request.Options == nil {
+		return true
+	}
+
+	// Find the value of every option, ignoring case
+	requested := make(map[string]string, len(request.Options))
+	for name, value := range request.Options {
+		requested[strings.ToLower(strings.TrimSpace(name))] = strings.ToLower(strings.TrimSpace(value))
+	}
+	if len(requested) != len(product.Options) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "options must have a value for each product option", "productOptions": optionNames(product.Options)})
+		return false
+	}
+	values := make([]models.ProductOptionValue, 0, len(product.Options))
+	for _, option := range product.Options {
+		requestedValue, ok := requested[strings.ToLower(option.Name)]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "options must have a value for each product option", "productOptions": optionNames(product.Options)})
+			return false
+		}
+		found := false
+		for _, value := range option.Values {
+			if strings.ToLower(value.Value) == requestedValue {
+				values = append(values, value)
+				found = true
+				break
+			}
+		}
+		if !found {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid value for option %q", option.Name), "allowedValues": option.ToResponse().Values})
+			return false
+		}
+	}
+
+	// Each combination of values belongs to a single variant
+	key := variantKey(values)
+	for _, other := range product.Variants {
+		if other.ID != variant.ID && variantKey(other.OptionValues) == key {
+			c.JSON(http.StatusConflict, gin.H{"error": "Another variant has these options", "variantId": other.ID})
+			return false
+		}
+	}
+	variant.OptionValues = values
+	return true
+}
+
+// variantKey identifies a combination of option values
+func variantKey(values []models.ProductOptionValue) string {
+	ids := make([]string, 0, len(values))
+	for _, value := range values {
+		ids = append(ids, strconv.FormatUint(uint64(value.ID), 10))
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+// optionNames returns the names of the options in order
+func optionNames(options []models.ProductOption) []string {
+	names := make([]string, 0, len(options))
+	for _, option := range options {
+		names = append(names, option.Name)
+	}
+	return names
+}
+
+// checkSKU fails with errSKUTaken if another variant has the SKU
+func checkSKU(db *gorm.DB, sku string, excludeID uint) error {
+	var count int64
+	if err := db.Model(&models.ProductVariant{}).Where("sku = ? AND id <> ?", sku, excludeID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errSKUTaken
+	}
+	return nil
+}
+
+// loadProductVariants loads the options of the products, with their values, and their
+// variants, all in display order
+func loadProductVariants(db *gorm.DB, products ...*models.Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+
+	productIndex := make(map[uint]int, len(products))
+	productIDs := make([]uint, 0, len(products))
+	for i, product := range products {
+		product.Options = nil
+		product.Variants = nil
+		productIndex[product.ID] = i
+		productIDs = append(productIDs, product.ID)
+	}
+
+	var options []models.ProductOption
+	if err := db.Preload("Values", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("position")
+	}).Where("product_id IN ?", productIDs).Order("position").Find(&options).Error; err != nil {
+		return err
+	}
+	for _, option := range options {
+		product := products[productIndex[option.ProductID]]
+		product.Options = append(product.Options, option)
+	}
+
+	var variants []models.ProductVariant
+	if err := db.Preload("OptionValues").Where("product_id IN ?", productIDs).Order("id").Find(&variants).Error; err != nil {
+		return err
+	}
+	for _, variant := range variants {
+		product := products[productIndex[variant.ProductID]]
+		product.Variants = append(product.Variants, variant)
+	}
+	return nil
+}
+
+// deleteProductVariants deletes the variants and options of a product
+func deleteProductVariants(tx *gorm.DB, productID uint) error {
+	if err := tx.Exec(`DELETE FROM product_variant_values WHERE product_variant_id IN
+		(SELECT id FROM product_variants WHERE product_id = ?)`, productID).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("product_id = ?", productID).Delete(&models.ProductVariant{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Exec(`DELETE FROM product_option_values WHERE option_id IN
+		(SELECT id FROM product_options WHERE product_id = ?)`, productID).Error; err != nil {
+		return err
+	}
+	return tx.Where("product_id = ?", productID).Delete(&models.ProductOption{}).Error
+}
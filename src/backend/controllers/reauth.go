@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"backend/middleware"
+	"backend/models"
+)
+
+// reauthData is the proof of identity sensitive account changes require: the current
+// password, or an MFA or recovery code for users with MFA enabled
+type reauthData struct {
+	CurrentPassword string `json:"currentPassword"`
+	Code            string `json:"code"`
+	RecoveryCode    string `json:"recoveryCode"`
+}
+
+// reauthenticate checks that the user proved their identity again, so a stolen token alone
+// can't take over the account. On failure the error response has been written.
+func reauthenticate(c *gin.Context, db *gorm.DB, user *models.User, data reauthData) bool {
+	switch {
+	case data.CurrentPassword != "":
+		// Password guesses count towards the login lockout, before the password is checked
+		lockedFor, err := middleware.BeginLoginAttempt(user.Email)
+		if err != nil {
+			log.Printf("Failed to count login attempt: %v", err)
+		}
+		if lockedFor > 0 {
+			middleware.SetRetryAfter(c, lockedFor)
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts, please try again later"})
+			return false
+		}
+
+		if !user.ComparePassword(data.CurrentPassword) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			return false
+		}
+
+		if err := middleware.ResetLoginFailures(user.Email); err != nil {
+			log.Printf("Failed to reset login failures: %v", err)
+		}
+		return true
+	case data.Code != "" || data.RecoveryCode != "":
+		if user.MFAEnabledAt == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "MFA is not enabled"})
+			return false
+		}
+		return checkMFACode(c, db, user, data.Code, data.RecoveryCode)
+	default:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Re-authentication required: provide the current password or an MFA code"})
+		return false
+	}
+}
@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	"backend/middleware"
+	"backend/models"
+)
+
+// StartSessionCleanupJob removes expired sessions, now and then every interval
+func StartSessionCleanupJob(db *gorm.DB, interval time.Duration) {
+	go func() {
+		for {
+			if err := deleteExpiredSessions(db); err != nil {
+				log.Printf("Failed to delete expired sessions: %v", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// deleteExpiredSessions removes sessions that expired long enough ago that no access token
+// issued to them is still valid, so revoking them no longer matters
+func deleteExpiredSessions(db *gorm.DB) error {
+	return db.Where("expires_at < ?", time.Now().Add(-middleware.RevocationWindow())).Delete(&models.Session{}).Error
+}
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"backend/config"
+	"backend/models"
+)
+
+// SessionController handles the current user's sign-in sessions
+type SessionController struct {
+	DB *gorm.DB
+}
+
+// NewSessionController creates a new SessionController
+func NewSessionController() *SessionController {
+	return &SessionController{
+		DB: config.GetDB(),
+	}
+}
+
+// ListSessions lists the current user's active sessions, most recently used first
+func (sc *SessionController) ListSessions(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var sessions []models.Session
+	if err := sc.DB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sessions"})
+		return
+	}
+
+	// Convert sessions to responses, marking the one making this request
+	currentID := c.GetString("sessionId")
+	sessionResponses := make([]models.SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		sessionResponses = append(sessionResponses, session.ToResponse(session.ID == currentID))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessionResponses})
+}
+
+// RevokeSession signs the current user out of one of their sessions
+func (sc *SessionController) RevokeSession(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Find session by ID, only among the user's own sessions
+	var session models.Session
+	if err := sc.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&session).Error; err != nil || !session.IsActive() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	// End the session and record it in the audit log
+	err := sc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := endSession(tx, session.ID); err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionSessionRevoke, models.AuditEntityUser, session.UserID,
+			map[string]string{"sessionId": session.ID}, nil)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session ended successfully"})
+}
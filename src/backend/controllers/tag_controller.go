@@ -0,0 +1,295 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"backend/config"
+	"backend/models"
+)
+
+// Tag limits
+const (
+	maxTagNameLength = 50
+	maxProductTags   = 20
+)
+
+// errInvalidTags is returned when a product's tags are too long or too many
+var errInvalidTags = errors.New("tags must have between 1 and " + strconv.Itoa(maxTagNameLength) +
+	" characters, at most " + strconv.Itoa(maxProductTags) + " per product")
+
+// errTagNameTaken is returned when renaming a tag to the name of another tag
+var errTagNameTaken = errors.New("tag name taken")
+
+// isTagNameTaken checks if saving a tag failed because another tag has the name, either
+// found beforehand or, when two requests race, by the unique index
+func isTagNameTaken(err error) bool {
+	return errors.Is(err, errTagNameTaken) || errors.Is(err, gorm.ErrDuplicatedKey)
+}
+
+// TagController handles product tag operations
+type TagController struct {
+	DB *gorm.DB
+}
+
+// NewTagController creates a new TagController
+func NewTagController() *TagController {
+	return &TagController{
+		DB: config.GetDB(),
+	}
+}
+
+// ListTags lists the tags by name, with the number of products labeled with each
+func (tc *TagController) ListTags(c *gin.Context) {
+	tags := []models.TagSummary{}
+	if err := tc.DB.Model(&models.Tag{}).
+		Select("tags.id, tags.name, COUNT(product_tags.product_id) AS product_count").
+		Joins("LEFT JOIN product_tags ON product_tags.tag_id = tags.id").
+		Group("tags.id, tags.name").Order("tags.name").
+		Scan(&tags).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get tags"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
+
+// CreateTag creates a tag ahead of labeling products with it
+func (tc *TagController) CreateTag(c *gin.Context) {
+	name, ok := bindTagName(c)
+	if !ok {
+		return
+	}
+
+	// Create tag and record it in the audit log
+	tag := models.Tag{Name: name}
+	err := tc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkTagName(tx, name, 0); err != nil {
+			return err
+		}
+		if err := tx.Create(&tag).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionCreate, models.AuditEntityTag, tag.ID, nil, tag.ToResponse())
+	})
+	if isTagNameTaken(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Tag already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tag"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"tag": tag.ToResponse()})
+}
+
+// UpdateTag renames a tag on every product labeled with it
+func (tc *TagController) UpdateTag(c *gin.Context) {
+	tag, ok := tc.findTag(c)
+	if !ok {
+		return
+	}
+	name, ok := bindTagName(c)
+	if !ok {
+		return
+	}
+
+	// Keep a snapshot for the audit log
+	before := tag.ToResponse()
+
+	// Rename tag and record the change in the audit log
+	tag.Name = name
+	err := tc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkTagName(tx, name, tag.ID); err != nil {
+			return err
+		}
+		if err := tx.Save(&tag).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionUpdate, models.AuditEntityTag, tag.ID, before, tag.ToResponse())
+	})
+	if isTagNameTaken(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Another tag has this name"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tag"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tag": tag.ToResponse()})
+}
+
+// DeleteTag deletes a tag, removing it from its products
+func (tc *TagController) DeleteTag(c *gin.Context) {
+	tag, ok := tc.findTag(c)
+	if !ok {
+		return
+	}
+
+	// Delete tag and record it in the audit log
+	err := tc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM product_tags WHERE tag_id = ?", tag.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&tag).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionDelete, models.AuditEntityTag, tag.ID, tag.ToResponse(), nil)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tag"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tag deleted successfully"})
+}
+
+// findTag loads the tag in the id URL parameter. On failure the error response has been written.
+func (tc *TagController) findTag(c *gin.Context) (models.Tag, bool) {
+	var tag models.Tag
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag ID"})
+		return tag, false
+	}
+
+	if err := tc.DB.First(&tag, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+		return tag, false
+	}
+	return tag, true
+}
+
+// bindTagName parses and normalizes the tag name in the request body. On failure the error
+// response has been written.
+func bindTagName(c *gin.Context) (string, bool) {
+	var tagData struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&tagData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+
+	name := models.NormalizeTagName(tagData.Name)
+	if name == "" || len(name) > maxTagNameLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must have between 1 and " + strconv.Itoa(maxTagNameLength) + " characters"})
+		return "", false
+	}
+	return name, true
+}
+
+// checkTagName fails with errTagNameTaken if another tag has the name
+func checkTagName(db *gorm.DB, name string, excludeID uint) error {
+	var count int64
+	if err := db.Model(&models.Tag{}).Where("name = ? AND id <> ?", name, excludeID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errTagNameTaken
+	}
+	return nil
+}
+
+// loadProductTags loads the tags of the products, sorted by name
+func loadProductTags(db *gorm.DB, products ...*models.Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+
+	productIndex := make(map[uint]int, len(products))
+	productIDs := make([]uint, 0, len(products))
+	for i, product := range products {
+		product.Tags = nil
+		productIndex[product.ID] = i
+		productIDs = append(productIDs, product.ID)
+	}
+
+	var rows []struct {
+		ProductID uint
+		models.Tag
+	}
+	if err := db.Table("product_tags").
+		Select("product_tags.product_id, tags.id, tags.name, tags.created_at").
+		Joins("JOIN tags ON tags.id = product_tags.tag_id").
+		Where("product_tags.product_id IN ?", productIDs).
+		Order("tags.name").Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		product := products[productIndex[row.ProductID]]
+		product.Tags = append(product.Tags, row.Tag)
+	}
+	return nil
+}
+
+// setProductTags replaces the tags of a product, creating the tags that don't exist yet and
+// recording them in the audit log. It fails with errInvalidTags if the names are too long or
+// too many.
+func setProductTags(tx *gorm.DB, c *gin.Context, product *models.Product, names []string) error {
+	unique := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = models.NormalizeTagName(name)
+		if name == "" || len(name) > maxTagNameLength {
+			return errInvalidTags
+		}
+		unique[name] = true
+	}
+	if len(unique) > maxProductTags {
+		return errInvalidTags
+	}
+
+	tags := []models.Tag{}
+	if len(unique) > 0 {
+		normalized := make([]string, 0, len(unique))
+		for name := range unique {
+			normalized = append(normalized, name)
+		}
+		sort.Strings(normalized)
+
+		var existing []string
+		if err := tx.Model(&models.Tag{}).Where("name IN ?", normalized).Pluck("name", &existing).Error; err != nil {
+			return err
+		}
+		for _, name := range existing {
+			delete(unique, name)
+		}
+
+		// Create the missing tags, leaving alone the ones a concurrent request just created
+		for _, name := range normalized {
+			if !unique[name] {
+				continue
+			}
+			tag := models.Tag{Name: name}
+			result := tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "name"}}, DoNothing: true}).Create(&tag)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				continue
+			}
+			if err := recordAudit(tx, c, models.AuditActionCreate, models.AuditEntityTag, tag.ID, nil, tag.ToResponse()); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Where("name IN ?", normalized).Order("name").Find(&tags).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Model(product).Association("Tags").Replace(tags); err != nil {
+		return err
+	}
+	product.Tags = tags
+	return nil
+}
@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"backend/middleware"
+	"backend/models"
+	"backend/utils"
+)
+
+// authTokens holds the tokens issued to a client after authenticating
+type authTokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int
+}
+
+// refreshTokenTTL returns how long refresh tokens are valid (REFRESH_TOKEN_TTL, default 30 days)
+func refreshTokenTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("REFRESH_TOKEN_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return 30 * 24 * time.Hour
+}
+
+// issueTokens generates an access token and stores a new refresh token for the user.
+// An empty sessionID starts a new session for the requesting device; otherwise the
+// session's refresh token family continues. mfa records whether the user completed MFA.
+func issueTokens(c *gin.Context, db *gorm.DB, user *models.User, sessionID string, mfa bool) (*authTokens, error) {
+	now := time.Now()
+	expiresAt := now.Add(refreshTokenTTL())
+
+	// Start a new session, or record activity on the current one
+	if sessionID == "" {
+		session := models.Session{
+			ID:         uuid.New().String(),
+			UserID:     user.ID,
+			Device:     utils.DescribeDevice(c.Request.UserAgent()),
+			IPAddress:  c.ClientIP(),
+			UserAgent:  c.Request.UserAgent(),
+			LastSeenAt: now,
+			ExpiresAt:  expiresAt,
+		}
+		if err := db.Create(&session).Error; err != nil {
+			return nil, err
+		}
+		sessionID = session.ID
+	} else {
+		if err := db.Model(&models.Session{}).Where("id = ?", sessionID).Updates(map[string]interface{}{
+			"ip_address":   c.ClientIP(),
+			"last_seen_at": now,
+			"expires_at":   expiresAt,
+		}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	// Generate JWT token
+	accessToken, err := middleware.GenerateJWT(middleware.JWTClaims{
+		UserID:    user.ID,
+		Role:      user.Role,
+		MFA:       mfa,
+		SessionID: sessionID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate refresh token
+	refreshToken, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	// Store only the hash of the refresh token
+	stored := models.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: utils.HashToken(refreshToken),
+		FamilyID:  sessionID,
+		MFA:       mfa,
+		ExpiresAt: expiresAt,
+	}
+	if err := db.Create(&stored).Error; err != nil {
+		return nil, err
+	}
+
+	return &authTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(middleware.AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+// revokeUserTokens revokes every access and refresh token issued to the user
+func revokeUserTokens(db *gorm.DB, userID uint) error {
+	if store := middleware.Revocations(); store != nil {
+		if err := store.RevokeAllForUser(userID); err != nil {
+			return err
+		}
+	}
+
+	if err := db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error; err != nil {
+		return err
+	}
+
+	return db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// revokeUserAPIKeys revokes every API key of the user, for changes that must lock out
+// whoever had access to the account
+func revokeUserAPIKeys(tx *gorm.DB, userID uint) error {
+	return tx.Model(&models.APIKey{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// endSession terminates a session, revoking its refresh tokens and the access tokens issued to it
+func endSession(db *gorm.DB, sessionID string) error {
+	now := time.Now()
+	if err := db.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+
+	if store := middleware.Revocations(); store != nil {
+		store.RevokeSession(sessionID, now)
+	}
+
+	return db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// errInvalidUserToken is returned when a user token is unknown, used or expired
+var errInvalidUserToken = errors.New("invalid or expired token")
+
+// createUserToken stores a new single-use token for the user and returns it.
+// Earlier unused tokens with the same purpose are invalidated.
+func createUserToken(db *gorm.DB, userID uint, purpose string, ttl time.Duration) (string, error) {
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Invalidate earlier tokens so only the latest one works
+		if err := tx.Model(&models.UserToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		userToken := models.UserToken{
+			UserID:    userID,
+			Purpose:   purpose,
+			TokenHash: utils.HashToken(token),
+			ExpiresAt: time.Now().Add(ttl),
+		}
+		return tx.Create(&userToken).Error
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// consumeUserToken marks a user token as used and returns it.
+// Marking is atomic, so a token can only be consumed once.
+func consumeUserToken(db *gorm.DB, token, purpose string) (*models.UserToken, error) {
+	var userToken models.UserToken
+	if err := db.Where("token_hash = ? AND purpose = ?", utils.HashToken(token), purpose).First(&userToken).Error; err != nil {
+		return nil, errInvalidUserToken
+	}
+
+	now := time.Now()
+	result := db.Model(&models.UserToken{}).
+		Where("id = ? AND used_at IS NULL AND expires_at > ?", userToken.ID, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errInvalidUserToken
+	}
+
+	return &userToken, nil
+}
+
+// appURL returns the public URL of the frontend used in emailed links (APP_URL, default http://localhost)
+func appURL() string {
+	if url := os.Getenv("APP_URL"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return "http://localhost"
+}
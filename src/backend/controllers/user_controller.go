@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"log"
 	"net/http"
 	"strconv"
 
@@ -8,20 +9,39 @@ import (
 	"gorm.io/gorm"
 
 	"backend/config"
+	"backend/mailer"
 	"backend/middleware"
 	"backend/models"
 	"backend/utils"
 )
 
+// User list page sizes
+const (
+	defaultUserPageSize = 20
+	maxUserPageSize     = 100
+)
+
+// userSortFields are the fields users can be sorted by
+var userSortFields = map[string]sortField{
+	"id":        {Column: "id", Kind: sortNumber},
+	"name":      {Column: "name", Kind: sortString},
+	"email":     {Column: "email", Kind: sortString},
+	"role":      {Column: "role", Kind: sortString},
+	"createdAt": {Column: "created_at", Kind: sortTime},
+	"updatedAt": {Column: "updated_at", Kind: sortTime},
+}
+
 // UserController handles user-related operations
 type UserController struct {
-	DB *gorm.DB
+	DB     *gorm.DB
+	Mailer mailer.Mailer
 }
 
 // NewUserController creates a new UserController
 func NewUserController() *UserController {
 	return &UserController{
-		DB: config.GetDB(),
+		DB:     config.GetDB(),
+		Mailer: mailer.NewFromEnv(),
 	}
 }
 
@@ -35,6 +55,14 @@ func (uc *UserController) Register(c *gin.Context) {
 		return
 	}
 
+	// Self-registered users always start as regular users
+	user.Role = models.RoleUser
+
+	// Check the password against the password policy
+	if !checkPasswordPolicy(c, user.Password, user.Name, user.Email) {
+		return
+	}
+
 	// Check if email already exists
 	var existingUser models.User
 	if err := uc.DB.Where("email = ?", user.Email).First(&existingUser).Error; err == nil {
@@ -42,23 +70,36 @@ func (uc *UserController) Register(c *gin.Context) {
 		return
 	}
 
-	// Create user
-	if err := uc.DB.Create(&user).Error; err != nil {
+	// Create user and record it in the audit log
+	err := uc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionCreate, models.AuditEntityUser, user.ID, nil, user.ToResponse())
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register user"})
 		return
 	}
 
-	// Generate JWT token
-	token, err := middleware.GenerateJWT(user.ID)
+	// Send verification email; the user can ask for a new one if this fails
+	if err := sendVerificationEmail(uc.DB, uc.Mailer, &user); err != nil {
+		log.Printf("Failed to send verification email: %v", err)
+	}
+
+	// Generate access and refresh tokens
+	tokens, err := issueTokens(c, uc.DB, &user, "", false)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	// Return user response and token
+	// Return user response and tokens
 	c.JSON(http.StatusCreated, gin.H{
-		"user":  user.ToResponse(),
-		"token": token,
+		"user":         user.ToResponse(),
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
 	})
 }
 
@@ -75,30 +116,71 @@ func (uc *UserController) Login(c *gin.Context) {
 		return
 	}
 
-	// Find user by email
+	// Count the attempt before checking the password, and reject it while the account is
+	// locked out. Unknown emails are counted too, so lockouts don't reveal which accounts exist.
+	lockedFor, err := middleware.BeginLoginAttempt(loginData.Email)
+	if err != nil {
+		log.Printf("Failed to count login attempt: %v", err)
+	}
+	if lockedFor > 0 {
+		middleware.SetRetryAfter(c, lockedFor)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, please try again later"})
+		return
+	}
+
+	// Find user by email and check password
 	var user models.User
-	if err := uc.DB.Where("email = ?", loginData.Email).First(&user).Error; err != nil {
+	if err := uc.DB.Where("email = ?", loginData.Email).First(&user).Error; err != nil || !user.ComparePassword(loginData.Password) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
-	// Check password
-	if !user.ComparePassword(loginData.Password) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+	// Clear failed attempts after a successful login
+	if err := middleware.ResetLoginFailures(loginData.Email); err != nil {
+		log.Printf("Failed to reset login failures: %v", err)
+	}
+
+	// Upgrade the stored hash to the preferred algorithm while the password is at hand
+	if user.PasswordNeedsRehash() {
+		if err := uc.DB.Model(&user).Update("password", loginData.Password).Error; err != nil {
+			log.Printf("Failed to rehash password of user %d: %v", user.ID, err)
+		}
+	}
+
+	// With MFA enabled, the password only earns a token for the second step
+	if user.MFAEnabledAt != nil {
+		mfaToken, err := middleware.GenerateMFAToken(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"mfaRequired": true,
+			"mfaToken":    mfaToken,
+		})
+		return
+	}
+
+	// Signing in again keeps an account whose deletion is pending
+	if err := cancelAccountDeletion(c, uc.DB, &user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore account"})
 		return
 	}
 
-	// Generate JWT token
-	token, err := middleware.GenerateJWT(user.ID)
+	// Generate access and refresh tokens
+	tokens, err := issueTokens(c, uc.DB, &user, "", false)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	// Return user response and token
+	// Return user response and tokens
 	c.JSON(http.StatusOK, gin.H{
-		"user":  user.ToResponse(),
-		"token": token,
+		"user":         user.ToResponse(),
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
 	})
 }
 
@@ -150,27 +232,32 @@ func (uc *UserController) UpdateProfile(c *gin.Context) {
 		return
 	}
 
+	// Email and password changes need re-authentication, so they have their own endpoints
+	if updateData.Email != "" && updateData.Email != user.Email {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use PUT /users/me/email to change the email"})
+		return
+	}
+	if updateData.Password != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use PUT /users/me/password to change the password"})
+		return
+	}
+
+	// Keep a snapshot for the audit log
+	before := user.ToResponse()
+
 	// Update fields if provided
 	if updateData.Name != "" {
 		user.Name = updateData.Name
 	}
-	if updateData.Email != "" {
-		// Check if email already exists
-		var existingUser models.User
-		if updateData.Email != user.Email {
-			if err := uc.DB.Where("email = ?", updateData.Email).First(&existingUser).Error; err == nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Email already registered"})
-				return
-			}
-		}
-		user.Email = updateData.Email
-	}
-	if updateData.Password != "" {
-		user.Password = updateData.Password
-	}
 
-	// Save user
-	if err := uc.DB.Save(&user).Error; err != nil {
+	// Save user and record the changes in the audit log
+	err := uc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionUpdate, models.AuditEntityUser, user.ID, before, user.ToResponse())
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
 		return
 	}
@@ -179,6 +266,150 @@ func (uc *UserController) UpdateProfile(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"user": user.ToResponse()})
 }
 
+// ChangePassword changes the current user's password after re-authentication
+func (uc *UserController) ChangePassword(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Parse password data
+	var passwordData struct {
+		reauthData
+		NewPassword string `json:"newPassword" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&passwordData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Find user by ID
+	var user models.User
+	if err := uc.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Check the current password or MFA code
+	if !reauthenticate(c, uc.DB, &user, passwordData.reauthData) {
+		return
+	}
+
+	// Check the new password against the password policy
+	if !checkPasswordPolicy(c, passwordData.NewPassword, user.Name, user.Email) {
+		return
+	}
+
+	// Update the password (hashed by the BeforeUpdate hook), revoke the API keys and record it
+	// in the audit log
+	err := uc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("password", passwordData.NewPassword).Error; err != nil {
+			return err
+		}
+		if err := revokeUserAPIKeys(tx, user.ID); err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionPasswordChange, models.AuditEntityUser, user.ID, nil, nil)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	// Revoke existing tokens and issue new ones for this client
+	if err := revokeUserTokens(uc.DB, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke tokens"})
+		return
+	}
+	tokens, err := issueTokens(c, uc.DB, &user, "", c.GetBool("mfa"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	// Return user response and new tokens
+	c.JSON(http.StatusOK, gin.H{
+		"user":         user.ToResponse(),
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
+	})
+}
+
+// RequestEmailChange starts changing the current user's email after re-authentication.
+// The address only changes once the link sent to the new address is opened.
+func (uc *UserController) RequestEmailChange(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Parse email data
+	var emailData struct {
+		reauthData
+		NewEmail string `json:"newEmail" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&emailData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Find user by ID
+	var user models.User
+	if err := uc.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Check the current password or MFA code
+	if !reauthenticate(c, uc.DB, &user, emailData.reauthData) {
+		return
+	}
+
+	if emailData.NewEmail == user.Email {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The new email is the current email"})
+		return
+	}
+
+	// Check if email already exists
+	var existingUser models.User
+	if err := uc.DB.Where("email = ?", emailData.NewEmail).First(&existingUser).Error; err == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email already registered"})
+		return
+	}
+
+	// Keep the new address until it's confirmed and record it in the audit log
+	before := user.ToResponse()
+	err := uc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("pending_email", emailData.NewEmail).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionUpdate, models.AuditEntityUser, user.ID, before, user.ToResponse())
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email"})
+		return
+	}
+
+	// Send the confirmation link to the new address
+	if err := sendEmailChangeConfirmation(uc.DB, uc.Mailer, &user); err != nil {
+		log.Printf("Failed to send email change confirmation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send confirmation email"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Confirmation email sent to the new address",
+		"user":    user.ToResponse(),
+	})
+}
+
 // UploadProfileImage uploads a profile image for the current user
 func (uc *UserController) UploadProfileImage(c *gin.Context) {
 	// Get user ID from context (set by auth middleware)
@@ -209,6 +440,9 @@ func (uc *UserController) UploadProfileImage(c *gin.Context) {
 		return
 	}
 
+	// Keep a snapshot for the audit log
+	before := user.ToResponse()
+
 	// Delete old image if it exists
 	if user.ImagePath != "" {
 		utils.DeleteFile(user.ImagePath)
@@ -221,9 +455,15 @@ func (uc *UserController) UploadProfileImage(c *gin.Context) {
 		return
 	}
 
-	// Update user with new image path
+	// Update user with new image path and record the change in the audit log
 	user.ImagePath = imagePath
-	if err := uc.DB.Save(&user).Error; err != nil {
+	err = uc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionUpdate, models.AuditEntityUser, user.ID, before, user.ToResponse())
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
 		return
 	}
@@ -232,21 +472,103 @@ func (uc *UserController) UploadProfileImage(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"user": user.ToResponse()})
 }
 
-// GetAllUsers gets all users (for admin purposes)
+// GetAllUsers lists users (for admin purposes), paginated with page and pageSize or with
+// the cursor from the previous page. Users can be searched by name or email with q and
+// filtered by role, verified and a createdFrom/createdTo range (RFC 3339), and sorted by a
+// comma-separated list of fields, prefixed with "-" for descending order.
 func (uc *UserController) GetAllUsers(c *gin.Context) {
+	// Reject unknown parameters
+	if !checkQueryParams(c, "q", "role", "verified", "createdFrom", "createdTo",
+		sortParam, pageParam, pageSizeParam, cursorParam) {
+		return
+	}
+
+	query := uc.DB.Model(&models.User{})
+
+	// Apply filters
+	if q := c.Query("q"); q != "" {
+		pattern := containsPattern(q)
+		query = query.Where(`LOWER(name) LIKE ? ESCAPE '\' OR LOWER(email) LIKE ? ESCAPE '\'`, pattern, pattern)
+	}
+	if role := c.Query("role"); role != "" {
+		if !models.IsValidRole(role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+			return
+		}
+		query = query.Where("role = ?", role)
+	}
+	verified, ok := parseBoolParam(c, "verified")
+	if !ok {
+		return
+	}
+	if verified != nil && *verified {
+		query = query.Where("email_verified_at IS NOT NULL")
+	} else if verified != nil {
+		query = query.Where("email_verified_at IS NULL")
+	}
+	createdFrom, ok := parseTimeParam(c, "createdFrom")
+	if !ok {
+		return
+	}
+	if createdFrom != nil {
+		query = query.Where("created_at >= ?", *createdFrom)
+	}
+	createdTo, ok := parseTimeParam(c, "createdTo")
+	if !ok {
+		return
+	}
+	if createdTo != nil {
+		query = query.Where("created_at < ?", *createdTo)
+	}
+
+	// Parse sorting and pagination
+	keys, sortSpec, ok := parseSort(c, userSortFields, "id")
+	if !ok {
+		return
+	}
+	page, ok := parsePage(c, keys, sortSpec, defaultUserPageSize, maxUserPageSize)
+	if !ok {
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get users"})
+		return
+	}
+
 	var users []models.User
-	if err := uc.DB.Find(&users).Error; err != nil {
+	if err := applyPage(applySort(query, keys), keys, page).Find(&users).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get users"})
 		return
 	}
 
-	// Convert users to responses
-	var userResponses []models.UserResponse
+	// The extra row only tells that there's a next page
+	hasMore := len(users) > page.PageSize
+	if hasMore {
+		users = users[:page.PageSize]
+	}
+
+	// Convert users to responses; an empty list is [] rather than null
+	userResponses := make([]models.UserResponse, 0, len(users))
 	for _, user := range users {
 		userResponses = append(userResponses, user.ToResponse())
 	}
 
-	c.JSON(http.StatusOK, gin.H{"users": userResponses})
+	nextCursor := ""
+	if hasMore {
+		var err error
+		nextCursor, err = encodeCursor(userResponses[len(userResponses)-1], keys, sortSpec)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get users"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":      userResponses,
+		"pagination": paginationResponse(page, total, nextCursor),
+	})
 }
 
 // GetUserByID gets a user by ID
@@ -287,16 +609,148 @@ func (uc *UserController) DeleteUser(c *gin.Context) {
 		return
 	}
 
+	// Delete user, record it in the audit log and erase the user's data
+	err = uc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&user).Error; err != nil {
+			return err
+		}
+		if err := recordAudit(tx, c, models.AuditActionDelete, models.AuditEntityUser, user.ID, user.ToResponse(), nil); err != nil {
+			return err
+		}
+		return eraseUserData(tx, user.ID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+
 	// Delete user's image if it exists
 	if user.ImagePath != "" {
 		utils.DeleteFile(user.ImagePath)
 	}
 
-	// Delete user
-	if err := uc.DB.Delete(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+	// Revoke the deleted user's tokens
+	if err := revokeUserTokens(uc.DB, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke tokens"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
-} 
\ No newline at end of file
+}
+
+// UpdateUserRole changes the role of a user (for admin purposes)
+func (uc *UserController) UpdateUserRole(c *gin.Context) {
+	// Get user ID from URL parameter
+	userID := c.Param("id")
+	id, err := strconv.ParseUint(userID, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	// Parse role data
+	var roleData struct {
+		Role string `json:"role" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&roleData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !models.IsValidRole(roleData.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+
+	// Find user by ID
+	var user models.User
+	if err := uc.DB.First(&user, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Update role and record the change in the audit log
+	before := user.ToResponse()
+	roleChanged := user.Role != roleData.Role
+	err = uc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("role", roleData.Role).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, models.AuditActionRoleChange, models.AuditEntityUser, user.ID, before, user.ToResponse())
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user role"})
+		return
+	}
+
+	// Revoke the user's tokens, since they carry the old role
+	if roleChanged {
+		if err := revokeUserTokens(uc.DB, user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke tokens"})
+			return
+		}
+	}
+
+	// Return user response
+	c.JSON(http.StatusOK, gin.H{"user": user.ToResponse()})
+}
+
+// ImpersonateUser issues a short-lived token that lets the current admin act as another user
+func (uc *UserController) ImpersonateUser(c *gin.Context) {
+	// Get admin ID from context (set by auth middleware)
+	adminID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Get user ID from URL parameter
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	// Find user by ID
+	var user models.User
+	if err := uc.DB.First(&user, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Admins can't be impersonated, so impersonation never grants admin rights
+	if user.ID == adminID.(uint) || user.Role == models.RoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This user can't be impersonated"})
+		return
+	}
+
+	// Record the start of the impersonation in the audit log, then generate the token in the
+	// same transaction, so no token is issued unless the event is recorded. The admin's MFA
+	// carries over.
+	var token string
+	err = uc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := recordAudit(tx, c, models.AuditActionImpersonate, models.AuditEntityUser, user.ID, nil, nil); err != nil {
+			return err
+		}
+		token, err = middleware.GenerateImpersonationToken(middleware.JWTClaims{
+			UserID:         user.ID,
+			Role:           user.Role,
+			MFA:            c.GetBool("mfa"),
+			ImpersonatorID: adminID.(uint),
+		})
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start impersonation"})
+		return
+	}
+
+	// Return user response and token
+	c.JSON(http.StatusOK, gin.H{
+		"user":           user.ToResponse(),
+		"token":          token,
+		"expiresIn":      int(middleware.ImpersonationTokenTTL().Seconds()),
+		"impersonatorId": adminID,
+	})
+}
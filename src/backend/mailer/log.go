@@ -0,0 +1,36 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LogMailer writes emails to the log instead of sending them, for development.
+// If Dir is set, each email is also written to a file in that directory.
+type LogMailer struct {
+	Dir string
+}
+
+// Send logs the message and optionally writes it to a file
+func (m *LogMailer) Send(msg Message) error {
+	log.Printf("Email to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+
+	if m.Dir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(m.Dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create mail directory: %w", err)
+	}
+
+	filename := time.Now().Format("20060102-150405") + "-" + uuid.New().String() + ".eml"
+	if err := os.WriteFile(filepath.Join(m.Dir, filename), buildMessage("noreply@localhost", msg), 0o644); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+	return nil
+}
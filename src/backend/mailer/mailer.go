@@ -0,0 +1,35 @@
+package mailer
+
+import (
+	"os"
+)
+
+// Message represents an email to be sent
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// NewFromEnv creates the mailer selected by MAIL_DRIVER ("smtp" or "log", default "log")
+func NewFromEnv() Mailer {
+	switch os.Getenv("MAIL_DRIVER") {
+	case "smtp":
+		return &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+	default:
+		return &LogMailer{
+			Dir: os.Getenv("MAIL_DIR"),
+		}
+	}
+}
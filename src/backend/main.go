@@ -1,30 +1,50 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 
 	"backend/config"
+	"backend/controllers"
+	"backend/middleware"
 	"backend/models"
+	"backend/passwords"
 	"backend/routes"
 )
 
 func main() {
+	// Validate security-critical configuration before anything else
+	validateConfig()
+
 	// Set Gin mode
 	gin.SetMode(os.Getenv("GIN_MODE"))
 
 	// Initialize router
 	r := gin.Default()
 
+	// Only trust X-Forwarded-For from the proxies in TRUSTED_PROXIES, so client IPs used for
+	// rate limiting can't be spoofed. Without it the connection's address is used.
+	var trustedProxies []string
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		trustedProxies = strings.Split(proxies, ",")
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	// CORS configuration
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type"},
+		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type", "X-API-Key"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 	}))
@@ -75,6 +95,64 @@ func main() {
 	}
 }
 
+// Validate configuration and load the JWT keys, refusing to start in release mode
+// if a critical check fails
+func validateConfig() {
+	if err := loadConfig(); err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+}
+
+// loadConfig runs the configuration checks, loads the JWT keys and the password policy, and
+// returns an error if the server must not start: without keys, whatever the mode, or with a
+// failed critical check in release mode
+func loadConfig() error {
+	checks := config.ValidateConfig()
+
+	// Load JWT signing and verification keys
+	keyCheck := config.Check{Name: "jwt_keys", Critical: true, Passed: true}
+	keyErr := middleware.InitKeys()
+	if keyErr != nil {
+		keyCheck.Passed = false
+		keyCheck.Message = keyErr.Error()
+	}
+	checks = append(checks, keyCheck)
+
+	// Select the password hashing algorithm, then load the password policy and the breached password list
+	policyCheck := config.Check{Name: "password_policy", Critical: true, Passed: true}
+	if err := passwords.InitHasher(); err != nil {
+		policyCheck.Passed = false
+		policyCheck.Message = err.Error()
+	} else if err := passwords.InitPolicy(); err != nil {
+		policyCheck.Passed = false
+		policyCheck.Message = err.Error()
+	} else if passwords.CurrentPolicy().Breached == nil {
+		policyCheck.Message = "no breached password list configured"
+	}
+	checks = append(checks, policyCheck)
+	config.SetChecks(checks)
+
+	failed := false
+	for _, check := range checks {
+		if check.Passed {
+			continue
+		}
+		log.Printf("Configuration check %s failed: %s", check.Name, check.Message)
+		if check.Critical {
+			failed = true
+		}
+	}
+
+	// Tokens can't be signed without keys, whatever the mode
+	if keyErr != nil {
+		return fmt.Errorf("failed to load JWT keys: %w", keyErr)
+	}
+	if failed && config.IsReleaseMode() {
+		return errors.New("unsafe configuration in release mode")
+	}
+	return nil
+}
+
 // Initialize database connection
 func initDB() {
 	// Connect to database
@@ -82,7 +160,62 @@ func initDB() {
 	
 	// Auto-migrate models
 	db := config.GetDB()
-	db.AutoMigrate(&models.User{}, &models.Product{})
-	
+	if err := db.AutoMigrate(models.All()...); err != nil {
+		log.Fatalf("Failed to migrate database models: %v", err)
+	}
+
 	log.Println("Database models migrated successfully")
-} 
\ No newline at end of file
+
+	// Enforce in the database that audit events are append-only
+	if err := models.MigrateAuditEvents(db); err != nil {
+		log.Fatalf("Failed to protect audit events: %v", err)
+	}
+
+	// Add the generated full-text search column to products, rebuilding it if SEARCH_LANGUAGE changed
+	if err := models.MigrateProductSearch(db, config.SearchLanguage()); err != nil {
+		log.Fatalf("Failed to set up product search: %v", err)
+	}
+
+	// Set up typo-tolerant product suggestions, if pg_trgm is available
+	controllers.InitProductSuggest(db)
+
+	// Select where rate limiting counters are kept
+	middleware.InitCounterStore(db)
+
+	// Load revoked tokens, reloading them every minute to pick up other replicas' revocations
+	middleware.InitRevocationStore(db, time.Minute)
+
+	// Remove expired sessions
+	controllers.StartSessionCleanupJob(db, time.Hour)
+
+	// Erase accounts whose deletion grace period is over
+	controllers.StartAccountDeletionJob(db, time.Hour)
+
+	// Promote the bootstrap admin, if configured
+	bootstrapAdmin()
+}
+
+// Promote the user identified by ADMIN_EMAIL to admin so the first admin can be created
+func bootstrapAdmin() {
+	email := os.Getenv("ADMIN_EMAIL")
+	if email == "" {
+		return
+	}
+
+	err := controllers.BootstrapAdmin(config.GetDB(), email)
+	switch {
+	case errors.Is(err, controllers.ErrAdminExists):
+		return
+	case errors.Is(err, controllers.ErrBootstrapAdminNotFound):
+		log.Printf("Bootstrap admin %s not found or not verified; register and verify it, then restart to promote", email)
+		return
+	case errors.Is(err, controllers.ErrBootstrapAdminDemoted):
+		log.Printf("Bootstrap admin %s had its role changed before; not promoting it again", email)
+		return
+	case err != nil:
+		log.Printf("Failed to promote bootstrap admin: %v", err)
+		return
+	}
+
+	log.Printf("User %s promoted to admin", email)
+}
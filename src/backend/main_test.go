@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigJWTSecret(t *testing.T) {
+	// A configuration that passes every other critical check
+	t.Setenv("DB_HOST", "db")
+	t.Setenv("DB_USER", "app")
+	t.Setenv("DB_NAME", "app")
+	t.Setenv("DB_PORT", "5432")
+	t.Setenv("JWT_ALGORITHM", "")
+	t.Setenv("JWT_HMAC_ACCEPT_UNTIL", "")
+	t.Setenv("OIDC_PROVIDERS", "")
+	t.Setenv("PASSWORD_HASH_ALGORITHM", "")
+	t.Setenv("BREACHED_PASSWORDS_FILE", "")
+
+	strong := "kQ7v2Xb9LmPz4RtY8WcN1HsJ6FdA3GeU5oIpTrBx"
+	tests := []struct {
+		name    string
+		mode    string
+		secret  string
+		wantErr string
+	}{
+		{"missing in release mode", "release", "", "JWT_SECRET is required"},
+		{"missing in debug mode", "debug", "", "JWT_SECRET is required"},
+		{"placeholder in release mode", "release", "your_jwt_secret_here", "unsafe configuration"},
+		{"short in release mode", "release", "kQ7v2Xb9LmPz4RtY", "unsafe configuration"},
+		{"repetitive in release mode", "release", strings.Repeat("ab", 32), "unsafe configuration"},
+		{"weak in debug mode", "debug", "changeme", ""},
+		{"strong in release mode", "release", strong, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GIN_MODE", tt.mode)
+			t.Setenv("JWT_SECRET", tt.secret)
+
+			err := loadConfig()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("got %v, want no error", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("got %v, want an error about %q", err, tt.wantErr)
+			}
+		})
+	}
+}
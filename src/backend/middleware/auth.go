@@ -1,25 +1,69 @@
 package middleware
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 )
 
 // JWTClaims represents the JWT claims
 type JWTClaims struct {
-	UserID uint `json:"userId"`
+	UserID uint   `json:"userId"`
+	Role   string `json:"role"`
+	// MFA is set when the user completed multi-factor authentication
+	MFA bool `json:"mfa,omitempty"`
+	// Purpose restricts a token to a single step (e.g. MFA login); access tokens have none
+	Purpose string `json:"purpose,omitempty"`
+	// SessionID identifies the session (refresh token family) the token was issued to
+	SessionID string `json:"sid,omitempty"`
+	// ImpersonatorID is the admin acting as the user, set only on impersonation tokens
+	ImpersonatorID uint `json:"impersonatorId,omitempty"`
+	// IssuedAtMicros is the issue time in microseconds, since iat only has whole seconds
+	// and revocations must tell apart tokens issued within the same second
+	IssuedAtMicros int64 `json:"iatMicros,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// AuthMiddleware validates the JWT token in the Authorization header
+// IssueTime returns when the token was issued, as precisely as the claims tell
+func (c *JWTClaims) IssueTime() (time.Time, bool) {
+	if c.IssuedAtMicros != 0 {
+		return time.UnixMicro(c.IssuedAtMicros), true
+	}
+	if c.IssuedAt != nil {
+		return c.IssuedAt.Time, true
+	}
+	return time.Time{}, false
+}
+
+// Authentication methods stored in the context as "authMethod"
+const (
+	AuthMethodJWT    = "jwt"
+	AuthMethodAPIKey = "api_key"
+)
+
+// TokenPurposeMFAPending marks a token that can only be exchanged at /auth/mfa/verify
+const TokenPurposeMFAPending = "mfa_pending"
+
+// mfaPendingTokenTTL is how long a user has to enter the MFA code after the password
+const mfaPendingTokenTTL = 5 * time.Minute
+
+// AuthMiddleware validates the JWT token in the Authorization header, or an API key
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// API keys are sent in the X-API-Key header or as "Authorization: ApiKey {key}"
+		if apiKey := apiKeyFromRequest(c); apiKey != "" {
+			authenticateAPIKey(c, apiKey)
+			return
+		}
+
 		// Get the Authorization header
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -40,47 +84,133 @@ func AuthMiddleware() gin.HandlerFunc {
 		tokenString := parts[1]
 
 		// Parse the token
-		token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-			// Validate the signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
-
+		claims, err := parseJWT(tokenString)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
 
-		// Check if the token is valid
-		if claims, ok := token.Claims.(*JWTClaims); ok && token.Valid {
-			// Set the user ID in the context
-			c.Set("userId", claims.UserID)
-			c.Next()
-		} else {
+		// Single-purpose tokens can't be used as access tokens
+		if claims.Purpose != "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
+
+		// Check if the token has been revoked
+		if revocations != nil && revocations.IsRevoked(claims) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			c.Abort()
+			return
+		}
+
+		// Set the user ID, role and token details in the context
+		c.Set("authMethod", AuthMethodJWT)
+		c.Set("userId", claims.UserID)
+		c.Set("userRole", claims.Role)
+		c.Set("mfa", claims.MFA)
+		c.Set("tokenId", claims.ID)
+		c.Set("sessionId", claims.SessionID)
+		if issuedAt, ok := claims.IssueTime(); ok {
+			c.Set("tokenIssuedAt", issuedAt)
+		}
+		if claims.ExpiresAt != nil {
+			c.Set("tokenExpiresAt", claims.ExpiresAt.Time)
+		}
+
+		// Record activity on the session
+		if claims.SessionID != "" {
+			touchSession(claims.SessionID)
+		}
+
+		// Mark and record every request made while impersonating
+		if claims.ImpersonatorID != 0 {
+			c.Set("impersonatorId", claims.ImpersonatorID)
+			c.Header("X-Impersonated-By", strconv.FormatUint(uint64(claims.ImpersonatorID), 10))
+			c.Next()
+			recordImpersonatedRequest(c, claims)
+			return
+		}
+		c.Next()
+	}
+}
+
+// AccessTokenTTL returns how long access tokens are valid (ACCESS_TOKEN_TTL, default 15 minutes)
+func AccessTokenTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("ACCESS_TOKEN_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return 15 * time.Minute
+}
+
+// GenerateJWT generates an access token for the given claims, setting its ID and lifetime
+func GenerateJWT(claims JWTClaims) (string, error) {
+	claims.Purpose = ""
+	claims.ImpersonatorID = 0
+	return signJWT(claims, AccessTokenTTL())
+}
+
+// GenerateMFAToken generates a short-lived token proving that the user passed the password step of an MFA login
+func GenerateMFAToken(userID uint) (string, error) {
+	return signJWT(JWTClaims{UserID: userID, Purpose: TokenPurposeMFAPending}, mfaPendingTokenTTL)
+}
+
+// ParseMFAToken validates a token generated by GenerateMFAToken and returns its claims.
+// Tokens that were exchanged already, or issued before the user's tokens were revoked, are rejected.
+func ParseMFAToken(tokenString string) (*JWTClaims, error) {
+	claims, err := parseJWT(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != TokenPurposeMFAPending {
+		return nil, fmt.Errorf("unexpected token purpose: %q", claims.Purpose)
 	}
+	if revocations != nil && revocations.IsRevoked(claims) {
+		return nil, errors.New("token has been revoked")
+	}
+	return claims, nil
+}
+
+// signJWT signs the claims, setting a new token ID, the issue time and the expiry
+func signJWT(claims JWTClaims, ttl time.Duration) (string, error) {
+	if keySet == nil {
+		return "", errors.New("signing keys not initialized")
+	}
+
+	now := time.Now()
+	claims.IssuedAtMicros = now.UnixMicro()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        uuid.New().String(),
+		Issuer:    os.Getenv("JWT_ISSUER"),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		IssuedAt:  jwt.NewNumericDate(now),
+	}
+
+	// Create and sign the token
+	return keySet.sign(&claims)
 }
 
-// GenerateJWT generates a JWT token for the given user ID
-func GenerateJWT(userID uint) (string, error) {
-	// Create the claims
-	claims := &JWTClaims{
-		UserID: userID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)), // Token expires in 24 hours
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+// parseJWT parses and validates a signed token
+func parseJWT(tokenString string) (*JWTClaims, error) {
+	if keySet == nil {
+		return nil, errors.New("signing keys not initialized")
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, keySet.verificationKey)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create the token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	// Check if the token is valid
+	claims, ok := token.Claims.(*JWTClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
 
-	// Sign the token
-	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
-} 
\ No newline at end of file
+	// Check the issuer, if one is configured
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" && !claims.VerifyIssuer(issuer, true) {
+		return nil, fmt.Errorf("unexpected issuer: %s", claims.Issuer)
+	}
+	return claims, nil
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/config"
+	"backend/models"
+)
+
+// ImpersonationTokenTTL returns how long impersonation tokens are valid (IMPERSONATION_TOKEN_TTL, default 10 minutes)
+func ImpersonationTokenTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("IMPERSONATION_TOKEN_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return 10 * time.Minute
+}
+
+// GenerateImpersonationToken generates a short-lived access token that lets an admin act as
+// another user. The claims must carry the impersonated user in UserID and the admin in ImpersonatorID.
+func GenerateImpersonationToken(claims JWTClaims) (string, error) {
+	claims.Purpose = ""
+	claims.SessionID = ""
+	return signJWT(claims, ImpersonationTokenTTL())
+}
+
+// BlockImpersonation rejects requests made with an impersonation token, for actions
+// only the account owner may take (e.g. changing the password)
+func BlockImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetUint("impersonatorId") != 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed while impersonating a user"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// recordImpersonatedRequest stores a request made under impersonation, after it was handled
+func recordImpersonatedRequest(c *gin.Context, claims *JWTClaims) {
+	request := models.ImpersonationRequest{
+		ImpersonatorID: claims.ImpersonatorID,
+		UserID:         claims.UserID,
+		TokenID:        claims.ID,
+		Method:         c.Request.Method,
+		Path:           c.Request.URL.RequestURI(),
+		Status:         c.Writer.Status(),
+		IPAddress:      c.ClientIP(),
+	}
+	if err := config.GetDB().Create(&request).Error; err != nil {
+		log.Printf("Failed to record request by admin %d impersonating user %d: %v", claims.ImpersonatorID, claims.UserID, err)
+	}
+}
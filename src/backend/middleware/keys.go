@@ -0,0 +1,309 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// jwtKey is a key used to sign and/or verify tokens
+type jwtKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	SigningKey interface{} // nil for verification-only keys
+	VerifyKey  interface{}
+}
+
+// KeySet holds the key used to sign new tokens and every key accepted when verifying them
+type KeySet struct {
+	signing   *jwtKey
+	byID      map[string]*jwtKey
+	hmac      *jwtKey   // accepts tokens without a kid header, issued before keys had IDs
+	hmacUntil time.Time // when signing with another key, HMAC tokens are accepted until then
+}
+
+var keySet *KeySet
+
+// InitKeys loads the signing configuration from the environment:
+//   - JWT_ALGORITHM: HS256 (default), RS256 or EdDSA
+//   - JWT_SECRET: HMAC secret, used for HS256
+//   - JWT_HMAC_ACCEPT_UNTIL: with RS256/EdDSA, keeps accepting tokens signed with JWT_SECRET
+//     until this date (RFC 3339 or YYYY-MM-DD), while switching from HS256. Off by default.
+//   - JWT_PRIVATE_KEY_FILE: PEM private key used to sign with RS256/EdDSA
+//   - JWT_VERIFICATION_KEY_FILES: comma-separated PEM public keys of previous or upcoming
+//     keys, accepted for verification and published in the JWKS during a rotation
+func InitKeys() error {
+	set, err := loadKeySet()
+	if err != nil {
+		return err
+	}
+	keySet = set
+	return nil
+}
+
+// Keys returns the loaded key set
+func Keys() *KeySet {
+	return keySet
+}
+
+// loadKeySet builds a KeySet from the environment
+func loadKeySet() (*KeySet, error) {
+	set := &KeySet{byID: make(map[string]*jwtKey)}
+
+	var hmac *jwtKey
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		hmac = &jwtKey{
+			ID:         "hs256",
+			Method:     jwt.SigningMethodHS256,
+			SigningKey: []byte(secret),
+			VerifyKey:  []byte(secret),
+		}
+	}
+
+	algorithm := os.Getenv("JWT_ALGORITHM")
+	switch algorithm {
+	case "", "HS256":
+		if hmac == nil {
+			return nil, errors.New("JWT_SECRET is required for HS256")
+		}
+		set.signing = hmac
+		set.hmac = hmac
+		set.byID[hmac.ID] = hmac
+	case "RS256", "EdDSA":
+		// Tokens signed with the secret are only accepted during an explicit transition
+		until, err := hmacAcceptUntil()
+		if err != nil {
+			return nil, err
+		}
+		if !until.IsZero() {
+			if hmac == nil {
+				return nil, errors.New("JWT_SECRET is required to accept HS256 tokens until JWT_HMAC_ACCEPT_UNTIL")
+			}
+			set.hmac = hmac
+			set.hmacUntil = until
+			set.byID[hmac.ID] = hmac
+		}
+
+		path := os.Getenv("JWT_PRIVATE_KEY_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("JWT_PRIVATE_KEY_FILE is required for %s", algorithm)
+		}
+		key, err := loadPrivateKey(path)
+		if err != nil {
+			return nil, err
+		}
+		if key.Method.Alg() != algorithm {
+			return nil, fmt.Errorf("%s holds a %s key, but JWT_ALGORITHM is %s", path, key.Method.Alg(), algorithm)
+		}
+		set.signing = key
+		set.byID[key.ID] = key
+	default:
+		return nil, fmt.Errorf("unsupported JWT_ALGORITHM: %s", algorithm)
+	}
+
+	for _, path := range strings.Split(os.Getenv("JWT_VERIFICATION_KEY_FILES"), ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		key, err := loadPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := set.byID[key.ID]; !exists {
+			set.byID[key.ID] = key
+		}
+	}
+
+	return set, nil
+}
+
+// hmacAcceptUntil parses JWT_HMAC_ACCEPT_UNTIL, returning the zero time if it isn't set
+func hmacAcceptUntil() (time.Time, error) {
+	value := os.Getenv("JWT_HMAC_ACCEPT_UNTIL")
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if until, err := time.Parse(time.RFC3339, value); err == nil {
+		return until, nil
+	}
+	if until, err := time.Parse("2006-01-02", value); err == nil {
+		return until, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid JWT_HMAC_ACCEPT_UNTIL: %s, expected a date like 2006-01-02", value)
+}
+
+// sign signs the claims with the current signing key, adding its kid header
+func (s *KeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.signing.Method, claims)
+	if s.signing != s.hmac {
+		token.Header["kid"] = s.signing.ID
+	}
+	return token.SignedString(s.signing.SigningKey)
+}
+
+// verificationKey finds the key for a token, making sure the token's algorithm matches the key
+func (s *KeySet) verificationKey(token *jwt.Token) (interface{}, error) {
+	key := s.hmac
+	if kid, ok := token.Header["kid"].(string); ok {
+		key = s.byID[kid]
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key: %v", token.Header["kid"])
+	}
+	if key == s.hmac && key != s.signing && !time.Now().Before(s.hmacUntil) {
+		return nil, errors.New("HS256 tokens are no longer accepted")
+	}
+
+	// Validate the signing method
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.VerifyKey, nil
+}
+
+// JWKS returns the public keys as a JSON Web Key Set; HMAC secrets are never published
+func (s *KeySet) JWKS() map[string]interface{} {
+	ids := make([]string, 0, len(s.byID))
+	for id := range s.byID {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	keys := []map[string]string{}
+	for _, id := range ids {
+		key := s.byID[id]
+		if jwk := publicJWK(key.VerifyKey); jwk != nil {
+			jwk["kid"] = key.ID
+			jwk["alg"] = key.Method.Alg()
+			jwk["use"] = "sig"
+			keys = append(keys, jwk)
+		}
+	}
+	return map[string]interface{}{"keys": keys}
+}
+
+// loadPrivateKey reads a PEM encoded RSA or Ed25519 private key
+func loadPrivateKey(path string) (*jwtKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed interface{}
+	if block.Type == "RSA PRIVATE KEY" {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %w", path, err)
+	}
+
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type in %s", path)
+	}
+	key, err := newPublicKey(signer.Public())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	key.SigningKey = parsed
+	return key, nil
+}
+
+// loadPublicKey reads a PEM encoded RSA or Ed25519 public key
+func loadPublicKey(path string) (*jwtKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed interface{}
+	if block.Type == "RSA PUBLIC KEY" {
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %w", path, err)
+	}
+
+	key, err := newPublicKey(parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// readPEM reads the first PEM block of a file
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	return block, nil
+}
+
+// newPublicKey creates a verification key, deriving its kid from the RFC 7638 thumbprint
+// so the same key always gets the same ID across restarts and replicas
+func newPublicKey(public interface{}) (*jwtKey, error) {
+	var method jwt.SigningMethod
+	switch public.(type) {
+	case *rsa.PublicKey:
+		method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, errors.New("unsupported key type, expected RSA or Ed25519")
+	}
+
+	jwk := publicJWK(public)
+	thumbprint, err := json.Marshal(jwk) // map keys are sorted, as RFC 7638 requires
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(thumbprint)
+
+	return &jwtKey{
+		ID:        base64.RawURLEncoding.EncodeToString(sum[:]),
+		Method:    method,
+		VerifyKey: public,
+	}, nil
+}
+
+// publicJWK returns the required JWK members of a public key, or nil for non-public keys
+func publicJWK(public interface{}) map[string]string {
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"x":   base64.RawURLEncoding.EncodeToString(key),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHMACTokensOptInWithAsymmetricKeys(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret-that-is-long-enough-for-hs256")
+	if err := InitKeys(); err != nil {
+		t.Fatal(err)
+	}
+	hmacToken, err := GenerateJWT(JWTClaims{UserID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Switch to EdDSA
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("JWT_ALGORITHM", "EdDSA")
+	t.Setenv("JWT_PRIVATE_KEY_FILE", path)
+
+	tests := []struct {
+		name   string
+		until  string
+		accept bool
+	}{
+		{"no transition", "", false},
+		{"transition over", time.Now().Add(-time.Hour).Format(time.RFC3339), false},
+		{"during transition", time.Now().Add(time.Hour).Format(time.RFC3339), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("JWT_HMAC_ACCEPT_UNTIL", tt.until)
+			if err := InitKeys(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := parseJWT(hmacToken); (err == nil) != tt.accept {
+				t.Errorf("HS256 token accepted: %v, want %v (%v)", err == nil, tt.accept, err)
+			}
+
+			// Tokens signed with the new key are always accepted
+			token, err := GenerateJWT(JWTClaims{UserID: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := parseJWT(token); err != nil {
+				t.Errorf("EdDSA token rejected: %v", err)
+			}
+		})
+	}
+
+	t.Setenv("JWT_HMAC_ACCEPT_UNTIL", "soon")
+	if err := InitKeys(); err == nil {
+		t.Error("invalid JWT_HMAC_ACCEPT_UNTIL accepted")
+	}
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Login lockout defaults; the threshold can be changed with LOGIN_LOCKOUT_THRESHOLD
+const (
+	defaultLockoutThreshold = 5
+	lockoutBaseDuration     = 30 * time.Second
+	lockoutMaxDuration      = 15 * time.Minute
+	loginFailureWindow      = 24 * time.Hour
+)
+
+// lockoutThreshold returns how many failed logins are allowed before the account is locked
+func lockoutThreshold() int {
+	if threshold, err := strconv.Atoi(os.Getenv("LOGIN_LOCKOUT_THRESHOLD")); err == nil && threshold > 0 {
+		return threshold
+	}
+	return defaultLockoutThreshold
+}
+
+// loginFailureKey returns the counter key for failed logins of an account
+func loginFailureKey(email string) string {
+	return "login-failures:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// lockoutRemaining returns how long an account with the given failures is still locked
+func lockoutRemaining(counter Counter) time.Duration {
+	threshold := lockoutThreshold()
+	if counter.Count < threshold {
+		return 0
+	}
+
+	// Double the lockout for every failure past the threshold
+	lockout := lockoutMaxDuration
+	if shift := counter.Count - threshold; shift < 16 {
+		lockout = lockoutBaseDuration << shift
+		if lockout > lockoutMaxDuration {
+			lockout = lockoutMaxDuration
+		}
+	}
+
+	remaining := time.Until(counter.LastAt.Add(lockout))
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// BeginLoginAttempt counts a login attempt for the account before its password is checked,
+// so parallel guesses can't all get past the lockout, and returns how long the account is
+// still locked if the attempt must be rejected. The attempt stays counted as a failure
+// unless ResetLoginFailures is called after the password matched.
+func BeginLoginAttempt(email string) (time.Duration, error) {
+	key := loginFailureKey(email)
+	previous, err := counters.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if remaining := lockoutRemaining(previous); remaining > 0 {
+		return remaining, nil
+	}
+
+	counter, err := counters.Increment(key, loginFailureWindow)
+	if err != nil {
+		return 0, err
+	}
+
+	// Past the threshold only one attempt gets through each time a lockout ends; the
+	// others were counted by parallel requests after the same check
+	if counter.Count > lockoutThreshold() && counter.Count != previous.Count+1 {
+		return lockoutRemaining(counter), nil
+	}
+	return 0, nil
+}
+
+// ResetLoginFailures clears the failed logins of the account after the password matched
+func ResetLoginFailures(email string) error {
+	return counters.Reset(loginFailureKey(email))
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestBeginLoginAttemptParallel(t *testing.T) {
+	counters = NewMemoryCounterStore(0)
+	t.Setenv("LOGIN_LOCKOUT_THRESHOLD", "5")
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lockedFor, err := BeginLoginAttempt("user@example.com")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if lockedFor == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != 5 {
+		t.Errorf("got %d attempts through, want 5", allowed)
+	}
+	if lockedFor, _ := BeginLoginAttempt("USER@example.com"); lockedFor == 0 {
+		t.Error("attempt allowed while the account is locked")
+	}
+
+	if err := ResetLoginFailures("user@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if lockedFor, _ := BeginLoginAttempt("user@example.com"); lockedFor != 0 {
+		t.Errorf("attempt rejected after reset, locked for %v", lockedFor)
+	}
+}
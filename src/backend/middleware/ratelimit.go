@@ -0,0 +1,250 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"backend/models"
+)
+
+// Counter is the state of a fixed-window counter
+type Counter struct {
+	Count     int
+	LastAt    time.Time
+	ExpiresAt time.Time
+}
+
+// CounterStore keeps fixed-window counters used for rate limiting and login lockout
+type CounterStore interface {
+	// Increment adds one to the counter, starting a new window if the current one expired
+	Increment(key string, window time.Duration) (Counter, error)
+	// Get returns the counter, or a zero Counter if it doesn't exist or expired
+	Get(key string) (Counter, error)
+	// Reset removes the counter
+	Reset(key string) error
+}
+
+var counters CounterStore = NewMemoryCounterStore(time.Minute)
+
+// InitCounterStore selects the counter store (RATE_LIMIT_STORE=memory or database, default memory).
+// The database store shares counters between backend replicas.
+func InitCounterStore(db *gorm.DB) CounterStore {
+	if os.Getenv("RATE_LIMIT_STORE") == "database" {
+		counters = NewDBCounterStore(db, 10*time.Minute)
+		log.Println("Rate limiting counters stored in the database")
+	}
+	return counters
+}
+
+// Counters returns the counter store
+func Counters() CounterStore {
+	return counters
+}
+
+// MemoryCounterStore keeps counters in memory for a single backend process
+type MemoryCounterStore struct {
+	mu       sync.Mutex
+	counters map[string]Counter
+}
+
+// NewMemoryCounterStore creates a MemoryCounterStore that removes expired counters every cleanupInterval
+func NewMemoryCounterStore(cleanupInterval time.Duration) *MemoryCounterStore {
+	store := &MemoryCounterStore{counters: make(map[string]Counter)}
+
+	if cleanupInterval > 0 {
+		go func() {
+			for range time.Tick(cleanupInterval) {
+				store.cleanup()
+			}
+		}()
+	}
+
+	return store
+}
+
+// Increment adds one to the counter, starting a new window if the current one expired
+func (s *MemoryCounterStore) Increment(key string, window time.Duration) (Counter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	counter, ok := s.counters[key]
+	if !ok || !now.Before(counter.ExpiresAt) {
+		counter = Counter{ExpiresAt: now.Add(window)}
+	}
+	counter.Count++
+	counter.LastAt = now
+	s.counters[key] = counter
+
+	return counter, nil
+}
+
+// Get returns the counter, or a zero Counter if it doesn't exist or expired
+func (s *MemoryCounterStore) Get(key string) (Counter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counter, ok := s.counters[key]
+	if !ok || !time.Now().Before(counter.ExpiresAt) {
+		return Counter{}, nil
+	}
+	return counter, nil
+}
+
+// Reset removes the counter
+func (s *MemoryCounterStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.counters, key)
+	return nil
+}
+
+// cleanup removes expired counters
+func (s *MemoryCounterStore) cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, counter := range s.counters {
+		if !now.Before(counter.ExpiresAt) {
+			delete(s.counters, key)
+		}
+	}
+}
+
+// DBCounterStore keeps counters in the database so they are shared between replicas
+type DBCounterStore struct {
+	db *gorm.DB
+}
+
+// NewDBCounterStore creates a DBCounterStore that removes expired counters every cleanupInterval
+func NewDBCounterStore(db *gorm.DB, cleanupInterval time.Duration) *DBCounterStore {
+	store := &DBCounterStore{db: db}
+
+	if cleanupInterval > 0 {
+		go func() {
+			for range time.Tick(cleanupInterval) {
+				if err := store.cleanup(); err != nil {
+					log.Printf("Failed to remove expired rate limiting counters: %v", err)
+				}
+			}
+		}()
+	}
+
+	return store
+}
+
+// Increment adds one to the counter atomically, starting a new window if the current one expired
+func (s *DBCounterStore) Increment(key string, window time.Duration) (Counter, error) {
+	now := time.Now()
+
+	var counter models.RateLimitCounter
+	err := s.db.Raw(`
+		INSERT INTO rate_limit_counters (key, count, last_at, expires_at)
+		VALUES (?, 1, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			count = CASE WHEN rate_limit_counters.expires_at <= excluded.last_at THEN 1 ELSE rate_limit_counters.count + 1 END,
+			expires_at = CASE WHEN rate_limit_counters.expires_at <= excluded.last_at THEN excluded.expires_at ELSE rate_limit_counters.expires_at END,
+			last_at = excluded.last_at
+		RETURNING key, count, last_at, expires_at`,
+		key, now, now.Add(window)).Scan(&counter).Error
+	if err != nil {
+		return Counter{}, err
+	}
+
+	return Counter{Count: counter.Count, LastAt: counter.LastAt, ExpiresAt: counter.ExpiresAt}, nil
+}
+
+// Get returns the counter, or a zero Counter if it doesn't exist or expired
+func (s *DBCounterStore) Get(key string) (Counter, error) {
+	var counters []models.RateLimitCounter
+	if err := s.db.Where("key = ? AND expires_at > ?", key, time.Now()).Limit(1).Find(&counters).Error; err != nil {
+		return Counter{}, err
+	}
+	if len(counters) == 0 {
+		return Counter{}, nil
+	}
+	return Counter{Count: counters[0].Count, LastAt: counters[0].LastAt, ExpiresAt: counters[0].ExpiresAt}, nil
+}
+
+// Reset removes the counter
+func (s *DBCounterStore) Reset(key string) error {
+	return s.db.Where("key = ?", key).Delete(&models.RateLimitCounter{}).Error
+}
+
+// cleanup removes expired counters, which would otherwise pile up for every client IP and email
+func (s *DBCounterStore) cleanup() error {
+	return s.db.Where("expires_at < ?", time.Now()).Delete(&models.RateLimitCounter{}).Error
+}
+
+// SetRetryAfter sets the Retry-After header, rounding up to whole seconds
+func SetRetryAfter(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// RateLimit limits each client IP to limit requests per window for the named route group
+func RateLimit(name string, limit int, window time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limitRequest(c, "ip:"+name+":"+c.ClientIP(), limit, window)
+	}
+}
+
+// RateLimitEmail limits each email address in the JSON request body to limit requests per
+// window for the named route group, so a single account can't be flooded from many IPs.
+// Requests without an email are left for the handler to reject.
+func RateLimitEmail(name string, limit int, window time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		var data struct {
+			Email string `json:"email"`
+		}
+		json.Unmarshal(body, &data)
+		email := strings.ToLower(strings.TrimSpace(data.Email))
+		if email == "" {
+			c.Next()
+			return
+		}
+
+		limitRequest(c, "email:"+name+":"+email, limit, window)
+	}
+}
+
+// limitRequest counts a request against the counter and rejects it once the limit is exceeded
+func limitRequest(c *gin.Context, key string, limit int, window time.Duration) {
+	counter, err := counters.Increment(key, window)
+	if err != nil {
+		// Don't lock everyone out if the store is unavailable
+		log.Printf("Rate limiter error: %v", err)
+		c.Next()
+		return
+	}
+
+	if counter.Count > limit {
+		SetRetryAfter(c, time.Until(counter.ExpiresAt))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, please try again later"})
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+
+	"backend/models"
+)
+
+func TestDBCounterStoreCleanup(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.RateLimitCounter{}); err != nil {
+		t.Fatal(err)
+	}
+	store := NewDBCounterStore(db, 0)
+
+	if _, err := store.Increment("expired", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Increment("active", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if err := store.cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	if err := db.Model(&models.RateLimitCounter{}).Pluck("key", &keys).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "active" {
+		t.Errorf("got counters %v, want [active]", keys)
+	}
+	if counter, err := store.Get("active"); err != nil || counter.Count != 1 {
+		t.Errorf("active counter: %+v, %v", counter, err)
+	}
+}
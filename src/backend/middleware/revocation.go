@@ -0,0 +1,208 @@
+package middleware
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"backend/models"
+)
+
+// RevocationStore keeps track of revoked access tokens.
+// Revocations are persisted in the database and cached in memory; the cache is
+// reloaded periodically so revocations made by other replicas are picked up.
+type RevocationStore struct {
+	db       *gorm.DB
+	mu       sync.RWMutex
+	tokens   map[string]time.Time // JWT ID -> token expiry
+	users    map[uint]time.Time   // user ID -> tokens issued before this time are revoked
+	sessions map[string]time.Time // session ID -> time the session was terminated
+	// afterRead, if set, runs between reading the database and merging in Load; tests use it
+	// to revoke tokens while a reload is in progress
+	afterRead func()
+}
+
+var revocations *RevocationStore
+
+// InitRevocationStore loads the revocation store and keeps it in sync with the database
+func InitRevocationStore(db *gorm.DB, reloadInterval time.Duration) *RevocationStore {
+	store := &RevocationStore{
+		db:       db,
+		tokens:   make(map[string]time.Time),
+		users:    make(map[uint]time.Time),
+		sessions: make(map[string]time.Time),
+	}
+	if err := store.Load(); err != nil {
+		log.Printf("Failed to load token revocations: %v", err)
+	}
+
+	if reloadInterval > 0 {
+		go func() {
+			for range time.Tick(reloadInterval) {
+				if err := store.Load(); err != nil {
+					log.Printf("Failed to reload token revocations: %v", err)
+				}
+			}
+		}()
+	}
+
+	revocations = store
+	return store
+}
+
+// Revocations returns the revocation store
+func Revocations() *RevocationStore {
+	return revocations
+}
+
+// RevocationWindow returns how long a revocation has to be kept: the lifetime of the
+// longest-lived access token it may cover, including impersonation tokens
+func RevocationWindow() time.Duration {
+	return max(AccessTokenTTL(), ImpersonationTokenTTL())
+}
+
+// Load merges the revocations stored in the database into the in-memory cache, removing
+// entries that no longer matter because the tokens they cover have expired. Entries are
+// merged rather than replaced, so revocations made on this replica while the database was
+// being read are kept.
+func (s *RevocationStore) Load() error {
+	now := time.Now()
+	window := RevocationWindow()
+
+	// Expired tokens are rejected anyway, so their revocations can be dropped
+	s.db.Where("expires_at < ?", now).Delete(&models.RevokedToken{})
+	s.db.Where("revoked_before < ?", now.Add(-window)).Delete(&models.UserTokenRevocation{})
+
+	var revokedTokens []models.RevokedToken
+	if err := s.db.Find(&revokedTokens).Error; err != nil {
+		return err
+	}
+
+	var userRevocations []models.UserTokenRevocation
+	if err := s.db.Find(&userRevocations).Error; err != nil {
+		return err
+	}
+
+	// Access tokens of sessions terminated longer ago than their lifetime have expired
+	var revokedSessions []models.Session
+	if err := s.db.Select("id", "revoked_at").Where("revoked_at > ?", now.Add(-window)).Find(&revokedSessions).Error; err != nil {
+		return err
+	}
+
+	if s.afterRead != nil {
+		s.afterRead()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range revokedTokens {
+		s.tokens[t.JTI] = t.ExpiresAt
+	}
+	for _, u := range userRevocations {
+		if u.RevokedBefore.After(s.users[u.UserID]) {
+			s.users[u.UserID] = u.RevokedBefore
+		}
+	}
+	for _, session := range revokedSessions {
+		if _, ok := s.sessions[session.ID]; !ok {
+			s.sessions[session.ID] = *session.RevokedAt
+		}
+	}
+
+	// Drop the entries whose tokens have all expired
+	for jti, expiresAt := range s.tokens {
+		if expiresAt.Before(now) {
+			delete(s.tokens, jti)
+		}
+	}
+	for userID, revokedBefore := range s.users {
+		if revokedBefore.Before(now.Add(-window)) {
+			delete(s.users, userID)
+		}
+	}
+	for sessionID, revokedAt := range s.sessions {
+		if !revokedAt.After(now.Add(-window)) {
+			delete(s.sessions, sessionID)
+		}
+	}
+	return nil
+}
+
+// RevokeToken revokes a single access token
+func (s *RevocationStore) RevokeToken(jti string, userID uint, expiresAt time.Time) error {
+	revoked := models.RevokedToken{JTI: jti, UserID: userID, ExpiresAt: expiresAt}
+	if err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&revoked).Error; err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.tokens[jti] = expiresAt
+	s.mu.Unlock()
+	return nil
+}
+
+// RevokeAllForUser revokes every access token issued to the user so far
+func (s *RevocationStore) RevokeAllForUser(userID uint) error {
+	// Tokens issued up to now are revoked; tokens carry their issue time in microseconds,
+	// so the ones issued right after (e.g. after a password change) stay valid
+	revokedBefore := time.Now()
+
+	revocation := models.UserTokenRevocation{UserID: userID, RevokedBefore: revokedBefore}
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"revoked_before", "updated_at"}),
+	}).Create(&revocation).Error
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.users[userID] = revokedBefore
+	s.mu.Unlock()
+	return nil
+}
+
+// RevokeSession rejects the access tokens of a session terminated in the database.
+// Other replicas pick the termination up when they reload.
+func (s *RevocationStore) RevokeSession(sessionID string, revokedAt time.Time) {
+	s.mu.Lock()
+	s.sessions[sessionID] = revokedAt
+	s.mu.Unlock()
+}
+
+// IsRevoked checks if the token described by the claims has been revoked
+func (s *RevocationStore) IsRevoked(claims *JWTClaims) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.tokens[claims.ID]; ok {
+		return true
+	}
+	if claims.SessionID != "" {
+		if _, ok := s.sessions[claims.SessionID]; ok {
+			return true
+		}
+	}
+	if revokedBefore, ok := s.users[claims.UserID]; ok && issuedBy(claims, revokedBefore) {
+		return true
+	}
+	// Impersonation ends when the admin's own tokens are revoked
+	if claims.ImpersonatorID != 0 {
+		if revokedBefore, ok := s.users[claims.ImpersonatorID]; ok && issuedBy(claims, revokedBefore) {
+			return true
+		}
+	}
+	return false
+}
+
+// issuedBy checks if the token was issued at or before the given time. Tokens from before
+// issue times had microseconds only have whole seconds, so those issued in the same second
+// as a revocation are revoked too.
+func issuedBy(claims *JWTClaims, t time.Time) bool {
+	issuedAt, ok := claims.IssueTime()
+	return !ok || !issuedAt.After(t)
+}
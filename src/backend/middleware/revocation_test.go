@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+
+	"backend/models"
+)
+
+func TestRevokeAllForUserWithinTheSameSecond(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret-that-is-long-enough-for-hs256")
+	if err := InitKeys(); err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.RevokedToken{}, &models.UserTokenRevocation{}, &models.Session{}); err != nil {
+		t.Fatal(err)
+	}
+	store := InitRevocationStore(db, 0)
+	t.Cleanup(func() { revocations = nil })
+
+	parse := func() *JWTClaims {
+		token, err := GenerateJWT(JWTClaims{UserID: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := parseJWT(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return claims
+	}
+
+	before := parse()
+	if err := store.RevokeAllForUser(1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	after := parse()
+
+	// Reload from the database, as another replica would
+	if err := store.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !store.IsRevoked(before) {
+		t.Error("token issued before the revocation is still valid")
+	}
+	if store.IsRevoked(after) {
+		t.Error("token issued after the revocation is revoked")
+	}
+
+	// Tokens without microseconds fall back to iat, revoking them within the whole second
+	legacy := *before
+	legacy.IssuedAtMicros = 0
+	if !store.IsRevoked(&legacy) {
+		t.Error("token with a whole-second issue time is still valid")
+	}
+}
+
+func TestRevocationsOutliveImpersonationTokens(t *testing.T) {
+	t.Setenv("ACCESS_TOKEN_TTL", "15m")
+	t.Setenv("IMPERSONATION_TOKEN_TTL", "1h")
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.RevokedToken{}, &models.UserTokenRevocation{}, &models.Session{}); err != nil {
+		t.Fatal(err)
+	}
+	store := InitRevocationStore(db, 0)
+	t.Cleanup(func() { revocations = nil })
+
+	// The user's tokens were revoked half an hour ago, longer than access tokens live but
+	// not impersonation tokens
+	revocation := models.UserTokenRevocation{UserID: 1, RevokedBefore: time.Now().Add(-30 * time.Minute)}
+	if err := db.Create(&revocation).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	impersonation := &JWTClaims{UserID: 1, ImpersonatorID: 2, IssuedAtMicros: time.Now().Add(-45 * time.Minute).UnixMicro()}
+	if !store.IsRevoked(impersonation) {
+		t.Error("impersonation token issued before the revocation is valid again")
+	}
+}
+
+func TestLoadKeepsRevocationsMadeWhileReloading(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.RevokedToken{}, &models.UserTokenRevocation{}, &models.Session{}); err != nil {
+		t.Fatal(err)
+	}
+	store := InitRevocationStore(db, 0)
+	t.Cleanup(func() { revocations = nil })
+
+	issuedAt := time.Now().Add(-time.Second).UnixMicro()
+	loggedOut := &JWTClaims{UserID: 1, SessionID: "session-1", IssuedAtMicros: issuedAt}
+	revokedToken := &JWTClaims{UserID: 2, IssuedAtMicros: issuedAt}
+	revokedToken.ID = "token-1"
+	revokedUser := &JWTClaims{UserID: 3, IssuedAtMicros: issuedAt}
+
+	// Another request revokes tokens after the database was read, before the cache is updated
+	store.afterRead = func() {
+		store.RevokeSession("session-1", time.Now())
+		if err := store.RevokeToken("token-1", 2, time.Now().Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.RevokeAllForUser(3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, claims := range map[string]*JWTClaims{"session": loggedOut, "token": revokedToken, "user": revokedUser} {
+		if !store.IsRevoked(claims) {
+			t.Errorf("%s revoked during the reload is valid", name)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"time"
+
+	"backend/config"
+	"backend/models"
+)
+
+// sessionLastSeenPrecision is how often a session's last activity is recorded
+const sessionLastSeenPrecision = time.Minute
+
+// touchSession records activity on a session, at most once per sessionLastSeenPrecision
+func touchSession(sessionID string) {
+	now := time.Now()
+	config.GetDB().Model(&models.Session{}).
+		Where("id = ? AND last_seen_at < ?", sessionID, now.Add(-sessionLastSeenPrecision)).
+		UpdateColumn("last_seen_at", now)
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/config"
+	"backend/models"
+)
+
+// EmailVerificationRequired reports whether unverified accounts are blocked from
+// protected mutations (REQUIRE_EMAIL_VERIFICATION=true)
+func EmailVerificationRequired() bool {
+	return os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true"
+}
+
+// RequireVerifiedEmail rejects users whose email is not verified, when the policy is enabled.
+// It must be used after AuthMiddleware, which sets the user ID in the context.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !EmailVerificationRequired() {
+			c.Next()
+			return
+		}
+
+		// Get user ID from context (set by auth middleware)
+		userID, exists := c.Get("userId")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		// Find user by ID
+		var user models.User
+		if err := config.GetDB().Select("id", "email_verified_at").First(&user, userID).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		if user.EmailVerifiedAt == nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email address must be verified"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,170 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Audit event actions; more specific actions describe security-relevant changes
+const (
+	AuditActionCreate                  = "create"
+	AuditActionUpdate                  = "update"
+	AuditActionDelete                  = "delete"
+	AuditActionPasswordChange          = "password_change"
+	AuditActionPasswordReset           = "password_reset"
+	AuditActionRoleChange              = "role_change"
+	AuditActionMFASetup                = "mfa_setup"
+	AuditActionMFAEnable               = "mfa_enable"
+	AuditActionMFADisable              = "mfa_disable"
+	AuditActionRecoveryCodesRegenerate = "recovery_codes_regenerate"
+	AuditActionImpersonate             = "impersonate"
+	AuditActionRevoke                  = "revoke"
+	AuditActionSessionRevoke           = "session_revoke"
+)
+
+// Audited entity types
+const (
+	AuditEntityUser     = "user"
+	AuditEntityProduct  = "product"
+	AuditEntityAPIKey   = "api_key"
+	AuditEntityCategory = "category"
+	AuditEntityTag      = "tag"
+	AuditEntityVariant  = "product_variant"
+)
+
+// ErrAuditEventImmutable is returned when trying to change or remove an audit event
+var ErrAuditEventImmutable = errors.New("audit events are append-only")
+
+// AuditEvent records a change to an entity: who made it, what changed and from where.
+// Events are append-only, enforced by hooks and, on PostgreSQL, by a trigger; only the
+// erasure of a deleted user's personal data bypasses them.
+type AuditEvent struct {
+	ID             uint  `gorm:"primaryKey"`
+	ActorID        *uint `gorm:"index"`
+	ImpersonatorID *uint `gorm:"index"`
+	APIKeyID       *uint
+	Action         string `gorm:"index;not null"`
+	EntityType     string `gorm:"index:idx_audit_events_entity;not null"`
+	EntityID       uint   `gorm:"index:idx_audit_events_entity"`
+	// Changes holds the changed fields as JSON: {"field": {"from": ..., "to": ...}}
+	Changes   string `gorm:"type:text"`
+	Method    string
+	Path      string
+	IPAddress string
+	UserAgent string
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// AuditEventResponse represents the audit event data that is sent back to the client
+type AuditEventResponse struct {
+	ID             uint            `json:"id"`
+	ActorID        *uint           `json:"actorId"`
+	ImpersonatorID *uint           `json:"impersonatorId,omitempty"`
+	APIKeyID       *uint           `json:"apiKeyId,omitempty"`
+	Action         string          `json:"action"`
+	EntityType     string          `json:"entityType"`
+	EntityID       uint            `json:"entityId"`
+	Changes        json.RawMessage `json:"changes"`
+	Method         string          `json:"method"`
+	Path           string          `json:"path"`
+	IPAddress      string          `json:"ipAddress"`
+	UserAgent      string          `json:"userAgent"`
+	CreatedAt      time.Time       `json:"createdAt"`
+}
+
+// BeforeUpdate keeps audit events from being changed
+func (e *AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditEventImmutable
+}
+
+// BeforeDelete keeps audit events from being removed
+func (e *AuditEvent) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditEventImmutable
+}
+
+// MigrateAuditEvents adds a trigger that makes audit events append-only in the database
+// itself, so they can't be changed or removed by bypassing the hooks. The only change
+// allowed is erasing a deleted user's personal data in a transaction that called
+// AllowAuditErasure for them: clearing the IP address and user agent of the events they
+// performed, and rewriting the changes of the events about them. Who did what to which
+// entity, and when, stays as it was. It is a no-op outside PostgreSQL.
+func MigrateAuditEvents(db *gorm.DB) error {
+	if db.Dialector.Name() != "postgres" {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range []string{
+			`CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+			DECLARE
+				erased bigint := NULLIF(current_setting('app.audit_erasure_user', true), '')::bigint;
+			BEGIN
+				IF TG_OP = 'UPDATE' AND erased IS NOT NULL THEN
+					IF (NEW.id, NEW.actor_id, NEW.impersonator_id, NEW.api_key_id, NEW.action,
+							NEW.entity_type, NEW.entity_id, NEW.method, NEW.path, NEW.created_at)
+						IS NOT DISTINCT FROM
+						(OLD.id, OLD.actor_id, OLD.impersonator_id, OLD.api_key_id, OLD.action,
+							OLD.entity_type, OLD.entity_id, OLD.method, OLD.path, OLD.created_at)
+						AND ((NEW.ip_address, NEW.user_agent) IS NOT DISTINCT FROM (OLD.ip_address, OLD.user_agent)
+							OR ((OLD.actor_id = erased OR OLD.impersonator_id = erased)
+								AND NEW.ip_address = '' AND NEW.user_agent = ''))
+						AND (NEW.changes IS NOT DISTINCT FROM OLD.changes
+							OR (OLD.entity_type = 'user' AND OLD.entity_id = erased))
+					THEN
+						RETURN NEW;
+					END IF;
+				END IF;
+				RAISE EXCEPTION 'audit events are append-only';
+			END;
+			$$ LANGUAGE plpgsql`,
+			`DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events`,
+			`CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+				FOR EACH ROW EXECUTE FUNCTION audit_events_append_only()`,
+			`DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events`,
+			`CREATE TRIGGER audit_events_no_truncate BEFORE TRUNCATE ON audit_events
+				FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only()`,
+		} {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AllowAuditErasure lets the rest of the transaction erase the personal data of the deleted
+// user from audit events, which the trigger added by MigrateAuditEvents otherwise rejects
+func AllowAuditErasure(tx *gorm.DB, userID uint) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	return tx.Exec(`SELECT set_config('app.audit_erasure_user', ?, true)`, strconv.FormatUint(uint64(userID), 10)).Error
+}
+
+// ToResponse converts an AuditEvent to an AuditEventResponse
+func (e *AuditEvent) ToResponse() AuditEventResponse {
+	changes := json.RawMessage(e.Changes)
+	if len(changes) == 0 {
+		changes = json.RawMessage("{}")
+	}
+
+	return AuditEventResponse{
+		ID:             e.ID,
+		ActorID:        e.ActorID,
+		ImpersonatorID: e.ImpersonatorID,
+		APIKeyID:       e.APIKeyID,
+		Action:         e.Action,
+		EntityType:     e.EntityType,
+		EntityID:       e.EntityID,
+		Changes:        changes,
+		Method:         e.Method,
+		Path:           e.Path,
+		IPAddress:      e.IPAddress,
+		UserAgent:      e.UserAgent,
+		CreatedAt:      e.CreatedAt,
+	}
+}
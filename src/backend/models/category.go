@@ -0,0 +1,93 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Category is a node of the product taxonomy. Path is the materialized path of the IDs from
+// the root down to the category, like "/1/4/9/", so the categories under a category are those
+// whose path starts with its own.
+type Category struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"not null" json:"name"`
+	ParentID  *uint     `gorm:"index" json:"parentId"`
+	Path      string    `gorm:"index;not null" json:"-"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Ancestors are the categories above this one, root first, when loaded
+	Ancestors []Category `gorm:"-" json:"-"`
+}
+
+// CategoryRef names a category in breadcrumbs
+type CategoryRef struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+// CategoryResponse represents the category data that is sent back to the client
+type CategoryResponse struct {
+	ID          uint          `json:"id"`
+	Name        string        `json:"name"`
+	ParentID    *uint         `json:"parentId"`
+	Breadcrumbs []CategoryRef `json:"breadcrumbs"`
+	CreatedAt   time.Time     `json:"createdAt"`
+	UpdatedAt   time.Time     `json:"updatedAt"`
+}
+
+// CategoryPath returns the materialized path of a category under the given parent path,
+// which is empty for a root category
+func CategoryPath(parentPath string, id uint) string {
+	if parentPath == "" {
+		parentPath = "/"
+	}
+	return parentPath + strconv.FormatUint(uint64(id), 10) + "/"
+}
+
+// AncestorIDs returns the IDs of the categories above this one, root first
+func (c *Category) AncestorIDs() []uint {
+	var ids []uint
+	for _, part := range strings.Split(strings.Trim(c.Path, "/"), "/") {
+		id, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || uint(id) == c.ID {
+			continue
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids
+}
+
+// IsAncestorOf checks if the category is other or one of the categories above it
+func (c *Category) IsAncestorOf(other *Category) bool {
+	return strings.HasPrefix(other.Path, c.Path)
+}
+
+// Breadcrumbs returns the trail from the root down to the category, which needs the
+// ancestors loaded
+func (c *Category) Breadcrumbs() []CategoryRef {
+	breadcrumbs := make([]CategoryRef, 0, len(c.Ancestors)+1)
+	for _, ancestor := range c.Ancestors {
+		breadcrumbs = append(breadcrumbs, CategoryRef{ID: ancestor.ID, Name: ancestor.Name})
+	}
+	return append(breadcrumbs, CategoryRef{ID: c.ID, Name: c.Name})
+}
+
+// ToResponse converts a Category to a CategoryResponse
+func (c *Category) ToResponse() CategoryResponse {
+	return CategoryResponse{
+		ID:          c.ID,
+		Name:        c.Name,
+		ParentID:    c.ParentID,
+		Breadcrumbs: c.Breadcrumbs(),
+		CreatedAt:   c.CreatedAt,
+		UpdatedAt:   c.UpdatedAt,
+	}
+}
+
+// CategoryNode is a category in the category tree
+type CategoryNode struct {
+	ID       uint           `json:"id"`
+	Name     string         `json:"name"`
+	Children []CategoryNode `json:"children"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// ImpersonationRequest records a request an admin made while impersonating a user
+type ImpersonationRequest struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	ImpersonatorID uint      `gorm:"index;not null" json:"impersonatorId"`
+	UserID         uint      `gorm:"index;not null" json:"userId"`
+	TokenID        string    `gorm:"index" json:"tokenId"`
+	Method         string    `json:"method"`
+	Path           string    `json:"path"`
+	Status         int       `json:"status"`
+	IPAddress      string    `json:"ipAddress"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
@@ -0,0 +1,6 @@
+package models
+
+// All returns every model, for migrating the database
+func All() []interface{} {
+	return []interface{}{&User{}, &Category{}, &Tag{}, &Product{}, &ProductOption{}, &ProductOptionValue{}, &ProductVariant{}, &RefreshToken{}, &RevokedToken{}, &UserTokenRevocation{}, &UserToken{}, &RateLimitCounter{}, &RecoveryCode{}, &APIKey{}, &UserIdentity{}, &OIDCLoginState{}, &Session{}, &ImpersonationRequest{}, &AuditEvent{}}
+}
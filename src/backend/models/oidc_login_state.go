@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+)
+
+// OIDCLoginState holds the secrets of an OpenID Connect login in progress until the
+// identity provider redirects back. Only the hash of the state parameter is stored.
+type OIDCLoginState struct {
+	StateHash    string    `gorm:"primaryKey"`
+	Provider     string    `gorm:"not null"`
+	Nonce        string    `gorm:"not null"`
+	CodeVerifier string    `gorm:"not null"`
+	ExpiresAt    time.Time `gorm:"index"`
+	CreatedAt    time.Time
+}
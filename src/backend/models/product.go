@@ -6,28 +6,44 @@ import (
 	"gorm.io/gorm"
 )
 
-// Product represents a product in the system
+// Product represents a product in the system.
+// The products table also has a generated search_vector column, see MigrateProductSearch.
 type Product struct {
-	ID          uint      `gorm:"primaryKey" json:"id"`
-	Name        string    `json:"name" binding:"required"`
-	Description string    `json:"description" binding:"required"`
-	Price       float64   `json:"price" binding:"required,min=0"`
-	Quantity    int       `json:"quantity" binding:"required,min=0"`
-	ImagePath   string    `json:"imagePath"`
-	CreatedAt   time.Time `json:"createdAt"`
-	UpdatedAt   time.Time `json:"updatedAt"`
+	ID          uint             `gorm:"primaryKey" json:"id"`
+	Name        string           `json:"name" binding:"required"`
+	Description string           `json:"description" binding:"required"`
+	Price       float64          `json:"price" binding:"required,min=0"`
+	Quantity    int              `json:"quantity" binding:"required,min=0"`
+	ImagePath   string           `json:"imagePath"`
+	CreatedAt   time.Time        `json:"createdAt"`
+	UpdatedAt   time.Time        `json:"updatedAt"`
+	Categories  []Category       `gorm:"many2many:product_categories;" json:"-"`
+	Tags        []Tag            `gorm:"many2many:product_tags;" json:"-"`
+	Options     []ProductOption  `json:"-"`
+	Variants    []ProductVariant `json:"-"`
+}
+
+// ProductCategory is a category of a product, with the breadcrumbs from the root down to it
+type ProductCategory struct {
+	ID          uint          `json:"id"`
+	Name        string        `json:"name"`
+	Breadcrumbs []CategoryRef `json:"breadcrumbs"`
 }
 
 // ProductResponse represents the product data that is sent back to the client
 type ProductResponse struct {
-	ID          uint      `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Price       float64   `json:"price"`
-	Quantity    int       `json:"quantity"`
-	ImagePath   string    `json:"imagePath"`
-	CreatedAt   time.Time `json:"createdAt"`
-	UpdatedAt   time.Time `json:"updatedAt"`
+	ID          uint                     `json:"id"`
+	Name        string                   `json:"name"`
+	Description string                   `json:"description"`
+	Price       float64                  `json:"price"`
+	Quantity    int                      `json:"quantity"`
+	ImagePath   string                   `json:"imagePath"`
+	CreatedAt   time.Time                `json:"createdAt"`
+	UpdatedAt   time.Time                `json:"updatedAt"`
+	Categories  []ProductCategory        `json:"categories"`
+	Tags        []string                 `json:"tags"`
+	Options     []ProductOptionResponse  `json:"options"`
+	Variants    []ProductVariantResponse `json:"variants"`
 }
 
 // BeforeCreate is a GORM hook that runs before creating a product
@@ -36,8 +52,30 @@ func (p *Product) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
-// ToResponse converts a Product to a ProductResponse
+// ToResponse converts a Product to a ProductResponse; categories, tags, options and variants
+// are only included if loaded
 func (p *Product) ToResponse() ProductResponse {
+	categories := make([]ProductCategory, 0, len(p.Categories))
+	for _, category := range p.Categories {
+		categories = append(categories, ProductCategory{
+			ID:          category.ID,
+			Name:        category.Name,
+			Breadcrumbs: category.Breadcrumbs(),
+		})
+	}
+	tags := make([]string, 0, len(p.Tags))
+	for _, tag := range p.Tags {
+		tags = append(tags, tag.Name)
+	}
+	options := make([]ProductOptionResponse, 0, len(p.Options))
+	for _, option := range p.Options {
+		options = append(options, option.ToResponse())
+	}
+	variants := make([]ProductVariantResponse, 0, len(p.Variants))
+	for _, variant := range p.Variants {
+		variants = append(variants, variant.ToResponse(p.Options))
+	}
+
 	return ProductResponse{
 		ID:          p.ID,
 		Name:        p.Name,
@@ -47,5 +85,9 @@ func (p *Product) ToResponse() ProductResponse {
 		ImagePath:   p.ImagePath,
 		CreatedAt:   p.CreatedAt,
 		UpdatedAt:   p.UpdatedAt,
+		Categories:  categories,
+		Tags:        tags,
+		Options:     options,
+		Variants:    variants,
 	}
-} 
\ No newline at end of file
+}
@@ -0,0 +1,30 @@
+package models
+
+// ProductFacets counts the products matching a filter by tag, category and price range
+type ProductFacets struct {
+	Tags       []TagFacet      `json:"tags"`
+	Categories []CategoryFacet `json:"categories"`
+	Prices     []PriceFacet    `json:"prices"`
+}
+
+// TagFacet is the number of matching products with a tag
+type TagFacet struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// CategoryFacet is the number of matching products in a category or the categories under it
+type CategoryFacet struct {
+	ID       uint   `json:"id"`
+	Name     string `json:"name"`
+	ParentID *uint  `json:"parentId"`
+	Count    int64  `json:"count"`
+}
+
+// PriceFacet is the number of matching products priced from Min up to, but not including,
+// Max; the last range has no Max
+type PriceFacet struct {
+	Min   float64  `json:"min"`
+	Max   *float64 `json:"max"`
+	Count int64    `json:"count"`
+}
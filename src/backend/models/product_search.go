@@ -0,0 +1,99 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ErrSearchUnsupported is returned when the database can't run full-text search
+var ErrSearchUnsupported = errors.New("full-text search requires PostgreSQL")
+
+// searchLanguagePattern matches the names of text search configurations
+var searchLanguagePattern = regexp.MustCompile(`^[a-z_]+$`)
+
+// ProductSearchResult is a product found by full-text search, with its relevance and the
+// matching words of its name and description highlighted
+type ProductSearchResult struct {
+	ProductResponse
+	Rank       float64           `json:"rank"`
+	Highlights ProductHighlights `json:"highlights"`
+}
+
+// ProductHighlights holds HTML-escaped text in which matching words are wrapped in <mark>
+type ProductHighlights struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// MigrateProductSearch adds the search_vector column to products: a tsvector the database
+// generates from the name (weight A) and description (weight B) with the given text search
+// configuration, with a GIN index. The column is rebuilt when the configuration changes.
+func MigrateProductSearch(db *gorm.DB, language string) error {
+	if db.Dialector.Name() != "postgres" {
+		return ErrSearchUnsupported
+	}
+	if !searchLanguagePattern.MatchString(language) {
+		return fmt.Errorf("invalid text search configuration %q", language)
+	}
+
+	// Keep the column if it was generated with the same configuration
+	var expression string
+	if err := db.Raw(`SELECT COALESCE(generation_expression, '') FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'products' AND column_name = 'search_vector'`).
+		Scan(&expression).Error; err != nil {
+		return err
+	}
+	if strings.Contains(expression, "'"+language+"'::regconfig") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range []string{
+			`DROP INDEX IF EXISTS idx_products_search_vector`,
+			`ALTER TABLE products DROP COLUMN IF EXISTS search_vector`,
+			fmt.Sprintf(`ALTER TABLE products ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+				setweight(to_tsvector('%[1]s'::regconfig, coalesce(name, '')), 'A') ||
+				setweight(to_tsvector('%[1]s'::regconfig, coalesce(description, '')), 'B')
+			) STORED`, language),
+			`CREATE INDEX idx_products_search_vector ON products USING GIN (search_vector)`,
+		} {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ProductSuggestion is a product name suggested while the user types
+type ProductSuggestion struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+// MigrateProductSuggest enables the pg_trgm extension and adds a trigram index on product
+// names, so suggestions can tolerate typos. It fails when the extension can't be created,
+// for instance when the database user isn't allowed to.
+func MigrateProductSuggest(db *gorm.DB) error {
+	if db.Dialector.Name() != "postgres" {
+		return errors.New("trigram similarity requires PostgreSQL")
+	}
+
+	// Creating the extension needs privileges, so only try when it isn't there yet
+	var installed bool
+	if err := db.Raw(`SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pg_trgm')`).
+		Scan(&installed).Error; err != nil {
+		return err
+	}
+	if !installed {
+		if err := db.Exec(`CREATE EXTENSION IF NOT EXISTS pg_trgm`).Error; err != nil {
+			return fmt.Errorf("pg_trgm extension unavailable: %w", err)
+		}
+	}
+
+	return db.Exec(`CREATE INDEX IF NOT EXISTS idx_products_name_trgm ON products USING GIN (name gin_trgm_ops)`).Error
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"time"
+)
+
+// ProductOption is an option type a product comes in, like size or color, with the values
+// its variants can take
+type ProductOption struct {
+	ID        uint                 `gorm:"primaryKey"`
+	ProductID uint                 `gorm:"index;not null"`
+	Name      string               `gorm:"not null"`
+	Position  int                  `gorm:"not null"`
+	Values    []ProductOptionValue `gorm:"foreignKey:OptionID"`
+}
+
+// ProductOptionValue is a value of a product option, like "M" for size
+type ProductOptionValue struct {
+	ID       uint   `gorm:"primaryKey"`
+	OptionID uint   `gorm:"index;not null"`
+	Value    string `gorm:"not null"`
+	Position int    `gorm:"not null"`
+}
+
+// ProductVariant is a purchasable version of a product with one value for each of the
+// product's options, and its own SKU, price, stock and image
+type ProductVariant struct {
+	ID           uint    `gorm:"primaryKey"`
+	ProductID    uint    `gorm:"index;not null"`
+	SKU          string  `gorm:"column:sku;uniqueIndex;not null"`
+	Price        float64 `gorm:"not null"`
+	Quantity     int     `gorm:"not null"`
+	ImagePath    string
+	OptionValues []ProductOptionValue `gorm:"many2many:product_variant_values;"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// ProductOptionResponse represents the option data that is sent back to the client
+type ProductOptionResponse struct {
+	ID     uint     `json:"id"`
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// ProductVariantResponse represents the variant data that is sent back to the client;
+// Options maps each option name to the variant's value
+type ProductVariantResponse struct {
+	ID        uint              `json:"id"`
+	SKU       string            `json:"sku"`
+	Price     float64           `json:"price"`
+	Quantity  int               `json:"quantity"`
+	ImagePath string            `json:"imagePath"`
+	Options   map[string]string `json:"options"`
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+// ToResponse converts a ProductOption to a ProductOptionResponse
+func (o *ProductOption) ToResponse() ProductOptionResponse {
+	values := make([]string, 0, len(o.Values))
+	for _, value := range o.Values {
+		values = append(values, value.Value)
+	}
+	return ProductOptionResponse{
+		ID:     o.ID,
+		Name:   o.Name,
+		Values: values,
+	}
+}
+
+// ToResponse converts a ProductVariant to a ProductVariantResponse, naming its values after
+// the product's options
+func (v *ProductVariant) ToResponse(options []ProductOption) ProductVariantResponse {
+	optionNames := make(map[uint]string, len(options))
+	for _, option := range options {
+		optionNames[option.ID] = option.Name
+	}
+
+	values := make(map[string]string, len(v.OptionValues))
+	for _, value := range v.OptionValues {
+		values[optionNames[value.OptionID]] = value.Value
+	}
+
+	return ProductVariantResponse{
+		ID:        v.ID,
+		SKU:       v.SKU,
+		Price:     v.Price,
+		Quantity:  v.Quantity,
+		ImagePath: v.ImagePath,
+		Options:   values,
+		CreatedAt: v.CreatedAt,
+		UpdatedAt: v.UpdatedAt,
+	}
+}
@@ -0,0 +1,13 @@
+package models
+
+import (
+	"time"
+)
+
+// RateLimitCounter stores a rate limiting counter shared by all backend replicas
+type RateLimitCounter struct {
+	Key       string    `gorm:"primaryKey" json:"key"`
+	Count     int       `gorm:"not null" json:"count"`
+	LastAt    time.Time `json:"lastAt"`
+	ExpiresAt time.Time `gorm:"index" json:"expiresAt"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// RevokedToken represents an access token revoked before its expiry, identified by its JWT ID
+type RevokedToken struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	JTI       string    `gorm:"uniqueIndex;not null" json:"jti"`
+	UserID    uint      `gorm:"index;not null" json:"userId"`
+	ExpiresAt time.Time `gorm:"index" json:"expiresAt"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// UserTokenRevocation revokes every access token of a user issued before RevokedBefore
+type UserTokenRevocation struct {
+	UserID        uint      `gorm:"primaryKey" json:"userId"`
+	RevokedBefore time.Time `json:"revokedBefore"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
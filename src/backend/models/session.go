@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+)
+
+// Session represents a signed-in device. Its ID is the family ID of the refresh
+// tokens issued to the device, and access tokens carry it in the "sid" claim.
+type Session struct {
+	ID         string     `gorm:"primaryKey;size:36" json:"id"`
+	UserID     uint       `gorm:"index;not null" json:"userId"`
+	Device     string     `json:"device"`
+	IPAddress  string     `json:"ipAddress"`
+	UserAgent  string     `json:"userAgent"`
+	LastSeenAt time.Time  `json:"lastSeenAt"`
+	ExpiresAt  time.Time  `gorm:"index" json:"expiresAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// SessionResponse represents the session data that is sent back to the client
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	IPAddress  string    `json:"ipAddress"`
+	UserAgent  string    `json:"userAgent"`
+	Current    bool      `json:"current"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// IsActive checks if the session has not been terminated or expired
+func (s *Session) IsActive() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
+
+// ToResponse converts a Session to a SessionResponse; current marks the session making the request
+func (s *Session) ToResponse(current bool) SessionResponse {
+	return SessionResponse{
+		ID:         s.ID,
+		Device:     s.Device,
+		IPAddress:  s.IPAddress,
+		UserAgent:  s.UserAgent,
+		Current:    current,
+		LastSeenAt: s.LastSeenAt,
+		CreatedAt:  s.CreatedAt,
+	}
+}
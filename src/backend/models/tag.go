@@ -0,0 +1,43 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Tag is a free-form label on products, like "promo" or "vegan". Names are stored
+// normalized, see NormalizeTagName.
+type Tag struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"uniqueIndex;not null" json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// TagResponse represents the tag data that is sent back to the client
+type TagResponse struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// NormalizeTagName lowercases a tag name and collapses its whitespace, so "Promo " and
+// "promo" are the same tag
+func NormalizeTagName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// ToResponse converts a Tag to a TagResponse
+func (t *Tag) ToResponse() TagResponse {
+	return TagResponse{
+		ID:        t.ID,
+		Name:      t.Name,
+		CreatedAt: t.CreatedAt,
+	}
+}
+
+// TagSummary is a tag with the number of products labeled with it
+type TagSummary struct {
+	ID           uint   `json:"id"`
+	Name         string `json:"name"`
+	ProductCount int64  `json:"productCount"`
+}
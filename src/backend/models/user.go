@@ -3,68 +3,127 @@ package models
 import (
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+
+	"backend/passwords"
+)
+
+// User roles
+const (
+	RoleUser   = "user"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
 )
 
 // User represents a user in the system
 type User struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	Name      string    `json:"name" binding:"required"`
-	Email     string    `json:"email" binding:"required,email" gorm:"unique"`
-	Password  string    `json:"password,omitempty" binding:"required,min=6"`
-	ImagePath string    `json:"imagePath"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	Name            string     `json:"name" binding:"required"`
+	Email           string     `json:"email" binding:"required,email" gorm:"unique"`
+	Password        string     `json:"password,omitempty" binding:"required"`
+	Role            string     `json:"role" gorm:"not null;default:user"`
+	ImagePath       string     `json:"imagePath"`
+	EmailVerifiedAt *time.Time `json:"-"`
+	PendingEmail    string     `json:"-"` // new address of an email change awaiting confirmation
+	DeleteAfter     *time.Time `json:"-"` // set while a requested account deletion is in its grace period
+	MFASecret       string     `json:"-"`
+	MFAEnabledAt    *time.Time `json:"-"`
+	MFALastStep     int64      `json:"-" gorm:"not null;default:0"` // time step of the last accepted TOTP code
+	CreatedAt       time.Time  `json:"createdAt"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
 }
 
 // UserResponse represents the user data that is sent back to the client
 type UserResponse struct {
-	ID        uint      `json:"id"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	ImagePath string    `json:"imagePath"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID              uint       `json:"id"`
+	Name            string     `json:"name"`
+	Email           string     `json:"email"`
+	Role            string     `json:"role"`
+	ImagePath       string     `json:"imagePath"`
+	EmailVerified   bool       `json:"emailVerified"`
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt"`
+	PendingEmail    string     `json:"pendingEmail,omitempty"`
+	DeleteAfter     *time.Time `json:"deleteAfter,omitempty"`
+	MFAEnabled      bool       `json:"mfaEnabled"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
+}
+
+// IsValidRole checks if the given role is one of the known roles
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleUser, RoleEditor, RoleAdmin:
+		return true
+	}
+	return false
 }
 
 // BeforeCreate is a GORM hook that hashes the password before creating a user
 func (u *User) BeforeCreate(tx *gorm.DB) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+	hashedPassword, err := passwords.Hash(u.Password)
 	if err != nil {
 		return err
 	}
-	u.Password = string(hashedPassword)
+	u.Password = hashedPassword
+
+	// New users start with the lowest privilege unless a role was set explicitly
+	if u.Role == "" {
+		u.Role = RoleUser
+	}
 	return nil
 }
 
-// BeforeUpdate is a GORM hook that hashes the password before updating a user
+// BeforeUpdate is a GORM hook that hashes the password before updating a user.
+// Passwords must be changed with Update("password", ...) or Updates, since Save
+// does not report the column as changed.
 func (u *User) BeforeUpdate(tx *gorm.DB) error {
 	// Only hash the password if it's being updated
 	if tx.Statement.Changed("Password") {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+		// The new password lives in the update values, not in the model
+		var password string
+		switch dest := tx.Statement.Dest.(type) {
+		case map[string]interface{}:
+			password, _ = dest["password"].(string)
+		case *User:
+			password = dest.Password
+		case User:
+			password = dest.Password
+		}
+
+		hashedPassword, err := passwords.Hash(password)
 		if err != nil {
 			return err
 		}
-		u.Password = string(hashedPassword)
+		tx.Statement.SetColumn("password", hashedPassword)
 	}
 	return nil
 }
 
-// ComparePassword checks if the provided password matches the stored hash
+// ComparePassword checks if the provided password matches the stored hash, whatever
+// algorithm it was hashed with
 func (u *User) ComparePassword(password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
-	return err == nil
+	return passwords.Verify(u.Password, password)
+}
+
+// PasswordNeedsRehash reports whether the stored hash uses an outdated algorithm or parameters
+func (u *User) PasswordNeedsRehash() bool {
+	return passwords.NeedsRehash(u.Password)
 }
 
 // ToResponse converts a User to a UserResponse
 func (u *User) ToResponse() UserResponse {
 	return UserResponse{
-		ID:        u.ID,
-		Name:      u.Name,
-		Email:     u.Email,
-		ImagePath: u.ImagePath,
-		CreatedAt: u.CreatedAt,
-		UpdatedAt: u.UpdatedAt,
+		ID:              u.ID,
+		Name:            u.Name,
+		Email:           u.Email,
+		Role:            u.Role,
+		ImagePath:       u.ImagePath,
+		EmailVerified:   u.EmailVerifiedAt != nil,
+		EmailVerifiedAt: u.EmailVerifiedAt,
+		PendingEmail:    u.PendingEmail,
+		DeleteAfter:     u.DeleteAfter,
+		MFAEnabled:      u.MFAEnabledAt != nil,
+		CreatedAt:       u.CreatedAt,
+		UpdatedAt:       u.UpdatedAt,
 	}
-} 
\ No newline at end of file
+}
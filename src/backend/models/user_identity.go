@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+)
+
+// UserIdentity links a user to an account at an external OpenID Connect identity provider
+type UserIdentity struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"index;not null" json:"userId"`
+	Provider  string    `gorm:"uniqueIndex:idx_user_identities_provider_subject;not null" json:"provider"`
+	Subject   string    `gorm:"uniqueIndex:idx_user_identities_provider_subject;not null" json:"-"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
@@ -0,0 +1,80 @@
+package passwords
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// hashPrefixLength is the length of the SHA-1 prefix breached hashes are grouped by,
+// as in the k-anonymity range files of Have I Been Pwned
+const hashPrefixLength = 5
+
+// BreachedList is a set of breached password SHA-1 hashes, grouped by hash prefix
+type BreachedList struct {
+	ranges map[string]map[string]bool // hash prefix -> hash suffixes
+	size   int
+}
+
+// LoadBreachedList loads breached password hashes from a file with one uppercase or
+// lowercase SHA-1 hex hash per line, optionally followed by ":count" as in the
+// Have I Been Pwned downloads. Blank lines and lines starting with # are skipped.
+func LoadBreachedList(path string) (*BreachedList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	defer file.Close()
+
+	list := &BreachedList{ranges: make(map[string]map[string]bool)}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hash, _, _ := strings.Cut(line, ":")
+		hash = strings.ToUpper(hash)
+		if len(hash) != sha1.Size*2 {
+			return nil, fmt.Errorf("invalid SHA-1 hash on line %d of breached password list", lineNumber)
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			return nil, fmt.Errorf("invalid SHA-1 hash on line %d of breached password list", lineNumber)
+		}
+
+		list.add(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password list: %w", err)
+	}
+
+	return list, nil
+}
+
+// Contains reports whether the password is in the list
+func (l *BreachedList) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	return l.ranges[hash[:hashPrefixLength]][hash[hashPrefixLength:]]
+}
+
+// Size returns the number of hashes in the list
+func (l *BreachedList) Size() int {
+	return l.size
+}
+
+// add adds an uppercase SHA-1 hex hash to the list
+func (l *BreachedList) add(hash string) {
+	prefix, suffix := hash[:hashPrefixLength], hash[hashPrefixLength:]
+	if l.ranges[prefix] == nil {
+		l.ranges[prefix] = make(map[string]bool)
+	}
+	if !l.ranges[prefix][suffix] {
+		l.ranges[prefix][suffix] = true
+		l.size++
+	}
+}
@@ -0,0 +1,91 @@
+package passwords
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeList writes a breached password list and returns its path
+func writeList(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadBreachedList(t *testing.T) {
+	list, err := LoadBreachedList(writeList(t,
+		"# Have I Been Pwned format",
+		"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824", // password
+		"",
+		"  b7a875fc1ea228b9061041b7cec4bd3c52ab3ce3  ", // letmein, lowercase
+		"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8",     // duplicate
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Size() != 2 {
+		t.Errorf("Size() = %d, want 2", list.Size())
+	}
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"password", true},
+		{"letmein", true},
+		{"Password", false},
+		{"Tr0ub4dor&3", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := list.Contains(tt.password); got != tt.want {
+			t.Errorf("Contains(%q) = %v, want %v", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestLoadBreachedListRejectsInvalidFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  string
+	}{
+		{"short hash", []string{"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD"}, "line 1"},
+		{"not hex", []string{"# comment", "ZBAA61E4C9B93F3F0682250B6CF8331B7EE68FD8"}, "line 2"},
+		{"plain password", []string{"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8", "password"}, "line 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadBreachedList(writeList(t, tt.lines...))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error about %s", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := LoadBreachedList(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("missing file: got no error")
+	}
+}
+
+func TestInitPolicyLoadsBreachedList(t *testing.T) {
+	t.Cleanup(func() { policy = PolicyFromEnv() })
+
+	t.Setenv("BREACHED_PASSWORDS_FILE", writeList(t, "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8"))
+	if err := InitPolicy(); err != nil {
+		t.Fatal(err)
+	}
+	if list := CurrentPolicy().Breached; list == nil || !list.Contains("password") {
+		t.Error("breached list not loaded into the policy")
+	}
+
+	t.Setenv("BREACHED_PASSWORDS_FILE", filepath.Join(t.TempDir(), "missing.txt"))
+	if err := InitPolicy(); err == nil {
+		t.Error("missing list: got no error")
+	}
+}
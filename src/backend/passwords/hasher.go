@@ -0,0 +1,233 @@
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+// Hasher hashes passwords with one algorithm and verifies hashes in its format.
+// Hashes embed their parameters, so a hasher verifies hashes made with any parameters.
+type Hasher interface {
+	// Algorithm returns the name of the algorithm
+	Algorithm() string
+	// Hash hashes a password with the hasher's current parameters
+	Hash(password string) (string, error)
+	// Owns reports whether a hash is in the hasher's format
+	Owns(hash string) bool
+	// Verify checks a password against a hash in the hasher's format
+	Verify(hash, password string) bool
+	// NeedsRehash reports whether a hash in the hasher's format uses outdated parameters
+	NeedsRehash(hash string) bool
+}
+
+// BcryptHasher hashes passwords with bcrypt
+type BcryptHasher struct {
+	Cost int
+}
+
+// Algorithm returns the name of the algorithm
+func (h BcryptHasher) Algorithm() string {
+	return AlgorithmBcrypt
+}
+
+// Hash hashes a password
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Owns reports whether a hash is a bcrypt hash
+func (h BcryptHasher) Owns(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// Verify checks a password against a bcrypt hash
+func (h BcryptHasher) Verify(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// NeedsRehash reports whether a bcrypt hash uses a different cost
+func (h BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.Cost
+}
+
+// Argon2idHasher hashes passwords with argon2id, encoded in the PHC string format:
+// $argon2id$v=19$m=<memory>,t=<time>,p=<parallelism>$<salt>$<key>
+type Argon2idHasher struct {
+	Memory      uint32 // KiB
+	Time        uint32 // iterations
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// argon2idParams are the parameters decoded from an argon2id hash
+type argon2idParams struct {
+	memory      uint32
+	time        uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+// Algorithm returns the name of the algorithm
+func (h Argon2idHasher) Algorithm() string {
+	return AlgorithmArgon2id
+}
+
+// Hash hashes a password with a random salt
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Parallelism, h.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Time, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Owns reports whether a hash is an argon2id hash
+func (h Argon2idHasher) Owns(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+// Verify checks a password against an argon2id hash
+func (h Argon2idHasher) Verify(hash, password string) bool {
+	params, err := decodeArgon2id(hash)
+	if err != nil {
+		return false
+	}
+
+	key := argon2.IDKey([]byte(password), params.salt, params.time, params.memory, params.parallelism, uint32(len(params.key)))
+	return subtle.ConstantTimeCompare(key, params.key) == 1
+}
+
+// NeedsRehash reports whether an argon2id hash uses different parameters
+func (h Argon2idHasher) NeedsRehash(hash string) bool {
+	params, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return params.memory != h.Memory || params.time != h.Time || params.parallelism != h.Parallelism ||
+		uint32(len(params.salt)) != h.SaltLength || uint32(len(params.key)) != h.KeyLength
+}
+
+// decodeArgon2id parses an argon2id hash in the PHC string format
+func decodeArgon2id(hash string) (*argon2idParams, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, fmt.Errorf("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2id version")
+	}
+
+	params := &argon2idParams{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.parallelism); err != nil {
+		return nil, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+
+	var err error
+	if params.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	if params.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, fmt.Errorf("invalid argon2id key: %w", err)
+	}
+	return params, nil
+}
+
+// hashers lists every supported hasher; preferred hashes new passwords
+var (
+	hashers   = defaultHashers()
+	preferred = hashers[0]
+)
+
+// defaultHashers returns the supported hashers with their default parameters, preferred first
+func defaultHashers() []Hasher {
+	return []Hasher{
+		Argon2idHasher{Memory: 19 * 1024, Time: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		BcryptHasher{Cost: bcrypt.DefaultCost},
+	}
+}
+
+// InitHasher configures password hashing from PASSWORD_HASH_ALGORITHM (argon2id or bcrypt,
+// default argon2id), BCRYPT_COST (default 10) and ARGON2_MEMORY (KiB, default 19456),
+// ARGON2_TIME (default 2) and ARGON2_PARALLELISM (default 1)
+func InitHasher() error {
+	bcryptHasher := BcryptHasher{Cost: envInt("BCRYPT_COST", bcrypt.DefaultCost)}
+	if bcryptHasher.Cost < bcrypt.MinCost || bcryptHasher.Cost > bcrypt.MaxCost {
+		return fmt.Errorf("BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	argon2idHasher := Argon2idHasher{
+		Memory:      uint32(envInt("ARGON2_MEMORY", 19*1024)),
+		Time:        uint32(envInt("ARGON2_TIME", 2)),
+		Parallelism: uint8(envInt("ARGON2_PARALLELISM", 1)),
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+	if argon2idHasher.Memory < 8*uint32(argon2idHasher.Parallelism) || argon2idHasher.Time < 1 || argon2idHasher.Parallelism < 1 {
+		return fmt.Errorf("invalid argon2id parameters")
+	}
+
+	switch algorithm := os.Getenv("PASSWORD_HASH_ALGORITHM"); algorithm {
+	case "", AlgorithmArgon2id:
+		hashers = []Hasher{argon2idHasher, bcryptHasher}
+	case AlgorithmBcrypt:
+		hashers = []Hasher{bcryptHasher, argon2idHasher}
+	default:
+		return fmt.Errorf("unsupported PASSWORD_HASH_ALGORITHM %q", algorithm)
+	}
+	preferred = hashers[0]
+	return nil
+}
+
+// PreferredAlgorithm returns the algorithm new passwords are hashed with
+func PreferredAlgorithm() string {
+	return preferred.Algorithm()
+}
+
+// Hash hashes a password with the preferred algorithm
+func Hash(password string) (string, error) {
+	return preferred.Hash(password)
+}
+
+// Verify checks a password against a hash made by any supported algorithm
+func Verify(hash, password string) bool {
+	for _, hasher := range hashers {
+		if hasher.Owns(hash) {
+			return hasher.Verify(hash, password)
+		}
+	}
+	return false
+}
+
+// NeedsRehash reports whether a hash should be replaced by one made with the preferred
+// algorithm and parameters
+func NeedsRehash(hash string) bool {
+	return !preferred.Owns(hash) || preferred.NeedsRehash(hash)
+}
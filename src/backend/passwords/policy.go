@@ -0,0 +1,176 @@
+package passwords
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// bcryptMaxBytes is the longest password bcrypt hashes; longer ones are silently truncated
+const bcryptMaxBytes = 72
+
+// defaultMaxLength bounds the work of hashing a password with argon2id
+const defaultMaxLength = 128
+
+// Policy rules, reported in violations
+const (
+	RuleMinLength        = "min_length"
+	RuleMaxLength        = "max_length"
+	RuleCharacterClasses = "character_classes"
+	RulePersonalInfo     = "personal_info"
+	RuleBreached         = "breached"
+)
+
+// Violation describes a password policy rule a password breaks
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Policy holds the rules passwords must follow
+type Policy struct {
+	MinLength int // characters
+	MaxLength int // bytes, at most bcrypt's limit when bcrypt is the preferred algorithm
+	// MinClasses is how many of lowercase, uppercase, digits and symbols a password must mix
+	MinClasses int
+	// DisallowPersonalInfo rejects passwords containing the user's name or email
+	DisallowPersonalInfo bool
+	// Breached, if set, rejects passwords found in the list
+	Breached *BreachedList
+}
+
+var policy = PolicyFromEnv()
+
+// PolicyFromEnv reads the policy from PASSWORD_MIN_LENGTH (default 8), PASSWORD_MAX_LENGTH
+// (default 128, or 72 and at most 72 with bcrypt), PASSWORD_MIN_CLASSES (default 2) and
+// PASSWORD_ALLOW_PERSONAL_INFO
+func PolicyFromEnv() Policy {
+	maxLength := defaultMaxLength
+	if PreferredAlgorithm() == AlgorithmBcrypt {
+		maxLength = bcryptMaxBytes
+	}
+
+	p := Policy{
+		MinLength:            envInt("PASSWORD_MIN_LENGTH", 8),
+		MaxLength:            envInt("PASSWORD_MAX_LENGTH", maxLength),
+		MinClasses:           envInt("PASSWORD_MIN_CLASSES", 2),
+		DisallowPersonalInfo: os.Getenv("PASSWORD_ALLOW_PERSONAL_INFO") != "true",
+	}
+	if PreferredAlgorithm() == AlgorithmBcrypt && p.MaxLength > bcryptMaxBytes {
+		p.MaxLength = bcryptMaxBytes
+	}
+	return p
+}
+
+// InitPolicy loads the password policy from the environment, including the breached
+// password list named by BREACHED_PASSWORDS_FILE, if any
+func InitPolicy() error {
+	p := PolicyFromEnv()
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		list, err := LoadBreachedList(path)
+		if err != nil {
+			return err
+		}
+		p.Breached = list
+	}
+
+	policy = p
+	return nil
+}
+
+// CurrentPolicy returns the password policy in use
+func CurrentPolicy() Policy {
+	return policy
+}
+
+// Validate checks a password against the policy. name and email belong to the
+// password's owner, so that passwords made of them are rejected.
+func (p Policy) Validate(password, name, email string) []Violation {
+	var violations []Violation
+
+	if n := len([]rune(password)); n < p.MinLength {
+		violations = append(violations, Violation{
+			Rule:    RuleMinLength,
+			Message: fmt.Sprintf("Password must be at least %d characters long", p.MinLength),
+		})
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		violations = append(violations, Violation{
+			Rule:    RuleMaxLength,
+			Message: fmt.Sprintf("Password must be at most %d bytes long", p.MaxLength),
+		})
+	}
+	if classes := countClasses(password); classes < p.MinClasses {
+		violations = append(violations, Violation{
+			Rule:    RuleCharacterClasses,
+			Message: fmt.Sprintf("Password must mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinClasses),
+		})
+	}
+	if p.DisallowPersonalInfo && containsPersonalInfo(password, name, email) {
+		violations = append(violations, Violation{
+			Rule:    RulePersonalInfo,
+			Message: "Password must not contain your name or email",
+		})
+	}
+	if p.Breached != nil && p.Breached.Contains(password) {
+		violations = append(violations, Violation{
+			Rule:    RuleBreached,
+			Message: "Password has appeared in a data breach; choose a different one",
+		})
+	}
+
+	return violations
+}
+
+// countClasses counts the character classes used in a password
+func countClasses(password string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	count := 0
+	for _, used := range []bool{lower, upper, digit, symbol} {
+		if used {
+			count++
+		}
+	}
+	return count
+}
+
+// containsPersonalInfo reports whether the password contains the email's local part or a
+// word of the name. Parts shorter than 3 characters are ignored.
+func containsPersonalInfo(password, name, email string) bool {
+	password = strings.ToLower(password)
+
+	parts := strings.Fields(strings.ToLower(name))
+	if local, _, found := strings.Cut(strings.ToLower(email), "@"); found {
+		parts = append(parts, local)
+	}
+
+	for _, part := range parts {
+		if len([]rune(part)) >= 3 && strings.Contains(password, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// envInt reads a non-negative integer from the environment, falling back to def
+func envInt(key string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n >= 0 {
+		return n
+	}
+	return def
+}
@@ -0,0 +1,93 @@
+package passwords
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// rules returns the rules of the violations, in order
+func rules(violations []Violation) []string {
+	names := []string{}
+	for _, violation := range violations {
+		names = append(names, violation.Rule)
+	}
+	return names
+}
+
+func TestPolicyValidate(t *testing.T) {
+	breached := &BreachedList{ranges: make(map[string]map[string]bool)}
+	breached.add("5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8") // "password"
+
+	policy := Policy{
+		MinLength:            8,
+		MaxLength:            72,
+		MinClasses:           2,
+		DisallowPersonalInfo: true,
+		Breached:             breached,
+	}
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		password string
+		want     []string
+	}{
+		{"valid", policy, "correct-horse-7", []string{}},
+		{"too short", policy, "ab-12", []string{RuleMinLength}},
+		{"length counts characters", policy, "ação-çã1", []string{}},
+		{"too long", policy, strings.Repeat("a1", 37), []string{RuleMaxLength}},
+		{"length counts bytes", policy, strings.Repeat("ç1", 25), []string{RuleMaxLength}},
+		{"single class", policy, "abcdefghij", []string{RuleCharacterClasses}},
+		{"symbols count as a class", policy, "abcdefgh!!", []string{}},
+		{"name word", policy, "Maria-2024", []string{RulePersonalInfo}},
+		{"email local part", policy, "xx-msilva-xx", []string{RulePersonalInfo}},
+		{"short name words are ignored", policy, "da-quinta-9", []string{}},
+		{"breached", policy, "password", []string{RuleCharacterClasses, RuleBreached}},
+		{"several rules", policy, "maria", []string{RuleMinLength, RuleCharacterClasses, RulePersonalInfo}},
+		{"personal info allowed", Policy{MinLength: 8, MinClasses: 2}, "Maria-2024", []string{}},
+		{"no maximum", Policy{MinLength: 8}, strings.Repeat("a", 200), []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rules(tt.policy.Validate(tt.password, "Maria da Silva", "msilva@example.com"))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyFromEnv(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm string
+		maxLength string
+		want      Policy
+	}{
+		{"argon2id defaults", AlgorithmArgon2id, "", Policy{MinLength: 8, MaxLength: 128, MinClasses: 2, DisallowPersonalInfo: true}},
+		{"bcrypt defaults", AlgorithmBcrypt, "", Policy{MinLength: 8, MaxLength: 72, MinClasses: 2, DisallowPersonalInfo: true}},
+		{"bcrypt caps the maximum", AlgorithmBcrypt, "100", Policy{MinLength: 8, MaxLength: 72, MinClasses: 2, DisallowPersonalInfo: true}},
+		{"invalid maximum", AlgorithmArgon2id, "-1", Policy{MinLength: 8, MaxLength: 128, MinClasses: 2, DisallowPersonalInfo: true}},
+	}
+	t.Setenv("PASSWORD_MIN_LENGTH", "")
+	t.Setenv("PASSWORD_MIN_CLASSES", "")
+	t.Setenv("PASSWORD_ALLOW_PERSONAL_INFO", "")
+	t.Cleanup(func() {
+		hashers = defaultHashers()
+		preferred = hashers[0]
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PASSWORD_HASH_ALGORITHM", tt.algorithm)
+			t.Setenv("PASSWORD_MAX_LENGTH", tt.maxLength)
+			if err := InitHasher(); err != nil {
+				t.Fatal(err)
+			}
+			if got := PolicyFromEnv(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,382 @@
+package routes
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+
+	"backend/config"
+	"backend/models"
+)
+
+// mockIssuer is an in-process OpenID Connect provider that signs users in without a login page
+type mockIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu            sync.Mutex
+	subject       string
+	email         string
+	emailVerified interface{} // left out of the ID token when nil
+	nonce         string      // replaces the nonce of the login when set
+	codes         map[string]issuedCode
+}
+
+// issuedCode is an authorization code and the login it was issued for
+type issuedCode struct {
+	challenge string
+	nonce     string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &mockIssuer{key: key, codes: make(map[string]issuedCode)}
+
+	mux := http.NewServeMux()
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                issuer.server.URL,
+			"authorization_endpoint":                issuer.server.URL + "/authorize",
+			"token_endpoint":                        issuer.server.URL + "/token",
+			"jwks_uri":                              issuer.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", issuer.token)
+	return issuer
+}
+
+// token redeems an authorization code, checking the PKCE code verifier
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r.ParseForm()
+	code, ok := m.codes[r.Form.Get("code")]
+	delete(m.codes, r.Form.Get("code"))
+	sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != code.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	nonce := code.nonce
+	if m.nonce != "" {
+		nonce = m.nonce
+	}
+	claims := jwt.MapClaims{
+		"iss":   m.server.URL,
+		"sub":   m.subject,
+		"aud":   "client",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": nonce,
+		"email": m.email,
+		"name":  "SSO User",
+	}
+	if m.emailVerified != nil {
+		claims["email_verified"] = m.emailVerified
+	}
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = "test"
+	signed, err := idToken.SignedString(m.key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"id_token":     signed,
+		"expires_in":   3600,
+	})
+}
+
+// signIn sets the account the next logins sign in as
+func (m *mockIssuer) signIn(subject, email string, emailVerified interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subject, m.email, m.emailVerified = subject, email, emailVerified
+}
+
+// authorize plays the user signing in at the provider and returns the callback path and query
+func (m *mockIssuer) authorize(t *testing.T, location string) string {
+	t.Helper()
+	authURL, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authURL.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("login without PKCE: %s", location)
+	}
+	if query.Get("nonce") == "" {
+		t.Fatalf("login without nonce: %s", location)
+	}
+
+	m.mu.Lock()
+	code := "code-" + query.Get("state")
+	m.codes[code] = issuedCode{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	m.mu.Unlock()
+
+	redirectURL, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback := url.Values{"code": {code}, "state": {query.Get("state")}}
+	return strings.TrimPrefix(redirectURL.Path, "/api") + "?" + callback.Encode()
+}
+
+// newOIDCTestRouter sets up the routes with the mock issuer as the "corp" provider
+func newOIDCTestRouter(t *testing.T) (*gin.Engine, *mockIssuer) {
+	t.Helper()
+	issuer := newMockIssuer(t)
+	t.Setenv("OIDC_PROVIDERS", "corp")
+	t.Setenv("OIDC_CORP_ISSUER", issuer.server.URL)
+	t.Setenv("OIDC_CORP_CLIENT_ID", "client")
+	t.Setenv("OIDC_CORP_REDIRECT_URL", "http://localhost/api/auth/oidc/callback")
+	return newTestRouter(t), issuer
+}
+
+// startOIDCLogin starts a login and returns the provider's authorization URL and the state cookie
+func startOIDCLogin(t *testing.T, r http.Handler) (string, []*http.Cookie) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/auth/oidc/login?provider=corp", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("oidc login: %d %s", w.Code, w.Body.String())
+	}
+	return w.Header().Get("Location"), w.Result().Cookies()
+}
+
+// oidcCallback calls the callback and returns the result the frontend gets in the fragment
+func oidcCallback(t *testing.T, r http.Handler, path string, cookies []*http.Cookie) url.Values {
+	t.Helper()
+	req := httptest.NewRequest("GET", path, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || err != nil {
+		t.Fatalf("oidc callback: %d %s", w.Code, w.Body.String())
+	}
+	result, err := url.ParseQuery(location.Fragment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// oidcLogin signs in through the mock issuer and returns the exchange response, or the
+// error the callback reported
+func oidcLogin(t *testing.T, r http.Handler, issuer *mockIssuer) (map[string]interface{}, string) {
+	t.Helper()
+	location, cookies := startOIDCLogin(t, r)
+	result := oidcCallback(t, r, issuer.authorize(t, location), cookies)
+	if result.Get("oidcError") != "" {
+		return nil, result.Get("oidcError")
+	}
+
+	body := `{"code":"` + result.Get("oidcCode") + `"}`
+	code, response := request(t, r, "POST", "/auth/oidc/exchange", "", body)
+	if code != http.StatusOK {
+		t.Fatalf("oidc exchange: %d %v", code, response)
+	}
+	if code, _ := request(t, r, "POST", "/auth/oidc/exchange", "", body); code != http.StatusUnauthorized {
+		t.Errorf("reused exchange code: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	return response, ""
+}
+
+func TestOIDCLoginProvisionsUser(t *testing.T) {
+	r, issuer := newOIDCTestRouter(t)
+	issuer.signIn("subject-1", "sso@example.com", true)
+
+	response, oidcErr := oidcLogin(t, r, issuer)
+	token, _ := response["token"].(string)
+	if oidcErr != "" || token == "" {
+		t.Fatalf("first login: %v %s", response, oidcErr)
+	}
+	code, profile := request(t, r, "GET", "/users/me", token, "")
+	user, _ := profile["user"].(map[string]interface{})
+	if code != http.StatusOK || user["email"] != "sso@example.com" || user["emailVerified"] != true {
+		t.Errorf("provisioned user: %d %v", code, profile)
+	}
+
+	// Signing in again uses the linked user
+	if response, oidcErr := oidcLogin(t, r, issuer); oidcErr != "" || response["token"] == nil {
+		t.Fatalf("second login: %v %s", response, oidcErr)
+	}
+	var users int64
+	config.DB.Model(&models.User{}).Count(&users)
+	if users != 1 {
+		t.Errorf("got %d users, want 1", users)
+	}
+}
+
+func TestOIDCLoginRequiresVerifiedEmail(t *testing.T) {
+	r, issuer := newOIDCTestRouter(t)
+
+	for _, verified := range []interface{}{nil, false, "false"} {
+		issuer.signIn("subject-1", "sso@example.com", verified)
+		if _, oidcErr := oidcLogin(t, r, issuer); oidcErr != "email_not_verified" {
+			t.Errorf("email_verified %v: got error %q, want email_not_verified", verified, oidcErr)
+		}
+	}
+}
+
+func TestOIDCCallbackChecksState(t *testing.T) {
+	r, issuer := newOIDCTestRouter(t)
+	issuer.signIn("subject-1", "sso@example.com", true)
+
+	// Without the state cookie, the login wasn't started by this browser
+	location, _ := startOIDCLogin(t, r)
+	if result := oidcCallback(t, r, issuer.authorize(t, location), nil); result.Get("oidcError") != "invalid_state" {
+		t.Errorf("missing cookie: got %v, want invalid_state", result)
+	}
+
+	// A state can only be used once
+	location, cookies := startOIDCLogin(t, r)
+	callback := issuer.authorize(t, location)
+	if result := oidcCallback(t, r, callback, cookies); result.Get("oidcCode") == "" {
+		t.Fatalf("login: %v", result)
+	}
+	if result := oidcCallback(t, r, callback, cookies); result.Get("oidcError") != "invalid_state" {
+		t.Errorf("reused state: got %v, want invalid_state", result)
+	}
+}
+
+func TestOIDCCallbackChecksNonce(t *testing.T) {
+	r, issuer := newOIDCTestRouter(t)
+	issuer.signIn("subject-1", "sso@example.com", true)
+	issuer.nonce = "replayed-nonce"
+
+	if _, oidcErr := oidcLogin(t, r, issuer); oidcErr != "login_failed" {
+		t.Errorf("got error %q, want login_failed", oidcErr)
+	}
+}
+
+func TestOIDCCallbackChecksPKCE(t *testing.T) {
+	r, issuer := newOIDCTestRouter(t)
+	issuer.signIn("subject-1", "sso@example.com", true)
+
+	// A code intercepted from another login can't be redeemed without that login's verifier
+	victimLocation, _ := startOIDCLogin(t, r)
+	victimCallback, err := url.Parse(issuer.authorize(t, victimLocation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	location, cookies := startOIDCLogin(t, r)
+	callback, err := url.Parse(issuer.authorize(t, location))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := callback.Query()
+	query.Set("code", victimCallback.Query().Get("code"))
+	callback.RawQuery = query.Encode()
+
+	if result := oidcCallback(t, r, callback.String(), cookies); result.Get("oidcError") != "login_failed" {
+		t.Errorf("got %v, want login_failed", result)
+	}
+}
+
+func TestOIDCLoginLinksVerifiedAccount(t *testing.T) {
+	r, issuer := newOIDCTestRouter(t)
+	registerUser(t, r, "user@example.com", models.RoleUser)
+	if err := config.DB.Model(&models.User{}).Where("email = ?", "user@example.com").Update("email_verified_at", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	issuer.signIn("subject-1", "user@example.com", true)
+	response, oidcErr := oidcLogin(t, r, issuer)
+	user, _ := response["user"].(map[string]interface{})
+	if oidcErr != "" || user["id"] != float64(1) {
+		t.Fatalf("login: %v %s", response, oidcErr)
+	}
+
+	// The account keeps its password
+	if code, _ := request(t, r, "POST", "/auth/login", "", `{"email":"user@example.com","password":"secret-pass-1"}`); code != http.StatusOK {
+		t.Errorf("password login: got %d, want %d", code, http.StatusOK)
+	}
+	var identities int64
+	config.DB.Model(&models.UserIdentity{}).Where("user_id = ? AND provider = ? AND subject = ?", 1, "corp", "subject-1").Count(&identities)
+	if identities != 1 {
+		t.Errorf("got %d linked identities, want 1", identities)
+	}
+}
+
+func TestOIDCLoginTakesOverUnverifiedAccount(t *testing.T) {
+	r, issuer := newOIDCTestRouter(t)
+
+	// Someone registered the address without being able to verify it
+	squatterToken := registerUser(t, r, "user@example.com", models.RoleUser)
+	createAPIKey(t, r, squatterToken, models.ScopeProfileRead)
+
+	issuer.signIn("subject-1", "user@example.com", true)
+	response, oidcErr := oidcLogin(t, r, issuer)
+	token, _ := response["token"].(string)
+	if oidcErr != "" || token == "" {
+		t.Fatalf("login: %v %s", response, oidcErr)
+	}
+
+	if code, _ := request(t, r, "POST", "/auth/login", "", `{"email":"user@example.com","password":"secret-pass-1"}`); code != http.StatusUnauthorized {
+		t.Errorf("squatter's password: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := request(t, r, "GET", "/users/me", squatterToken, ""); code != http.StatusUnauthorized {
+		t.Errorf("squatter's token: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	var activeKeys int64
+	config.DB.Model(&models.APIKey{}).Where("revoked_at IS NULL").Count(&activeKeys)
+	if activeKeys != 0 {
+		t.Errorf("got %d active API keys, want 0", activeKeys)
+	}
+	code, profile := request(t, r, "GET", "/users/me", token, "")
+	user, _ := profile["user"].(map[string]interface{})
+	if code != http.StatusOK || user["emailVerified"] != true {
+		t.Errorf("taken over account: %d %v", code, profile)
+	}
+}
+
+func TestOIDCUnknownProvider(t *testing.T) {
+	r, _ := newOIDCTestRouter(t)
+	if code, _ := request(t, r, "GET", "/auth/oidc/login?provider=other", "", ""); code != http.StatusNotFound {
+		t.Errorf("got %d, want %d", code, http.StatusNotFound)
+	}
+}
@@ -1,10 +1,13 @@
 package routes
 
 import (
+	"time"
+
 	"github.com/gin-gonic/gin"
 
 	"backend/controllers"
 	"backend/middleware"
+	"backend/models"
 )
 
 // SetupRoutes initializes all routes for the application
@@ -12,41 +15,120 @@ func SetupRoutes(r *gin.Engine) {
 	// Initialize controllers
 	userController := controllers.NewUserController()
 	productController := controllers.NewProductController()
+	authController := controllers.NewAuthController()
+	mfaController := controllers.NewMFAController()
+	healthController := controllers.NewHealthController()
+	apiKeyController := controllers.NewAPIKeyController()
+	oidcController := controllers.NewOIDCController()
+	sessionController := controllers.NewSessionController()
+	auditController := controllers.NewAuditController()
+	accountController := controllers.NewAccountController()
+	categoryController := controllers.NewCategoryController()
+	tagController := controllers.NewTagController()
+
+	// Health status, with the details for admins below
+	r.GET("/health", healthController.Health)
+
+	// Public keys for verifying access tokens
+	r.GET("/.well-known/jwks.json", authController.JWKS)
 
 	// Auth routes (no authentication required)
-	r.POST("/auth/register", userController.Register)
-	r.POST("/auth/login", userController.Login)
+	r.POST("/auth/register", middleware.RateLimit("register", 5, time.Hour), userController.Register)
+	r.POST("/auth/login", middleware.RateLimit("login", 20, time.Minute), userController.Login)
+	r.POST("/auth/refresh", authController.Refresh)
+	r.POST("/auth/logout", middleware.AuthMiddleware(), middleware.RequireTokenAuth(), authController.Logout)
+	r.POST("/auth/forgot-password", middleware.RateLimit("forgot_password", 10, time.Hour), middleware.RateLimitEmail("forgot_password", 3, time.Hour), authController.ForgotPassword)
+	r.POST("/auth/reset-password", middleware.RateLimit("reset_password", 20, time.Hour), authController.ResetPassword)
+	r.POST("/auth/verify-email", authController.VerifyEmail)
+	r.POST("/auth/confirm-email-change", authController.ConfirmEmailChange)
+	r.POST("/auth/mfa/verify", middleware.RateLimit("mfa", 20, time.Minute), mfaController.Verify)
+	r.POST("/auth/resend-verification", middleware.AuthMiddleware(), middleware.RequireTokenAuth(), middleware.BlockImpersonation(), authController.ResendVerification)
+
+	// Login through external identity providers (OpenID Connect)
+	r.GET("/auth/oidc/providers", oidcController.ListProviders)
+	r.GET("/auth/oidc/login", middleware.RateLimit("oidc_login", 20, time.Minute), oidcController.Login)
+	r.GET("/auth/oidc/callback", oidcController.Callback)
+	r.POST("/auth/oidc/exchange", middleware.RateLimit("oidc_exchange", 20, time.Minute), oidcController.Exchange)
 
 	// User routes (authentication required)
 	userRoutes := r.Group("/users")
 	userRoutes.Use(middleware.AuthMiddleware())
 	{
-		userRoutes.GET("/me", userController.GetProfile)
-		userRoutes.PUT("/me", userController.UpdateProfile)
-		userRoutes.POST("/me/image", userController.UploadProfileImage)
+		userRoutes.GET("/me", middleware.RequireScope(models.ScopeProfileRead), userController.GetProfile)
 	}
 
-	// Admin user routes (authentication required)
+	// Account management routes (interactive session required, API keys and impersonation are rejected)
+	accountRoutes := userRoutes.Group("/me")
+	accountRoutes.Use(middleware.RequireTokenAuth(), middleware.BlockImpersonation())
+	{
+		accountRoutes.PUT("", userController.UpdateProfile)
+		accountRoutes.DELETE("", accountController.DeleteAccount)
+		accountRoutes.GET("/export", middleware.RateLimit("export", 5, time.Hour), accountController.ExportData)
+		accountRoutes.PUT("/password", middleware.RateLimit("change_password", 10, time.Minute), userController.ChangePassword)
+		accountRoutes.PUT("/email", middleware.RateLimit("change_email", 10, time.Minute), userController.RequestEmailChange)
+		accountRoutes.POST("/image", userController.UploadProfileImage)
+		accountRoutes.POST("/mfa/setup", mfaController.Setup)
+		accountRoutes.POST("/mfa/confirm", mfaController.Confirm)
+		accountRoutes.POST("/mfa/recovery-codes", mfaController.RegenerateRecoveryCodes)
+		accountRoutes.DELETE("/mfa", mfaController.Disable)
+		accountRoutes.GET("/api-keys", apiKeyController.ListAPIKeys)
+		accountRoutes.POST("/api-keys", middleware.RequireMFA(), apiKeyController.CreateAPIKey)
+		accountRoutes.PUT("/api-keys/:id", middleware.RequireMFA(), apiKeyController.UpdateAPIKey)
+		accountRoutes.DELETE("/api-keys/:id", apiKeyController.RevokeAPIKey)
+		accountRoutes.GET("/sessions", sessionController.ListSessions)
+		accountRoutes.DELETE("/sessions/:id", sessionController.RevokeSession)
+	}
+
+	// Admin routes (admin role and, if required for the role, MFA)
 	adminRoutes := r.Group("/admin")
-	adminRoutes.Use(middleware.AuthMiddleware())
+	adminRoutes.Use(middleware.AuthMiddleware(), middleware.RequireRole(models.RoleAdmin), middleware.RequireMFA())
 	{
-		adminRoutes.GET("/users", userController.GetAllUsers)
-		adminRoutes.GET("/users/:id", userController.GetUserByID)
-		adminRoutes.DELETE("/users/:id", userController.DeleteUser)
+		adminRoutes.GET("/users", middleware.RequireScope(models.ScopeUsersRead), userController.GetAllUsers)
+		adminRoutes.GET("/users/:id", middleware.RequireScope(models.ScopeUsersRead), userController.GetUserByID)
+		adminRoutes.PUT("/users/:id/role", middleware.RequireScope(models.ScopeUsersWrite), userController.UpdateUserRole)
+		adminRoutes.DELETE("/users/:id", middleware.RequireScope(models.ScopeUsersWrite), userController.DeleteUser)
+		adminRoutes.POST("/users/:id/impersonate", middleware.RequireTokenAuth(), userController.ImpersonateUser)
+		adminRoutes.GET("/audit", middleware.RequireScope(models.ScopeAuditRead), auditController.ListAuditEvents)
+		adminRoutes.GET("/impersonation-requests", middleware.RequireScope(models.ScopeAuditRead), auditController.ListImpersonationRequests)
+		adminRoutes.GET("/health", middleware.RequireTokenAuth(), healthController.HealthDetails)
+		adminRoutes.POST("/categories", middleware.RequireScope(models.ScopeProductsWrite), categoryController.CreateCategory)
+		adminRoutes.PUT("/categories/:id", middleware.RequireScope(models.ScopeProductsWrite), categoryController.UpdateCategory)
+		adminRoutes.DELETE("/categories/:id", middleware.RequireScope(models.ScopeProductsWrite), categoryController.DeleteCategory)
+		adminRoutes.POST("/tags", middleware.RequireScope(models.ScopeProductsWrite), tagController.CreateTag)
+		adminRoutes.PUT("/tags/:id", middleware.RequireScope(models.ScopeProductsWrite), tagController.UpdateTag)
+		adminRoutes.DELETE("/tags/:id", middleware.RequireScope(models.ScopeProductsWrite), tagController.DeleteTag)
 	}
 
 	// Product routes - public (no authentication required)
 	r.GET("/products", productController.GetAllProducts)
+	r.GET("/products/search", middleware.RateLimit("product_search", 60, time.Minute), productController.SearchProducts)
+	r.GET("/products/suggest", middleware.RateLimit("product_suggest", 120, time.Minute), productController.SuggestProducts)
 	r.GET("/products/:id", productController.GetProductByID)
 
-	// Product routes - protected (authentication required)
+	// Category and tag routes - public (no authentication required)
+	r.GET("/categories", categoryController.ListCategories)
+	r.GET("/categories/:id", categoryController.GetCategory)
+	r.GET("/tags", tagController.ListTags)
+
+	// Product routes - protected (admin or editor role and, if required, MFA and a verified email)
 	protectedProducts := r.Group("/products")
-	protectedProducts.Use(middleware.AuthMiddleware())
+	protectedProducts.Use(
+		middleware.AuthMiddleware(),
+		middleware.RequireRole(models.RoleAdmin, models.RoleEditor),
+		middleware.RequireMFA(),
+		middleware.RequireVerifiedEmail(),
+		middleware.RequireScope(models.ScopeProductsWrite),
+	)
 	{
 		protectedProducts.POST("", productController.CreateProduct)
 		protectedProducts.PUT("/:id", productController.UpdateProduct)
-		protectedProducts.DELETE("/:id", productController.DeleteProduct)
+		protectedProducts.DELETE("/:id", middleware.BlockImpersonation(), productController.DeleteProduct)
 		protectedProducts.POST("/:id/image", productController.UploadProductImage)
+		protectedProducts.PUT("/:id/options", productController.SetProductOptions)
+		protectedProducts.POST("/:id/variants", productController.CreateVariant)
+		protectedProducts.PUT("/:id/variants/:variantId", productController.UpdateVariant)
+		protectedProducts.DELETE("/:id/variants/:variantId", productController.DeleteVariant)
+		protectedProducts.POST("/:id/variants/:variantId/image", productController.UploadVariantImage)
 	}
 }
 
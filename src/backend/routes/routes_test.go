@@ -0,0 +1,1515 @@
+package routes
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/pquerna/otp/totp"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"backend/config"
+	"backend/controllers"
+	"backend/middleware"
+	"backend/models"
+	"backend/passwords"
+)
+
+// newTestRouter sets up the routes over a fresh SQLite database
+func newTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "test-secret-that-is-long-enough-for-hs256")
+	t.Setenv("PASSWORD_MIN_LENGTH", "6")
+	t.Setenv("MAIL_DIR", t.TempDir())
+	t.Setenv("RATE_LIMIT_STORE", "database")
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{TranslateError: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(models.All()...); err != nil {
+		t.Fatal(err)
+	}
+	config.DB = db
+
+	if err := middleware.InitKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if err := passwords.InitPolicy(); err != nil {
+		t.Fatal(err)
+	}
+	middleware.InitRevocationStore(db, 0)
+	middleware.InitCounterStore(db)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	SetupRoutes(r)
+	return r
+}
+
+// request sends a JSON request and decodes the JSON response. The token is sent as a bearer
+// token, or with the ApiKey scheme for API keys.
+func request(t *testing.T, r http.Handler, method, path, token, body string) (int, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	switch {
+	case strings.HasPrefix(token, "pk_"):
+		req.Header.Set("Authorization", "ApiKey "+token)
+	case token != "":
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+// registerUser registers a user with the given role and returns an access token for them
+func registerUser(t *testing.T, r http.Handler, email, role string) string {
+	t.Helper()
+	body := `{"name":"Test User","email":"` + email + `","password":"secret-pass-1"}`
+	if code, response := request(t, r, "POST", "/auth/register", "", body); code != http.StatusCreated {
+		t.Fatalf("register %s: %d %v", email, code, response)
+	}
+	if role != models.RoleUser {
+		if err := config.DB.Model(&models.User{}).Where("email = ?", email).Update("role", role).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token, _ := login(t, r, email)
+	return token
+}
+
+// login signs in a user registered by registerUser and returns the access and refresh tokens
+func login(t *testing.T, r http.Handler, email string) (string, string) {
+	t.Helper()
+	code, response := request(t, r, "POST", "/auth/login", "", `{"email":"`+email+`","password":"secret-pass-1"}`)
+	token, _ := response["token"].(string)
+	refreshToken, _ := response["refreshToken"].(string)
+	if code != http.StatusOK || token == "" || refreshToken == "" {
+		t.Fatalf("login %s: %d %v", email, code, response)
+	}
+	return token, refreshToken
+}
+
+func TestAdminRoutesRejectUsers(t *testing.T) {
+	r := newTestRouter(t)
+	userToken := registerUser(t, r, "user@example.com", models.RoleUser)
+	adminToken := registerUser(t, r, "admin@example.com", models.RoleAdmin)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{"user lists users", "GET", "/admin/users", userToken, http.StatusForbidden},
+		{"user deletes user", "DELETE", "/admin/users/2", userToken, http.StatusForbidden},
+		{"anonymous lists users", "GET", "/admin/users", "", http.StatusUnauthorized},
+		{"admin lists users", "GET", "/admin/users", adminToken, http.StatusOK},
+		{"admin deletes user", "DELETE", "/admin/users/1", adminToken, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, response := request(t, r, tt.method, tt.path, tt.token, ""); code != tt.want {
+				t.Errorf("got %d, want %d: %v", code, tt.want, response)
+			}
+		})
+	}
+}
+
+func TestRoleChangeRevokesTokens(t *testing.T) {
+	r := newTestRouter(t)
+	userToken := registerUser(t, r, "user@example.com", models.RoleUser)
+	adminToken := registerUser(t, r, "admin@example.com", models.RoleAdmin)
+
+	if code, response := request(t, r, "PUT", "/admin/users/1/role", adminToken, `{"role":"editor"}`); code != http.StatusOK {
+		t.Fatalf("change role: %d %v", code, response)
+	}
+	if code, _ := request(t, r, "GET", "/users/me", userToken, ""); code != http.StatusUnauthorized {
+		t.Errorf("token issued before the role change: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := request(t, r, "GET", "/users/me", adminToken, ""); code != http.StatusOK {
+		t.Errorf("admin token: got %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	r := newTestRouter(t)
+	registerUser(t, r, "owner@example.com", models.RoleUser)
+	role := func() string {
+		var user models.User
+		if err := config.DB.Where("email = ?", "owner@example.com").First(&user).Error; err != nil {
+			t.Fatal(err)
+		}
+		return user.Role
+	}
+
+	// Registering the address isn't enough until it's verified
+	if err := controllers.BootstrapAdmin(config.DB, "owner@example.com"); !errors.Is(err, controllers.ErrBootstrapAdminNotFound) {
+		t.Errorf("unverified email: got %v", err)
+	}
+	if got := role(); got != models.RoleUser {
+		t.Errorf("unverified email promoted to %s", got)
+	}
+
+	if err := config.DB.Model(&models.User{}).Where("email = ?", "owner@example.com").Update("email_verified_at", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := controllers.BootstrapAdmin(config.DB, "owner@example.com"); err != nil {
+		t.Fatalf("verified email: %v", err)
+	}
+	if got := role(); got != models.RoleAdmin {
+		t.Errorf("verified email: role %s", got)
+	}
+	var events int64
+	config.DB.Model(&models.AuditEvent{}).Where("action = ? AND entity_id = ?", models.AuditActionRoleChange, 1).Count(&events)
+	if events != 1 {
+		t.Errorf("got %d role change audit events, want 1", events)
+	}
+
+	// A deliberately demoted admin isn't promoted again, even if no admin is left
+	if err := config.DB.Model(&models.User{}).Where("email = ?", "owner@example.com").Update("role", models.RoleUser).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := controllers.BootstrapAdmin(config.DB, "owner@example.com"); !errors.Is(err, controllers.ErrBootstrapAdminDemoted) {
+		t.Errorf("demoted admin: got %v", err)
+	}
+
+	// Nobody is promoted once an admin exists
+	registerUser(t, r, "admin@example.com", models.RoleAdmin)
+	if err := controllers.BootstrapAdmin(config.DB, "owner@example.com"); !errors.Is(err, controllers.ErrAdminExists) {
+		t.Errorf("existing admin: got %v", err)
+	}
+	if got := role(); got != models.RoleUser {
+		t.Errorf("promoted to %s with an existing admin", got)
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	r := newTestRouter(t)
+	registerUser(t, r, "user@example.com", models.RoleUser)
+	_, firstRefresh := login(t, r, "user@example.com")
+	otherToken, otherRefresh := login(t, r, "user@example.com")
+	refresh := func(refreshToken string) (int, string, string) {
+		code, response := request(t, r, "POST", "/auth/refresh", "", `{"refreshToken":"`+refreshToken+`"}`)
+		token, _ := response["token"].(string)
+		rotated, _ := response["refreshToken"].(string)
+		return code, token, rotated
+	}
+
+	code, _, secondRefresh := refresh(firstRefresh)
+	if code != http.StatusOK || secondRefresh == "" || secondRefresh == firstRefresh {
+		t.Fatalf("first refresh: got %d with refresh token %q", code, secondRefresh)
+	}
+	code, token, thirdRefresh := refresh(secondRefresh)
+	if code != http.StatusOK || thirdRefresh == "" {
+		t.Fatalf("second refresh: got %d", code)
+	}
+
+	// Replaying a rotated token revokes the whole family, including the latest tokens
+	if code, _, _ := refresh(firstRefresh); code != http.StatusUnauthorized {
+		t.Errorf("replayed refresh token: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _, _ := refresh(thirdRefresh); code != http.StatusUnauthorized {
+		t.Errorf("latest refresh token after the replay: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := request(t, r, "GET", "/users/me", token, ""); code != http.StatusUnauthorized {
+		t.Errorf("latest access token after the replay: got %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	// Other sessions are left alone
+	if code, _ := request(t, r, "GET", "/users/me", otherToken, ""); code != http.StatusOK {
+		t.Errorf("other session: got %d, want %d", code, http.StatusOK)
+	}
+	if code, _, _ := refresh(otherRefresh); code != http.StatusOK {
+		t.Errorf("other session refresh: got %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestLogoutRevokesTokens(t *testing.T) {
+	r := newTestRouter(t)
+	registerUser(t, r, "user@example.com", models.RoleUser)
+	token, refreshToken := login(t, r, "user@example.com")
+	otherToken, otherRefresh := login(t, r, "user@example.com")
+
+	// Logging out ends only the current session
+	if code, response := request(t, r, "POST", "/auth/logout", token, ""); code != http.StatusOK {
+		t.Fatalf("logout: %d %v", code, response)
+	}
+	if code, _ := request(t, r, "GET", "/users/me", token, ""); code != http.StatusUnauthorized {
+		t.Errorf("access token after logout: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := request(t, r, "POST", "/auth/refresh", "", `{"refreshToken":"`+refreshToken+`"}`); code != http.StatusUnauthorized {
+		t.Errorf("refresh token after logout: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := request(t, r, "GET", "/users/me", otherToken, ""); code != http.StatusOK {
+		t.Errorf("other session after logout: got %d, want %d", code, http.StatusOK)
+	}
+
+	// Logging out everywhere revokes every token issued so far, but not the ones issued after
+	if code, response := request(t, r, "POST", "/auth/logout", otherToken, `{"all":true}`); code != http.StatusOK {
+		t.Fatalf("logout everywhere: %d %v", code, response)
+	}
+	if code, _ := request(t, r, "GET", "/users/me", otherToken, ""); code != http.StatusUnauthorized {
+		t.Errorf("access token after logout everywhere: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := request(t, r, "POST", "/auth/refresh", "", `{"refreshToken":"`+otherRefresh+`"}`); code != http.StatusUnauthorized {
+		t.Errorf("refresh token after logout everywhere: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	newToken, _ := login(t, r, "user@example.com")
+	if code, _ := request(t, r, "GET", "/users/me", newToken, ""); code != http.StatusOK {
+		t.Errorf("login after logout everywhere: got %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	r := newTestRouter(t)
+	registerUser(t, r, "user@example.com", models.RoleUser)
+	otherUserToken := registerUser(t, r, "other@example.com", models.RoleUser)
+	token, _ := login(t, r, "user@example.com")
+	remoteToken, remoteRefresh := login(t, r, "user@example.com")
+
+	// The latest login is listed among the user's sessions
+	var remoteID string
+	config.DB.Model(&models.RefreshToken{}).Where("user_id = ?", 1).Order("id DESC").Limit(1).Pluck("family_id", &remoteID)
+	code, response := request(t, r, "GET", "/users/me/sessions", token, "")
+	sessions, _ := response["sessions"].([]interface{})
+	listed := false
+	for _, session := range sessions {
+		if session.(map[string]interface{})["id"] == remoteID {
+			listed = true
+		}
+	}
+	if code != http.StatusOK || remoteID == "" || !listed {
+		t.Fatalf("list sessions: %d %v", code, response)
+	}
+
+	// Another user can't sign the user out
+	if code, _ := request(t, r, "DELETE", "/users/me/sessions/"+remoteID, otherUserToken, ""); code != http.StatusNotFound {
+		t.Errorf("other user's session: got %d, want %d", code, http.StatusNotFound)
+	}
+	if code, _ := request(t, r, "GET", "/users/me", remoteToken, ""); code != http.StatusOK {
+		t.Fatalf("session after another user tried to end it: got %d, want %d", code, http.StatusOK)
+	}
+
+	if code, response := request(t, r, "DELETE", "/users/me/sessions/"+remoteID, token, ""); code != http.StatusOK {
+		t.Fatalf("revoke session: %d %v", code, response)
+	}
+	if code, _ := request(t, r, "GET", "/users/me", remoteToken, ""); code != http.StatusUnauthorized {
+		t.Errorf("access token of the ended session: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := request(t, r, "POST", "/auth/refresh", "", `{"refreshToken":"`+remoteRefresh+`"}`); code != http.StatusUnauthorized {
+		t.Errorf("refresh token of the ended session: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := request(t, r, "GET", "/users/me", token, ""); code != http.StatusOK {
+		t.Errorf("current session: got %d, want %d", code, http.StatusOK)
+	}
+
+	var events int64
+	config.DB.Model(&models.AuditEvent{}).
+		Where("action = ? AND entity_id = ? AND actor_id = ?", models.AuditActionSessionRevoke, 1, 1).
+		Count(&events)
+	if events != 1 {
+		t.Errorf("got %d session revocation audit events, want 1", events)
+	}
+}
+
+func TestLoginRehashesPassword(t *testing.T) {
+	r := newTestRouter(t)
+	registerUser(t, r, "user@example.com", models.RoleUser)
+
+	// A password hashed with bcrypt before argon2id became the preferred algorithm
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret-pass-1"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.DB.Model(&models.User{}).Where("id = ?", 1).UpdateColumn("password", string(hash)).Error; err != nil {
+		t.Fatal(err)
+	}
+	storedHash := func() string {
+		var user models.User
+		if err := config.DB.First(&user, 1).Error; err != nil {
+			t.Fatal(err)
+		}
+		return user.Password
+	}
+
+	if code, _ := request(t, r, "POST", "/auth/login", "", `{"email":"user@example.com","password":"wrong-pass"}`); code != http.StatusUnauthorized {
+		t.Errorf("wrong password: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	if storedHash() != string(hash) {
+		t.Error("hash replaced after a failed login")
+	}
+
+	login(t, r, "user@example.com")
+	if rehashed := storedHash(); !strings.HasPrefix(rehashed, "$argon2id$") || !passwords.Verify(rehashed, "secret-pass-1") {
+		t.Errorf("hash after login: %q", rehashed)
+	}
+
+	// The new hash works for the next login
+	login(t, r, "user@example.com")
+}
+
+func TestForgotPasswordRateLimitedPerEmail(t *testing.T) {
+	r := newTestRouter(t)
+	body := `{"email":"nobody@example.com"}`
+
+	for i := 0; i < 3; i++ {
+		if code, response := request(t, r, "POST", "/auth/forgot-password", "", body); code != http.StatusOK {
+			t.Fatalf("request %d: %d %v", i+1, code, response)
+		}
+	}
+	if code, _ := request(t, r, "POST", "/auth/forgot-password", "", body); code != http.StatusTooManyRequests {
+		t.Errorf("got %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if code, _ := request(t, r, "POST", "/auth/forgot-password", "", `{"email":"other@example.com"}`); code != http.StatusOK {
+		t.Errorf("other email: got %d, want %d", code, http.StatusOK)
+	}
+}
+
+// mailedToken returns the token of the link to path in the emails written to MAIL_DIR,
+// waiting for emails sent in the background
+func mailedToken(t *testing.T, path string) string {
+	t.Helper()
+	link := regexp.MustCompile(regexp.QuoteMeta(path) + `\?token=([^\s]+)\s`)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		files, err := filepath.Glob(filepath.Join(os.Getenv("MAIL_DIR"), "*.eml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if match := link.FindSubmatch(data); match != nil {
+				token, err := url.QueryUnescape(string(match[1]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return token
+			}
+		}
+	}
+	t.Fatalf("no email with a %s link", path)
+	return ""
+}
+
+func TestPasswordReset(t *testing.T) {
+	r := newTestRouter(t)
+	registerUser(t, r, "user@example.com", models.RoleUser)
+
+	if code, response := request(t, r, "POST", "/auth/forgot-password", "", `{"email":"user@example.com"}`); code != http.StatusOK {
+		t.Fatalf("forgot password: %d %v", code, response)
+	}
+	token := mailedToken(t, "/reset-password")
+
+	// A password that breaks the policy doesn't use up the token
+	code, response := request(t, r, "POST", "/auth/reset-password", "", `{"token":"`+token+`","password":"short"}`)
+	if _, ok := response["passwordErrors"]; code != http.StatusBadRequest || !ok {
+		t.Errorf("password breaking the policy: %d %v", code, response)
+	}
+
+	body := `{"token":"` + token + `","password":"another-pass-2"}`
+	if code, response := request(t, r, "POST", "/auth/reset-password", "", body); code != http.StatusOK {
+		t.Fatalf("reset password: %d %v", code, response)
+	}
+	if code, _ := request(t, r, "POST", "/auth/reset-password", "", body); code != http.StatusBadRequest {
+		t.Errorf("reusing the token: got %d, want %d", code, http.StatusBadRequest)
+	}
+
+	if code, _ := request(t, r, "POST", "/auth/login", "", `{"email":"user@example.com","password":"secret-pass-1"}`); code != http.StatusUnauthorized {
+		t.Errorf("login with the old password: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := request(t, r, "POST", "/auth/login", "", `{"email":"user@example.com","password":"another-pass-2"}`); code != http.StatusOK {
+		t.Errorf("login with the new password: got %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	r := newTestRouter(t)
+	t.Setenv("REQUIRE_EMAIL_VERIFICATION", "true")
+	token := registerUser(t, r, "editor@example.com", models.RoleEditor)
+	product := `{"name":"Shirt","description":"A shirt","price":10,"quantity":5}`
+
+	// Unverified users can't change products
+	if code, _ := request(t, r, "POST", "/products", token, product); code != http.StatusForbidden {
+		t.Errorf("unverified: got %d, want %d", code, http.StatusForbidden)
+	}
+
+	// Another email can only be requested once the resend interval is over
+	firstToken := mailedToken(t, "/verify-email")
+	if code, _ := request(t, r, "POST", "/auth/resend-verification", token, ""); code != http.StatusTooManyRequests {
+		t.Errorf("resend right after registering: got %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if err := config.DB.Model(&models.UserToken{}).Where("purpose = ?", models.TokenPurposeEmailVerification).
+		Update("created_at", time.Now().Add(-2*time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(os.Getenv("MAIL_DIR"), "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		os.Remove(file)
+	}
+	if code, response := request(t, r, "POST", "/auth/resend-verification", token, ""); code != http.StatusOK {
+		t.Fatalf("resend: %d %v", code, response)
+	}
+	secondToken := mailedToken(t, "/verify-email")
+
+	// Only the latest link works, and only once
+	if code, _ := request(t, r, "POST", "/auth/verify-email", "", `{"token":"`+firstToken+`"}`); code != http.StatusBadRequest {
+		t.Errorf("replaced token: got %d, want %d", code, http.StatusBadRequest)
+	}
+	code, response := request(t, r, "POST", "/auth/verify-email", "", `{"token":"`+secondToken+`"}`)
+	user, _ := response["user"].(map[string]interface{})
+	if code != http.StatusOK || user["emailVerified"] != true {
+		t.Fatalf("verify email: %d %v", code, response)
+	}
+	if code, _ := request(t, r, "POST", "/auth/verify-email", "", `{"token":"`+secondToken+`"}`); code != http.StatusBadRequest {
+		t.Errorf("reusing the token: got %d, want %d", code, http.StatusBadRequest)
+	}
+
+	if code, response := request(t, r, "POST", "/products", token, product); code != http.StatusCreated {
+		t.Errorf("verified: got %d, want %d: %v", code, http.StatusCreated, response)
+	}
+	if code, _ := request(t, r, "POST", "/auth/resend-verification", token, ""); code != http.StatusBadRequest {
+		t.Errorf("resend once verified: got %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestChangePasswordRequiresReauthentication(t *testing.T) {
+	r := newTestRouter(t)
+	token := registerUser(t, r, "user@example.com", models.RoleUser)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"no proof", `{"newPassword":"another-pass-2"}`, http.StatusUnauthorized},
+		{"wrong password", `{"currentPassword":"wrong-pass","newPassword":"another-pass-2"}`, http.StatusUnauthorized},
+		{"MFA code without MFA", `{"code":"123456","newPassword":"another-pass-2"}`, http.StatusBadRequest},
+		{"current password", `{"currentPassword":"secret-pass-1","newPassword":"another-pass-2"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code, response := request(t, r, "PUT", "/users/me/password", token, tt.body); code != tt.want {
+			t.Errorf("%s: got %d, want %d: %v", tt.name, code, tt.want, response)
+		}
+	}
+
+	if code, _ := request(t, r, "POST", "/auth/login", "", `{"email":"user@example.com","password":"another-pass-2"}`); code != http.StatusOK {
+		t.Errorf("login with the new password: got %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestChangeEmail(t *testing.T) {
+	r := newTestRouter(t)
+	token := registerUser(t, r, "user@example.com", models.RoleUser)
+
+	// The change needs the current password
+	if code, _ := request(t, r, "PUT", "/users/me/email", token, `{"newEmail":"new@example.com"}`); code != http.StatusUnauthorized {
+		t.Errorf("no proof: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := request(t, r, "PUT", "/users/me/email", token, `{"currentPassword":"wrong-pass","newEmail":"new@example.com"}`); code != http.StatusUnauthorized {
+		t.Errorf("wrong password: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	body := `{"currentPassword":"secret-pass-1","newEmail":"new@example.com"}`
+	if code, response := request(t, r, "PUT", "/users/me/email", token, body); code != http.StatusAccepted {
+		t.Fatalf("request email change: %d %v", code, response)
+	}
+	var events int64
+	config.DB.Model(&models.AuditEvent{}).Where("entity_id = ? AND changes LIKE ?", 1, `%"pendingEmail"%`).Count(&events)
+	if events != 1 {
+		t.Errorf("got %d audit events for the pending email, want 1", events)
+	}
+	confirmation := `{"token":"` + mailedToken(t, "/confirm-email-change") + `"}`
+
+	// Someone registers the address before it's confirmed; the token isn't used up
+	registerUser(t, r, "new@example.com", models.RoleUser)
+	if code, _ := request(t, r, "POST", "/auth/confirm-email-change", "", confirmation); code != http.StatusConflict {
+		t.Errorf("taken address: got %d, want %d", code, http.StatusConflict)
+	}
+	if err := config.DB.Where("email = ?", "new@example.com").Delete(&models.User{}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	code, response := request(t, r, "POST", "/auth/confirm-email-change", "", confirmation)
+	user, _ := response["user"].(map[string]interface{})
+	if code != http.StatusOK || user["email"] != "new@example.com" {
+		t.Fatalf("confirm email change: %d %v", code, response)
+	}
+	if code, _ := request(t, r, "POST", "/auth/confirm-email-change", "", confirmation); code != http.StatusBadRequest {
+		t.Errorf("reusing the token: got %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestMFASetupRequiresPassword(t *testing.T) {
+	r := newTestRouter(t)
+	token := registerUser(t, r, "user@example.com", models.RoleUser)
+
+	if code, _ := request(t, r, "POST", "/users/me/mfa/setup", token, ""); code != http.StatusBadRequest {
+		t.Errorf("setup without password: got %d, want %d", code, http.StatusBadRequest)
+	}
+	if code, _ := request(t, r, "POST", "/users/me/mfa/setup", token, `{"currentPassword":"wrong-pass"}`); code != http.StatusUnauthorized {
+		t.Errorf("setup with wrong password: got %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	_, response := request(t, r, "POST", "/users/me/mfa/setup", token, `{"currentPassword":"secret-pass-1"}`)
+	secret, _ := response["secret"].(string)
+	mfaCode, err := totp.GenerateCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := request(t, r, "POST", "/users/me/mfa/confirm", token, `{"currentPassword":"wrong-pass","code":"`+mfaCode+`"}`); code != http.StatusUnauthorized {
+		t.Errorf("confirm with wrong password: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, response := request(t, r, "POST", "/users/me/mfa/confirm", token, `{"currentPassword":"secret-pass-1","code":"`+mfaCode+`"}`); code != http.StatusOK {
+		t.Errorf("confirm: got %d, want %d: %v", code, http.StatusOK, response)
+	}
+}
+
+// enableMFA sets up and confirms MFA for the user and returns the TOTP secret
+func enableMFA(t *testing.T, r http.Handler, token string) string {
+	t.Helper()
+	code, response := request(t, r, "POST", "/users/me/mfa/setup", token, `{"currentPassword":"secret-pass-1"}`)
+	secret, _ := response["secret"].(string)
+	if code != http.StatusOK || secret == "" {
+		t.Fatalf("mfa setup: %d %v", code, response)
+	}
+
+	mfaCode, err := totp.GenerateCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `{"currentPassword":"secret-pass-1","code":"` + mfaCode + `"}`
+	if code, response := request(t, r, "POST", "/users/me/mfa/confirm", token, body); code != http.StatusOK {
+		t.Fatalf("mfa confirm: %d %v", code, response)
+	}
+	return secret
+}
+
+// mfaToken logs in a user with MFA enabled and returns the token for the MFA step
+func mfaToken(t *testing.T, r http.Handler, email string) string {
+	t.Helper()
+	code, response := request(t, r, "POST", "/auth/login", "", `{"email":"`+email+`","password":"secret-pass-1"}`)
+	token, _ := response["mfaToken"].(string)
+	if code != http.StatusOK || token == "" {
+		t.Fatalf("login %s: %d %v", email, code, response)
+	}
+	return token
+}
+
+func TestMFACodesCantBeReplayed(t *testing.T) {
+	r := newTestRouter(t)
+	secret := enableMFA(t, r, registerUser(t, r, "user@example.com", models.RoleUser))
+	verify := func(at time.Time) int {
+		mfaCode, err := totp.GenerateCode(secret, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, _ := request(t, r, "POST", "/auth/mfa/verify", "", `{"mfaToken":"`+mfaToken(t, r, "user@example.com")+`","code":"`+mfaCode+`"}`)
+		return code
+	}
+
+	// The code used to confirm MFA is used up
+	if code := verify(time.Now()); code != http.StatusUnauthorized {
+		t.Errorf("code used to confirm MFA: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	next := time.Now().Add(30 * time.Second)
+	if code := verify(next); code != http.StatusOK {
+		t.Errorf("next code: got %d, want %d", code, http.StatusOK)
+	}
+	if code := verify(next); code != http.StatusUnauthorized {
+		t.Errorf("replayed code: got %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestMFATokenExchangedOnce(t *testing.T) {
+	r := newTestRouter(t)
+	secret := enableMFA(t, r, registerUser(t, r, "user@example.com", models.RoleUser))
+	mfaCode, err := totp.GenerateCode(secret, time.Now().Add(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify := func(token string) int {
+		// Forget the last used code, so only the MFA token can make the exchange fail
+		if err := config.DB.Model(&models.User{}).Where("id = ?", 1).Update("mfa_last_step", 0).Error; err != nil {
+			t.Fatal(err)
+		}
+		code, _ := request(t, r, "POST", "/auth/mfa/verify", "", `{"mfaToken":"`+token+`","code":"`+mfaCode+`"}`)
+		return code
+	}
+
+	token := mfaToken(t, r, "user@example.com")
+	if code := verify(token); code != http.StatusOK {
+		t.Fatalf("first exchange: got %d, want %d", code, http.StatusOK)
+	}
+	if code := verify(token); code != http.StatusUnauthorized {
+		t.Errorf("second exchange: got %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	// Resetting the password invalidates a half-finished login
+	token = mfaToken(t, r, "user@example.com")
+	if code, response := request(t, r, "POST", "/auth/forgot-password", "", `{"email":"user@example.com"}`); code != http.StatusOK {
+		t.Fatalf("forgot password: %d %v", code, response)
+	}
+	body := `{"token":"` + mailedToken(t, "/reset-password") + `","password":"another-pass-2"}`
+	if code, response := request(t, r, "POST", "/auth/reset-password", "", body); code != http.StatusOK {
+		t.Fatalf("reset password: %d %v", code, response)
+	}
+	if code := verify(token); code != http.StatusUnauthorized {
+		t.Errorf("MFA token issued before the reset: got %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestMFAAttemptsLimited(t *testing.T) {
+	r := newTestRouter(t)
+	secret := enableMFA(t, r, registerUser(t, r, "user@example.com", models.RoleUser))
+	token := mfaToken(t, r, "user@example.com")
+
+	for i := 0; i < 5; i++ {
+		if code, _ := request(t, r, "POST", "/auth/mfa/verify", "", `{"mfaToken":"`+token+`","code":"000000"}`); code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: got %d, want %d", i+1, code, http.StatusUnauthorized)
+		}
+	}
+
+	// Once the limit is reached, even a valid code is rejected
+	mfaCode, err := totp.GenerateCode(secret, time.Now().Add(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := request(t, r, "POST", "/auth/mfa/verify", "", `{"mfaToken":"`+token+`","code":"`+mfaCode+`"}`); code != http.StatusTooManyRequests {
+		t.Errorf("got %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
+
+func TestMFADisable(t *testing.T) {
+	r := newTestRouter(t)
+	t.Setenv("LOGIN_LOCKOUT_THRESHOLD", "3")
+	token := registerUser(t, r, "user@example.com", models.RoleUser)
+	secret := enableMFA(t, r, token)
+	disable := func(password string, at time.Time) (int, map[string]interface{}) {
+		mfaCode, err := totp.GenerateCode(secret, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return request(t, r, "DELETE", "/users/me/mfa", token, `{"password":"`+password+`","code":"`+mfaCode+`"}`)
+	}
+
+	// Wrong passwords count towards the login lockout
+	for i := 0; i < 3; i++ {
+		if code, _ := disable("wrong-pass", time.Now()); code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: got %d, want %d", i+1, code, http.StatusUnauthorized)
+		}
+	}
+	if code, _ := disable("secret-pass-1", time.Now()); code != http.StatusTooManyRequests {
+		t.Errorf("locked out: got %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if err := middleware.ResetLoginFailures("user@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Disabling MFA signs out the other sessions and keeps this client signed in
+	code, response := disable("secret-pass-1", time.Now().Add(30*time.Second))
+	newToken, _ := response["token"].(string)
+	if code != http.StatusOK || newToken == "" {
+		t.Fatalf("disable: %d %v", code, response)
+	}
+	if code, _ := request(t, r, "GET", "/users/me", token, ""); code != http.StatusUnauthorized {
+		t.Errorf("old token: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := request(t, r, "GET", "/users/me", newToken, ""); code != http.StatusOK {
+		t.Errorf("new token: got %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestMFAChangesAudited(t *testing.T) {
+	r := newTestRouter(t)
+	token := registerUser(t, r, "user@example.com", models.RoleUser)
+	secret := enableMFA(t, r, token)
+
+	mfaCode, err := totp.GenerateCode(secret, time.Now().Add(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, response := request(t, r, "POST", "/users/me/mfa/recovery-codes", token, `{"code":"`+mfaCode+`"}`); code != http.StatusOK {
+		t.Fatalf("regenerate recovery codes: %d %v", code, response)
+	}
+
+	for _, action := range []string{models.AuditActionMFASetup, models.AuditActionMFAEnable, models.AuditActionRecoveryCodesRegenerate} {
+		var count int64
+		if err := config.DB.Model(&models.AuditEvent{}).Where("action = ? AND entity_id = ?", action, 1).Count(&count).Error; err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Errorf("%s: got %d audit events, want 1", action, count)
+		}
+	}
+}
+
+// createAPIKey creates an API key with the scopes and returns the key
+func createAPIKey(t *testing.T, r http.Handler, token string, scopes ...string) string {
+	t.Helper()
+	body, err := json.Marshal(map[string]interface{}{"name": "test", "scopes": scopes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, response := request(t, r, "POST", "/users/me/api-keys", token, string(body))
+	key, _ := response["key"].(string)
+	if code != http.StatusCreated || key == "" {
+		t.Fatalf("create API key: %d %v", code, response)
+	}
+	return key
+}
+
+func TestAPIKeysNeedMFAForAdminRoutes(t *testing.T) {
+	r := newTestRouter(t)
+	t.Setenv("MFA_REQUIRED_ROLES", models.RoleAdmin)
+
+	// A key created before the user became an admin didn't go through MFA
+	token := registerUser(t, r, "user@example.com", models.RoleUser)
+	key := createAPIKey(t, r, token, models.ScopeUsersRead)
+	if err := config.DB.Model(&models.User{}).Where("email = ?", "user@example.com").Update("role", models.RoleAdmin).Error; err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := request(t, r, "GET", "/admin/users", key, ""); code != http.StatusForbidden {
+		t.Errorf("key created without MFA: got %d, want %d", code, http.StatusForbidden)
+	}
+
+	// A key created from a session that passed MFA is accepted
+	secret := enableMFA(t, r, token)
+	mfaCode, err := totp.GenerateCode(secret, time.Now().Add(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, response := request(t, r, "POST", "/auth/mfa/verify", "", `{"mfaToken":"`+mfaToken(t, r, "user@example.com")+`","code":"`+mfaCode+`"}`)
+	mfaSessionToken, _ := response["token"].(string)
+	if code != http.StatusOK {
+		t.Fatalf("mfa verify: %d %v", code, response)
+	}
+	key = createAPIKey(t, r, mfaSessionToken, models.ScopeUsersRead)
+	if code, response := request(t, r, "GET", "/admin/users", key, ""); code != http.StatusOK {
+		t.Errorf("key created with MFA: got %d, want %d: %v", code, http.StatusOK, response)
+	}
+}
+
+func TestAPIKeyUpdateNeedsScopes(t *testing.T) {
+	r := newTestRouter(t)
+	token := registerUser(t, r, "user@example.com", models.RoleUser)
+	createAPIKey(t, r, token, models.ScopeProfileRead)
+
+	if code, _ := request(t, r, "PUT", "/users/me/api-keys/1", token, `{"scopes":[]}`); code != http.StatusBadRequest {
+		t.Errorf("empty scopes: got %d, want %d", code, http.StatusBadRequest)
+	}
+	if code, response := request(t, r, "PUT", "/users/me/api-keys/1", token, `{"name":"renamed"}`); code != http.StatusOK {
+		t.Errorf("rename: got %d, want %d: %v", code, http.StatusOK, response)
+	}
+}
+
+func TestPasswordChangesRevokeAPIKeys(t *testing.T) {
+	r := newTestRouter(t)
+	token := registerUser(t, r, "user@example.com", models.RoleUser)
+
+	// Resetting the password locks out a key created by whoever took over the account
+	key := createAPIKey(t, r, token, models.ScopeProfileRead)
+	if code, _ := request(t, r, "GET", "/users/me", key, ""); code != http.StatusOK {
+		t.Fatalf("key before the reset: got %d, want %d", code, http.StatusOK)
+	}
+	if code, response := request(t, r, "POST", "/auth/forgot-password", "", `{"email":"user@example.com"}`); code != http.StatusOK {
+		t.Fatalf("forgot password: %d %v", code, response)
+	}
+	body := `{"token":"` + mailedToken(t, "/reset-password") + `","password":"secret-pass-1"}`
+	if code, response := request(t, r, "POST", "/auth/reset-password", "", body); code != http.StatusOK {
+		t.Fatalf("reset password: %d %v", code, response)
+	}
+	if code, _ := request(t, r, "GET", "/users/me", key, ""); code != http.StatusUnauthorized {
+		t.Errorf("key after the reset: got %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	// So does changing it
+	token, _ = login(t, r, "user@example.com")
+	key = createAPIKey(t, r, token, models.ScopeProfileRead)
+	body = `{"currentPassword":"secret-pass-1","newPassword":"another-pass-2"}`
+	if code, response := request(t, r, "PUT", "/users/me/password", token, body); code != http.StatusOK {
+		t.Fatalf("change password: %d %v", code, response)
+	}
+	if code, _ := request(t, r, "GET", "/users/me", key, ""); code != http.StatusUnauthorized {
+		t.Errorf("key after the change: got %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestHealthDetailsForAdminsOnly(t *testing.T) {
+	r := newTestRouter(t)
+	userToken := registerUser(t, r, "user@example.com", models.RoleUser)
+	adminToken := registerUser(t, r, "admin@example.com", models.RoleAdmin)
+
+	code, response := request(t, r, "GET", "/health", "", "")
+	if code != http.StatusOK || response["status"] != "ok" {
+		t.Errorf("public health: %d %v", code, response)
+	}
+	if _, ok := response["checks"]; ok {
+		t.Errorf("public health reveals the checks: %v", response)
+	}
+
+	if code, _ := request(t, r, "GET", "/admin/health", userToken, ""); code != http.StatusForbidden {
+		t.Errorf("user health details: got %d, want %d", code, http.StatusForbidden)
+	}
+	code, response = request(t, r, "GET", "/admin/health", adminToken, "")
+	if _, ok := response["checks"]; code != http.StatusOK || !ok {
+		t.Errorf("admin health details: %d %v", code, response)
+	}
+}
+
+func TestImpersonatedRequestsListed(t *testing.T) {
+	r := newTestRouter(t)
+	registerUser(t, r, "user@example.com", models.RoleUser)
+	adminToken := registerUser(t, r, "admin@example.com", models.RoleAdmin)
+
+	code, response := request(t, r, "POST", "/admin/users/1/impersonate", adminToken, "")
+	token, _ := response["token"].(string)
+	if code != http.StatusOK || token == "" {
+		t.Fatalf("impersonate: %d %v", code, response)
+	}
+	if code, _ := request(t, r, "GET", "/users/me", token, ""); code != http.StatusOK {
+		t.Fatalf("impersonated request: got %d, want %d", code, http.StatusOK)
+	}
+
+	code, response = request(t, r, "GET", "/admin/impersonation-requests?impersonatorId=2&userId=1", adminToken, "")
+	requests, _ := response["requests"].([]interface{})
+	if code != http.StatusOK || len(requests) != 1 {
+		t.Fatalf("list: %d %v", code, response)
+	}
+	if listed := requests[0].(map[string]interface{}); listed["path"] != "/users/me" || listed["status"] != float64(http.StatusOK) {
+		t.Errorf("listed request: %v", listed)
+	}
+
+	_, response = request(t, r, "GET", "/admin/impersonation-requests?impersonatorId=1", adminToken, "")
+	if requests, _ := response["requests"].([]interface{}); len(requests) != 0 {
+		t.Errorf("filtered by another impersonator: %v", response)
+	}
+}
+
+func TestImpersonationFailsWithoutAudit(t *testing.T) {
+	r := newTestRouter(t)
+	registerUser(t, r, "user@example.com", models.RoleUser)
+	adminToken := registerUser(t, r, "admin@example.com", models.RoleAdmin)
+
+	// No token is issued when the audit event can't be recorded
+	if err := config.DB.Migrator().DropTable(&models.AuditEvent{}); err != nil {
+		t.Fatal(err)
+	}
+	code, response := request(t, r, "POST", "/admin/users/1/impersonate", adminToken, "")
+	if _, ok := response["token"]; code != http.StatusInternalServerError || ok {
+		t.Errorf("impersonate without audit: %d %v", code, response)
+	}
+}
+
+func TestAuditRejectsUnknownParams(t *testing.T) {
+	r := newTestRouter(t)
+	adminToken := registerUser(t, r, "admin@example.com", models.RoleAdmin)
+
+	if code, response := request(t, r, "GET", "/admin/audit?action=role_change&entityType=user&page=1", adminToken, ""); code != http.StatusOK {
+		t.Errorf("known params: got %d, want %d: %v", code, http.StatusOK, response)
+	}
+	if code, _ := request(t, r, "GET", "/admin/audit?actor=1", adminToken, ""); code != http.StatusBadRequest {
+		t.Errorf("unknown param: got %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestExportData(t *testing.T) {
+	r := newTestRouter(t)
+	token := registerUser(t, r, "user@example.com", models.RoleUser)
+	createAPIKey(t, r, token, models.ScopeProfileRead)
+	if code, response := request(t, r, "PUT", "/users/me", token, `{"name":"Renamed User"}`); code != http.StatusOK {
+		t.Fatalf("update profile: %d %v", code, response)
+	}
+	registerUser(t, r, "other@example.com", models.RoleUser)
+
+	req := httptest.NewRequest("GET", "/users/me/export", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("export: %d %s", w.Code, w.Body.String())
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[file.Name] = data
+	}
+	if len(files) != 2 {
+		t.Errorf("files in the archive: %v", archive.File)
+	}
+
+	var profile struct {
+		User     map[string]interface{}   `json:"user"`
+		Sessions []map[string]interface{} `json:"sessions"`
+		APIKeys  []map[string]interface{} `json:"apiKeys"`
+	}
+	if err := json.Unmarshal(files["profile.json"], &profile); err != nil {
+		t.Fatalf("profile.json: %v", err)
+	}
+	if profile.User["email"] != "user@example.com" || profile.User["name"] != "Renamed User" {
+		t.Errorf("exported user: %v", profile.User)
+	}
+	if _, ok := profile.User["password"]; ok {
+		t.Error("exported user has the password hash")
+	}
+	if len(profile.Sessions) != 2 || len(profile.APIKeys) != 1 {
+		t.Errorf("exported %d sessions and %d API keys, want 2 and 1", len(profile.Sessions), len(profile.APIKeys))
+	}
+
+	// The audit history has the user's own events only
+	var events []models.AuditEventResponse
+	if err := json.Unmarshal(files["audit.json"], &events); err != nil {
+		t.Fatalf("audit.json: %v", err)
+	}
+	actions := []string{}
+	for _, event := range events {
+		if event.EntityType == models.AuditEntityUser && event.EntityID != 1 {
+			t.Errorf("exported another user's event: %+v", event)
+		}
+		actions = append(actions, event.EntityType+":"+event.Action)
+	}
+	want := []string{"user:create", "api_key:create", "user:update"}
+	if strings.Join(actions, " ") != strings.Join(want, " ") {
+		t.Errorf("exported events %v, want %v", actions, want)
+	}
+}
+
+func TestDeleteAccountRequiresReauthentication(t *testing.T) {
+	r := newTestRouter(t)
+	token := registerUser(t, r, "user@example.com", models.RoleUser)
+
+	if code, _ := request(t, r, "DELETE", "/users/me", token, "{}"); code != http.StatusUnauthorized {
+		t.Errorf("no proof: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := request(t, r, "DELETE", "/users/me", token, `{"currentPassword":"wrong-pass"}`); code != http.StatusUnauthorized {
+		t.Errorf("wrong password: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	var stored models.User
+	if err := config.DB.First(&stored, 1).Error; err != nil || stored.DeleteAfter != nil {
+		t.Fatalf("deletion scheduled without reauthentication: %+v, %v", stored.DeleteAfter, err)
+	}
+
+	code, response := request(t, r, "DELETE", "/users/me", token, `{"currentPassword":"secret-pass-1"}`)
+	if _, pending := response["deleteAfter"]; code != http.StatusAccepted || !pending {
+		t.Fatalf("delete account: %d %v", code, response)
+	}
+	if code, _ := request(t, r, "GET", "/users/me", token, ""); code != http.StatusUnauthorized {
+		t.Errorf("token after deleting the account: got %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestLoginCancelsAccountDeletion(t *testing.T) {
+	r := newTestRouter(t)
+	token := registerUser(t, r, "user@example.com", models.RoleUser)
+
+	if code, response := request(t, r, "DELETE", "/users/me", token, `{"currentPassword":"secret-pass-1"}`); code != http.StatusAccepted {
+		t.Fatalf("delete account: %d %v", code, response)
+	}
+
+	code, response := request(t, r, "POST", "/auth/login", "", `{"email":"user@example.com","password":"secret-pass-1"}`)
+	user, _ := response["user"].(map[string]interface{})
+	if code != http.StatusOK {
+		t.Fatalf("login: %d %v", code, response)
+	}
+	if _, pending := user["deleteAfter"]; pending {
+		t.Errorf("deletion still pending after login: %v", user)
+	}
+	var stored models.User
+	if err := config.DB.First(&stored, 1).Error; err != nil || stored.DeleteAfter != nil {
+		t.Errorf("stored user: %+v, %v", stored.DeleteAfter, err)
+	}
+}
+
+func TestVariantSKUConflict(t *testing.T) {
+	r := newTestRouter(t)
+	adminToken := registerUser(t, r, "admin@example.com", models.RoleAdmin)
+	if err := config.DB.Model(&models.User{}).Where("id = ?", 1).Update("email_verified_at", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if code, response := request(t, r, "POST", "/products", adminToken, `{"name":"Shirt","description":"A shirt","price":10,"quantity":5}`); code != http.StatusCreated {
+		t.Fatalf("create product: %d %v", code, response)
+	}
+	if code, response := request(t, r, "PUT", "/products/1/options", adminToken, `{"options":[{"name":"Size","values":["S","M"]}]}`); code != http.StatusOK {
+		t.Fatalf("set options: %d %v", code, response)
+	}
+	if code, response := request(t, r, "POST", "/products/1/variants", adminToken, `{"sku":"SHIRT-S","price":10,"quantity":2,"options":{"Size":"S"}}`); code != http.StatusCreated {
+		t.Fatalf("create variant: %d %v", code, response)
+	}
+
+	if code, _ := request(t, r, "POST", "/products/1/variants", adminToken, `{"sku":"SHIRT-S","price":10,"quantity":2,"options":{"Size":"M"}}`); code != http.StatusConflict {
+		t.Errorf("duplicate SKU: got %d, want %d", code, http.StatusConflict)
+	}
+
+	// When another request takes the SKU between the check and the save, the unique index
+	// rejects the variant
+	racingSKU := ""
+	takeSKU := func(tx *gorm.DB) {
+		variant, ok := tx.Statement.Dest.(*models.ProductVariant)
+		if !ok || racingSKU == "" || variant.SKU != racingSKU {
+			return
+		}
+		racingSKU = ""
+		err := tx.Session(&gorm.Session{NewDB: true}).
+			Exec("INSERT INTO product_variants (product_id, sku, price, quantity, created_at, updated_at) VALUES (1, ?, 10, 1, ?, ?)", variant.SKU, time.Now(), time.Now()).Error
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if err := config.DB.Callback().Create().Before("gorm:create").Register("test:take_sku", takeSKU); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.DB.Callback().Update().Before("gorm:update").Register("test:take_sku", takeSKU); err != nil {
+		t.Fatal(err)
+	}
+
+	racingSKU = "SHIRT-M"
+	if code, response := request(t, r, "POST", "/products/1/variants", adminToken, `{"sku":"SHIRT-M","price":10,"quantity":2,"options":{"Size":"M"}}`); code != http.StatusConflict {
+		t.Errorf("create racing for the SKU: got %d, want %d: %v", code, http.StatusConflict, response)
+	}
+	racingSKU = "SHIRT-L"
+	if code, response := request(t, r, "PUT", "/products/1/variants/1", adminToken, `{"sku":"SHIRT-L"}`); code != http.StatusConflict {
+		t.Errorf("update racing for the SKU: got %d, want %d: %v", code, http.StatusConflict, response)
+	}
+	if racingSKU != "" {
+		t.Error("the SKU wasn't taken between the check and the save")
+	}
+}
+
+func TestTagNameConflict(t *testing.T) {
+	r := newTestRouter(t)
+	adminToken := registerUser(t, r, "admin@example.com", models.RoleAdmin)
+
+	if code, response := request(t, r, "POST", "/admin/tags", adminToken, `{"name":"sale"}`); code != http.StatusCreated {
+		t.Fatalf("create tag: %d %v", code, response)
+	}
+	if code, _ := request(t, r, "POST", "/admin/tags", adminToken, `{"name":"Sale"}`); code != http.StatusConflict {
+		t.Errorf("duplicate tag: got %d, want %d", code, http.StatusConflict)
+	}
+
+	// When another request creates the tag between the check and the save, the unique index
+	// rejects it
+	racingName := ""
+	takeName := func(tx *gorm.DB) {
+		tag, ok := tx.Statement.Dest.(*models.Tag)
+		if !ok || racingName == "" || tag.Name != racingName {
+			return
+		}
+		racingName = ""
+		err := tx.Session(&gorm.Session{NewDB: true}).
+			Exec("INSERT INTO tags (name, created_at) VALUES (?, ?)", tag.Name, time.Now()).Error
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if err := config.DB.Callback().Create().Before("gorm:create").Register("test:take_name", takeName); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.DB.Callback().Update().Before("gorm:update").Register("test:take_name", takeName); err != nil {
+		t.Fatal(err)
+	}
+
+	racingName = "promo"
+	if code, response := request(t, r, "POST", "/admin/tags", adminToken, `{"name":"promo"}`); code != http.StatusConflict {
+		t.Errorf("create racing for the name: got %d, want %d: %v", code, http.StatusConflict, response)
+	}
+	racingName = "clearance"
+	if code, response := request(t, r, "PUT", "/admin/tags/1", adminToken, `{"name":"clearance"}`); code != http.StatusConflict {
+		t.Errorf("rename racing for the name: got %d, want %d: %v", code, http.StatusConflict, response)
+	}
+	if racingName != "" {
+		t.Error("the name wasn't taken between the check and the save")
+	}
+}
+
+func TestProductTagsAudited(t *testing.T) {
+	r := newTestRouter(t)
+	adminToken := registerUser(t, r, "admin@example.com", models.RoleAdmin)
+	if err := config.DB.Model(&models.User{}).Where("id = ?", 1).Update("email_verified_at", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+	if code, response := request(t, r, "POST", "/admin/tags", adminToken, `{"name":"sale"}`); code != http.StatusCreated {
+		t.Fatalf("create tag: %d %v", code, response)
+	}
+
+	body := `{"name":"Shirt","description":"A shirt","price":10,"quantity":5,"tags":["Sale","new"]}`
+	if code, response := request(t, r, "POST", "/products", adminToken, body); code != http.StatusCreated {
+		t.Fatalf("create product: %d %v", code, response)
+	}
+
+	var names []string
+	if err := config.DB.Model(&models.AuditEvent{}).
+		Joins("JOIN tags ON tags.id = audit_events.entity_id").
+		Where("audit_events.action = ? AND audit_events.entity_type = ?", models.AuditActionCreate, models.AuditEntityTag).
+		Order("tags.name").Pluck("tags.name", &names).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "new" || names[1] != "sale" {
+		t.Errorf("got tag creations %v, want [new sale]", names)
+	}
+}
+
+func TestCategoryMovesCantFormCycles(t *testing.T) {
+	r := newTestRouter(t)
+	adminToken := registerUser(t, r, "admin@example.com", models.RoleAdmin)
+	for _, name := range []string{"A", "B"} {
+		if code, response := request(t, r, "POST", "/admin/categories", adminToken, `{"name":"`+name+`"}`); code != http.StatusCreated {
+			t.Fatalf("create category %s: %d %v", name, code, response)
+		}
+	}
+
+	if code, _ := request(t, r, "PUT", "/admin/categories/1", adminToken, `{"name":"A","parentId":1}`); code != http.StatusBadRequest {
+		t.Errorf("move under itself: got %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// Another request moves B under A after this one read the categories, but before it
+	// moves A under B
+	racing := true
+	moveBUnderA := func(tx *gorm.DB) {
+		if _, locking := tx.Statement.Clauses["FOR"]; !locking || !racing {
+			return
+		}
+		racing = false
+		if err := tx.Session(&gorm.Session{NewDB: true}).
+			Exec("UPDATE categories SET parent_id = 1, path = '/1/2/' WHERE id = 2").Error; err != nil {
+			t.Error(err)
+		}
+	}
+	if err := config.DB.Callback().Query().Before("gorm:query").Register("test:move_b_under_a", moveBUnderA); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, response := request(t, r, "PUT", "/admin/categories/1", adminToken, `{"name":"A","parentId":2}`); code != http.StatusBadRequest {
+		t.Errorf("move A under B after B moved under A: got %d, want %d: %v", code, http.StatusBadRequest, response)
+	}
+	if racing {
+		t.Error("B wasn't moved while A was being moved")
+	}
+}
+
+// listNames returns the names of the listed items and the next cursor of a listing response
+func listNames(response map[string]interface{}, key string) ([]string, string) {
+	items, _ := response[key].([]interface{})
+	names := []string{}
+	for _, item := range items {
+		name, _ := item.(map[string]interface{})["name"].(string)
+		names = append(names, name)
+	}
+	pagination, _ := response["pagination"].(map[string]interface{})
+	nextCursor, _ := pagination["nextCursor"].(string)
+	return names, nextCursor
+}
+
+func TestProductListing(t *testing.T) {
+	r := newTestRouter(t)
+	for _, product := range []models.Product{
+		{Name: "Blue Shirt", Description: "Shirt", Price: 30, Quantity: 2},
+		{Name: "Red Shirt", Description: "Shirt", Price: 20, Quantity: 0},
+		{Name: "Green Hat", Description: "Hat", Price: 15, Quantity: 5},
+		{Name: "Black Shoes", Description: "Shoes", Price: 80, Quantity: 1},
+		{Name: "White Socks", Description: "Socks", Price: 5, Quantity: 10},
+	} {
+		if err := config.DB.Create(&product).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"?sort=name", []string{"Black Shoes", "Blue Shirt", "Green Hat", "Red Shirt", "White Socks"}},
+		{"?name=SHIRT&sort=-price", []string{"Blue Shirt", "Red Shirt"}},
+		{"?minPrice=10&maxPrice=30&sort=price", []string{"Green Hat", "Red Shirt", "Blue Shirt"}},
+		{"?inStock=false", []string{"Red Shirt"}},
+		{"?name=nothing", []string{}},
+	}
+	for _, test := range tests {
+		code, response := request(t, r, "GET", "/products"+test.query, "", "")
+		names, _ := listNames(response, "products")
+		if code != http.StatusOK || strings.Join(names, ",") != strings.Join(test.want, ",") {
+			t.Errorf("%s: got %d %v, want %v", test.query, code, names, test.want)
+		}
+	}
+
+	// Following the cursors lists every product once, in order
+	var walked []string
+	cursor := ""
+	for page := 0; page < 5; page++ {
+		query := "/products?sort=-price&pageSize=2"
+		if cursor != "" {
+			query += "&cursor=" + cursor
+		}
+		code, response := request(t, r, "GET", query, "", "")
+		if code != http.StatusOK {
+			t.Fatalf("%s: %d %v", query, code, response)
+		}
+		var names []string
+		names, cursor = listNames(response, "products")
+		walked = append(walked, names...)
+		if cursor == "" {
+			break
+		}
+	}
+	if want := "Black Shoes,Blue Shirt,Red Shirt,Green Hat,White Socks"; strings.Join(walked, ",") != want {
+		t.Errorf("walking the cursors: got %v, want %s", walked, want)
+	}
+
+	_, response := request(t, r, "GET", "/products?sort=-price&pageSize=2", "", "")
+	if pagination, _ := response["pagination"].(map[string]interface{}); pagination["total"] != float64(5) || pagination["totalPages"] != float64(3) {
+		t.Errorf("pagination: %v", response["pagination"])
+	}
+	_, cursor = listNames(response, "products")
+	for _, query := range []string{
+		"?color=red",
+		"?sort=password",
+		"?sort=name,name",
+		"?pageSize=0",
+		"?minPrice=30&maxPrice=10",
+		"?inStock=maybe",
+		"?sort=price&cursor=" + cursor,
+		"?sort=-price&page=2&cursor=" + cursor,
+		"?cursor=not-a-cursor",
+	} {
+		if code, _ := request(t, r, "GET", "/products"+query, "", ""); code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want %d", query, code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestProductFacets(t *testing.T) {
+	r := newTestRouter(t)
+	sale, cotton := models.Tag{Name: "sale"}, models.Tag{Name: "cotton"}
+	clothing, shoes := models.Category{ID: 1, Name: "Clothing", Path: models.CategoryPath("", 1)}, models.Category{ID: 3, Name: "Shoes", Path: models.CategoryPath("", 3)}
+	shirts := models.Category{ID: 2, Name: "Shirts", ParentID: &clothing.ID, Path: models.CategoryPath(clothing.Path, 2)}
+	for _, record := range []interface{}{&sale, &cotton, &clothing, &shirts, &shoes} {
+		if err := config.DB.Create(record).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Prices on the bounds fall in the range above them
+	for _, product := range []models.Product{
+		{Name: "Tee", Description: "Tee", Price: 20, Quantity: 1, Tags: []models.Tag{sale, cotton}, Categories: []models.Category{shirts}},
+		{Name: "Polo", Description: "Polo", Price: 50, Quantity: 1, Tags: []models.Tag{cotton}, Categories: []models.Category{shirts}},
+		{Name: "Jacket", Description: "Jacket", Price: 150, Quantity: 1, Tags: []models.Tag{sale}, Categories: []models.Category{clothing}},
+		{Name: "Boots", Description: "Boots", Price: 500, Quantity: 1, Tags: []models.Tag{sale}, Categories: []models.Category{shoes}},
+		{Name: "Socks", Description: "Socks", Price: 5, Quantity: 1},
+	} {
+		if err := config.DB.Create(&product).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query                   string
+		tags, categories, price string
+	}{
+		{"", "sale:3,cotton:2", "Clothing:3,Shirts:2,Shoes:1", "0-50:2,50-100:1,100-200:1,200-500:0,500-:1"},
+		{"?tag=cotton", "cotton:2,sale:1", "Clothing:2,Shirts:2", "0-50:1,50-100:1,100-200:0,200-500:0,500-:0"},
+		{"?category=1", "cotton:2,sale:2", "Clothing:3,Shirts:2", "0-50:1,50-100:1,100-200:1,200-500:0,500-:0"},
+		{"?name=nothing", "", "", "0-50:0,50-100:0,100-200:0,200-500:0,500-:0"},
+	}
+	for _, test := range tests {
+		code, response := request(t, r, "GET", "/products"+test.query, "", "")
+		if code != http.StatusOK {
+			t.Fatalf("%s: %d %v", test.query, code, response)
+		}
+		encoded, _ := json.Marshal(response["facets"])
+		var facets models.ProductFacets
+		if err := json.Unmarshal(encoded, &facets); err != nil {
+			t.Fatal(err)
+		}
+
+		var tags, categories, prices []string
+		for _, facet := range facets.Tags {
+			tags = append(tags, fmt.Sprintf("%s:%d", facet.Name, facet.Count))
+		}
+		for _, facet := range facets.Categories {
+			categories = append(categories, fmt.Sprintf("%s:%d", facet.Name, facet.Count))
+		}
+		for _, facet := range facets.Prices {
+			max := ""
+			if facet.Max != nil {
+				max = fmt.Sprint(*facet.Max)
+			}
+			prices = append(prices, fmt.Sprintf("%v-%s:%d", facet.Min, max, facet.Count))
+		}
+		if got := strings.Join(tags, ","); got != test.tags {
+			t.Errorf("%s: tag facets %s, want %s", test.query, got, test.tags)
+		}
+		if got := strings.Join(categories, ","); got != test.categories {
+			t.Errorf("%s: category facets %s, want %s", test.query, got, test.categories)
+		}
+		if got := strings.Join(prices, ","); got != test.price {
+			t.Errorf("%s: price facets %s, want %s", test.query, got, test.price)
+		}
+	}
+}
+
+func TestProductSearchWithoutPostgres(t *testing.T) {
+	r := newTestRouter(t)
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{"no words", "q=%26+%7C", http.StatusBadRequest},
+		{"unknown parameter", "q=shirt&sort=name", http.StatusBadRequest},
+		{"SQLite", "q=shirt", http.StatusNotImplemented},
+	}
+	for _, tt := range tests {
+		if code, response := request(t, r, "GET", "/products/search?"+tt.query, "", ""); code != tt.want {
+			t.Errorf("%s: got %d, want %d: %v", tt.name, code, tt.want, response)
+		}
+	}
+}
+
+func TestProductSuggestionsByPattern(t *testing.T) {
+	r := newTestRouter(t)
+	adminToken := registerUser(t, r, "admin@example.com", models.RoleAdmin)
+	for _, name := range []string{"Blusa camisa", "Camiseta azul", "Camisa", "Calça", "100% algodão", "Kit a_b"} {
+		body := `{"name":"` + name + `","description":"Test product","price":10,"quantity":1}`
+		if code, response := request(t, r, "POST", "/products", adminToken, body); code != http.StatusCreated {
+			t.Fatalf("create %s: %d %v", name, code, response)
+		}
+	}
+	suggest := func(query string) (int, []string) {
+		code, response := request(t, r, "GET", "/products/suggest?"+query, "", "")
+		names, _ := listNames(response, "suggestions")
+		return code, names
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"prefix matches first, shortest first", "q=CAMI", []string{"Camisa", "Camiseta azul", "Blusa camisa"}},
+		{"limit", "q=cami&limit=2", []string{"Camisa", "Camiseta azul"}},
+		{"percent is literal", "q=%25", []string{"100% algodão"}},
+		{"underscore is literal", "q=_", []string{"Kit a_b"}},
+		{"no match", "q=sapato", []string{}},
+	}
+	for _, tt := range tests {
+		code, names := suggest(tt.query)
+		if code != http.StatusOK || strings.Join(names, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: got %d %v, want %v", tt.name, code, names, tt.want)
+		}
+	}
+
+	for _, query := range []string{"q=", "q=cami&limit=0", "q=cami&limit=21", "q=cami&sort=name"} {
+		if code, _ := suggest(query); code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want %d", query, code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestUserListing(t *testing.T) {
+	r := newTestRouter(t)
+	adminToken := registerUser(t, r, "admin@example.com", models.RoleAdmin)
+	verifiedAt := time.Now()
+	for _, user := range []models.User{
+		{Name: "Alice", Email: "alice@example.com", Password: "secret-pass-1", Role: models.RoleEditor, EmailVerifiedAt: &verifiedAt},
+		{Name: "Bob", Email: "bob@shop.example", Password: "secret-pass-1", Role: models.RoleUser},
+		{Name: "Carol", Email: "carol@shop.example", Password: "secret-pass-1", Role: models.RoleUser, EmailVerifiedAt: &verifiedAt},
+	} {
+		if err := config.DB.Create(&user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"?sort=-name", []string{"Test User", "Carol", "Bob", "Alice"}},
+		{"?q=SHOP&sort=name", []string{"Bob", "Carol"}},
+		{"?q=alice", []string{"Alice"}},
+		{"?role=user&verified=true", []string{"Carol"}},
+		{"?role=editor", []string{"Alice"}},
+		{"?createdFrom=2000-01-01T00:00:00Z&createdTo=2001-01-01T00:00:00Z", []string{}},
+	}
+	for _, test := range tests {
+		code, response := request(t, r, "GET", "/admin/users"+test.query, adminToken, "")
+		names, _ := listNames(response, "users")
+		if code != http.StatusOK || strings.Join(names, ",") != strings.Join(test.want, ",") {
+			t.Errorf("%s: got %d %v, want %v", test.query, code, names, test.want)
+		}
+	}
+
+	// No results are an empty array, not null
+	_, response := request(t, r, "GET", "/admin/users?q=nobody", adminToken, "")
+	if users, ok := response["users"].([]interface{}); !ok || len(users) != 0 {
+		t.Errorf("no results: got %v", response["users"])
+	}
+
+	// Following the cursors lists every user once, in order
+	var walked []string
+	cursor := ""
+	for page := 0; page < 4; page++ {
+		query := "/admin/users?sort=email&pageSize=3"
+		if cursor != "" {
+			query += "&cursor=" + cursor
+		}
+		code, response := request(t, r, "GET", query, adminToken, "")
+		if code != http.StatusOK {
+			t.Fatalf("%s: %d %v", query, code, response)
+		}
+		var names []string
+		names, cursor = listNames(response, "users")
+		walked = append(walked, names...)
+		if cursor == "" {
+			break
+		}
+	}
+	if want := "Test User,Alice,Bob,Carol"; strings.Join(walked, ",") != want {
+		t.Errorf("walking the cursors: got %v, want %s", walked, want)
+	}
+
+	for _, query := range []string{"?search=bob", "?role=owner", "?verified=yes", "?sort=password", "?createdFrom=yesterday", "?pageSize=1000"} {
+		if code, _ := request(t, r, "GET", "/admin/users"+query, adminToken, ""); code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want %d", query, code, http.StatusBadRequest)
+		}
+	}
+}
@@ -58,8 +58,9 @@ const AuthProvider = ({ children }) => {
       });
       const { token: newToken } = response.data;
 
-      // Defina o token no localStorage e no estado
+      // Defina os tokens no localStorage e no estado
       localStorage.setItem("token", newToken);
+      localStorage.setItem("refreshToken", response.data.refreshToken);
       setToken(newToken);
 
       // Carregue os dados do usuário com o novo token
@@ -72,7 +73,10 @@ const AuthProvider = ({ children }) => {
       console.error("Registration error:", err);
       const errorMessage = err.response?.data?.error || "Registration failed";
       setError(errorMessage);
-      throw new Error(errorMessage);
+      // Repassar as regras de senha violadas para o formulário
+      const error = new Error(errorMessage);
+      error.passwordErrors = err.response?.data?.passwordErrors || [];
+      throw error;
     } finally {
       setLoading(false);
     }
@@ -95,14 +99,20 @@ const AuthProvider = ({ children }) => {
       const response = await api.post("/auth/login", loginData);
       console.log("Login response:", response.data);
 
+      // Com MFA ativo, o login continua em verifyMfa
+      if (response.data && response.data.mfaRequired) {
+        return { mfaRequired: true, mfaToken: response.data.mfaToken };
+      }
+
       if (!response.data || !response.data.token) {
         throw new Error("Token not found in response");
       }
 
       const { token: newToken } = response.data;
 
-      // Defina o token no localStorage e no estado
+      // Defina os tokens no localStorage e no estado
       localStorage.setItem("token", newToken);
+      localStorage.setItem("refreshToken", response.data.refreshToken);
       setToken(newToken);
 
       // Carregue os dados do usuário com o novo token
@@ -123,11 +133,81 @@ const AuthProvider = ({ children }) => {
     }
   };
 
+  // Complete a login through an external identity provider (OIDC)
+  const loginWithOidc = async (code) => {
+    setLoading(true);
+    try {
+      const response = await api.post("/auth/oidc/exchange", { code });
+
+      // Com MFA ativo, o login continua em verifyMfa
+      if (response.data && response.data.mfaRequired) {
+        return { mfaRequired: true, mfaToken: response.data.mfaToken };
+      }
+
+      const { token: newToken } = response.data;
+
+      // Defina os tokens no localStorage e no estado
+      localStorage.setItem("token", newToken);
+      localStorage.setItem("refreshToken", response.data.refreshToken);
+      setToken(newToken);
+
+      setUser(response.data.user);
+      setError(null);
+      return response.data.user;
+    } catch (err) {
+      console.error("OIDC login error:", err);
+      const errorMessage = err.response?.data?.error || "Login failed";
+      setError(errorMessage);
+      throw new Error(errorMessage);
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  // Complete a login that requires MFA
+  const verifyMfa = async (mfaToken, code) => {
+    setLoading(true);
+    try {
+      const response = await api.post("/auth/mfa/verify", { mfaToken, code });
+      const { token: newToken } = response.data;
+
+      // Defina os tokens no localStorage e no estado
+      localStorage.setItem("token", newToken);
+      localStorage.setItem("refreshToken", response.data.refreshToken);
+      setToken(newToken);
+
+      setUser(response.data.user);
+      setError(null);
+      return response.data.user;
+    } catch (err) {
+      console.error("MFA verification error:", err);
+      const errorMessage = err.response?.data?.error || "MFA verification failed";
+      setError(errorMessage);
+      throw new Error(errorMessage);
+    } finally {
+      setLoading(false);
+    }
+  };
+
   // Logout the user
   const logout = () => {
+    // Revogar os tokens no servidor; o estado local é limpo mesmo se falhar
+    const currentToken = localStorage.getItem("token");
+    const refreshToken = localStorage.getItem("refreshToken");
+    if (currentToken) {
+      api
+        .post(
+          "/auth/logout",
+          { refreshToken },
+          { headers: { Authorization: `Bearer ${currentToken}` } }
+        )
+        .catch(() => {});
+    }
+
     setUser(null);
     setToken(null);
     localStorage.removeItem("token");
+    localStorage.removeItem("refreshToken");
   };
 
   // Update user profile
@@ -135,6 +215,7 @@ const AuthProvider = ({ children }) => {
     setLoading(true);
     try {
       const response = await api.put("/users/me", userData);
+
       setUser(response.data.user);
       setError(null);
       return response.data.user;
@@ -149,6 +230,33 @@ const AuthProvider = ({ children }) => {
     }
   };
 
+  // Trocar a senha (exige a senha atual ou um código MFA)
+  const changePassword = async (passwordData) => {
+    setLoading(true);
+    try {
+      const response = await api.put("/users/me/password", passwordData);
+
+      // Trocar a senha revoga os tokens antigos; use os novos
+      localStorage.setItem("token", response.data.token);
+      localStorage.setItem("refreshToken", response.data.refreshToken);
+      setToken(response.data.token);
+
+      setUser(response.data.user);
+      setError(null);
+      return response.data.user;
+    } catch (err) {
+      console.error("Change password error:", err);
+      const errorMessage =
+        err.response?.data?.error || "Failed to change password";
+      setError(errorMessage);
+      const error = new Error(errorMessage);
+      error.passwordErrors = err.response?.data?.passwordErrors;
+      throw error;
+    } finally {
+      setLoading(false);
+    }
+  };
+
   // Upload profile image
   const uploadProfileImage = async (formData) => {
     setLoading(true);
@@ -192,8 +300,11 @@ const AuthProvider = ({ children }) => {
     error,
     register,
     login,
+    loginWithOidc,
+    verifyMfa,
     logout,
     updateProfile,
+    changePassword,
     uploadProfileImage,
   };
 
@@ -1,6 +1,7 @@
-import React, { useState } from "react";
+import React, { useEffect, useState } from "react";
 import { Link as RouterLink, useNavigate } from "react-router-dom";
 import { useAuth } from "../contexts/AuthContext";
+import api from "../services/api";
 import {
   Avatar,
   Box,
@@ -11,6 +12,7 @@ import {
   TextField,
   Typography,
   Alert,
+  Divider,
 } from "@mui/material";
 import LockOutlinedIcon from "@mui/icons-material/LockOutlined";
 
@@ -19,11 +21,57 @@ const Login = () => {
     email: "",
     password: "",
   });
+  const [mfaToken, setMfaToken] = useState(null);
+  const [mfaCode, setMfaCode] = useState("");
   const [error, setError] = useState("");
   const [loading, setLoading] = useState(false);
-  const { login } = useAuth();
+  const [providers, setProviders] = useState([]);
+  const { login, loginWithOidc, verifyMfa } = useAuth();
   const navigate = useNavigate();
 
+  // Carregar os provedores de identidade configurados
+  useEffect(() => {
+    api
+      .get("/auth/oidc/providers")
+      .then((response) => setProviders(response.data.providers || []))
+      .catch(() => setProviders([]));
+  }, []);
+
+  // Concluir o login OIDC quando o backend redireciona de volta
+  useEffect(() => {
+    const params = new URLSearchParams(window.location.hash.slice(1));
+    const oidcCode = params.get("oidcCode");
+    const oidcError = params.get("oidcError");
+    if (!oidcCode && !oidcError) {
+      return;
+    }
+
+    // Remover o código da URL
+    window.history.replaceState(null, "", window.location.pathname);
+
+    if (oidcError) {
+      setError(
+        oidcError === "email_not_verified"
+          ? "O provedor de identidade não confirmou seu email."
+          : "Falha ao entrar com o provedor de identidade."
+      );
+      return;
+    }
+
+    setLoading(true);
+    loginWithOidc(oidcCode)
+      .then((result) => {
+        if (result && result.mfaRequired) {
+          setMfaToken(result.mfaToken);
+          return;
+        }
+        navigate("/");
+      })
+      .catch(() => setError("Falha ao entrar com o provedor de identidade."))
+      .finally(() => setLoading(false));
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, []);
+
   const handleChange = (e) => {
     const { name, value } = e.target;
     setFormData({
@@ -46,7 +94,11 @@ const Login = () => {
       }
 
       console.log("Tentando login com:", formData);
-      await login(formData);
+      const result = await login(formData);
+      if (result && result.mfaRequired) {
+        setMfaToken(result.mfaToken);
+        return;
+      }
       navigate("/");
     } catch (err) {
       console.error("Erro ao fazer login:", err);
@@ -56,6 +108,21 @@ const Login = () => {
     }
   };
 
+  const handleMfaSubmit = async (e) => {
+    e.preventDefault();
+    try {
+      setError("");
+      setLoading(true);
+      await verifyMfa(mfaToken, mfaCode);
+      navigate("/");
+    } catch (err) {
+      console.error("Erro na verificação MFA:", err);
+      setError("Código inválido. Tente novamente.");
+    } finally {
+      setLoading(false);
+    }
+  };
+
   return (
     <Container component="main" maxWidth="xs">
       <Box
@@ -77,57 +144,107 @@ const Login = () => {
             {error}
           </Alert>
         )}
-        <Box component="form" onSubmit={handleSubmit} noValidate sx={{ mt: 1 }}>
-          <TextField
-            margin="normal"
-            required
-            fullWidth
-            id="email"
-            label="Endereço de Email"
-            name="email"
-            autoComplete="email"
-            autoFocus
-            value={formData.email}
-            onChange={handleChange}
-          />
-          <TextField
-            margin="normal"
-            required
-            fullWidth
-            name="password"
-            label="Senha"
-            type="password"
-            id="password"
-            autoComplete="current-password"
-            value={formData.password}
-            onChange={handleChange}
-          />
-          <Button
-            type="submit"
-            fullWidth
-            variant="contained"
-            sx={{ mt: 3, mb: 2 }}
-            disabled={loading}
+        {mfaToken ? (
+          <Box
+            component="form"
+            onSubmit={handleMfaSubmit}
+            noValidate
+            sx={{ mt: 1, width: "100%" }}
           >
-            {loading ? "Processando..." : "Entrar"}
-          </Button>
-          <Grid container>
-            <Grid item xs>
-              <Link
-                component={RouterLink}
-                to="/forgot-password"
-                variant="body2"
-              >
-                Esqueceu a senha?
-              </Link>
+            <TextField
+              margin="normal"
+              required
+              fullWidth
+              id="mfaCode"
+              label="Código de autenticação"
+              name="mfaCode"
+              autoComplete="one-time-code"
+              autoFocus
+              value={mfaCode}
+              onChange={(e) => setMfaCode(e.target.value)}
+            />
+            <Button
+              type="submit"
+              fullWidth
+              variant="contained"
+              sx={{ mt: 3, mb: 2 }}
+              disabled={loading}
+            >
+              {loading ? "Processando..." : "Verificar"}
+            </Button>
+          </Box>
+        ) : (
+          <Box component="form" onSubmit={handleSubmit} noValidate sx={{ mt: 1 }}>
+            <TextField
+              margin="normal"
+              required
+              fullWidth
+              id="email"
+              label="Endereço de Email"
+              name="email"
+              autoComplete="email"
+              autoFocus
+              value={formData.email}
+              onChange={handleChange}
+            />
+            <TextField
+              margin="normal"
+              required
+              fullWidth
+              name="password"
+              label="Senha"
+              type="password"
+              id="password"
+              autoComplete="current-password"
+              value={formData.password}
+              onChange={handleChange}
+            />
+            <Button
+              type="submit"
+              fullWidth
+              variant="contained"
+              sx={{ mt: 3, mb: 2 }}
+              disabled={loading}
+            >
+              {loading ? "Processando..." : "Entrar"}
+            </Button>
+            {providers.length > 0 && (
+              <>
+                <Divider sx={{ mb: 2 }}>ou</Divider>
+                {providers.map((provider) => (
+                  <Button
+                    key={provider.name}
+                    fullWidth
+                    variant="outlined"
+                    sx={{ mb: 2 }}
+                    href={`/api/auth/oidc/login?provider=${encodeURIComponent(
+                      provider.name
+                    )}`}
+                    disabled={loading}
+                  >
+                    Entrar com {provider.displayName}
+                  </Button>
+                ))}
+              </>
+            )}
+            <Grid container>
+              <Grid item xs>
+                <Link
+                  component={RouterLink}
+                  to="/forgot-password"
+                  variant="body2"
+                >
+                  Esqueceu a senha?
+                </Link>
+              </Grid>
+              <Grid item>
+                <Link component={RouterLink} to="/register" variant="body2">
+                  {"Não tem uma conta? Cadastre-se"}
+                </Link>
+              </Grid>
             </Grid>
-            <Grid item>
-              <Link component={RouterLink} to="/register" variant="body2">
-                {"Não tem uma conta? Cadastre-se"}
-              </Link>
-            </Grid>
-          </Grid>
-        </Box>
+          </Box>
+        )}
       </Box>
     </Container>
   );
@@ -32,6 +32,8 @@ const Products = () => {
   const [filteredProducts, setFilteredProducts] = useState([]);
   const [searchTerm, setSearchTerm] = useState("");
   const [loading, setLoading] = useState(true);
+  const [loadingMore, setLoadingMore] = useState(false);
+  const [nextCursor, setNextCursor] = useState(null);
   const [error, setError] = useState("");
   const [deleteDialogOpen, setDeleteDialogOpen] = useState(false);
   const [productToDelete, setProductToDelete] = useState(null);
@@ -41,17 +43,38 @@ const Products = () => {
     fetchProducts();
   }, []);
 
+  // A busca é feita no servidor, com um pequeno atraso enquanto o usuário digita;
+  // se a busca não estiver disponível, filtra os produtos já carregados
   useEffect(() => {
-    if (searchTerm) {
-      const filtered = products.filter(
-        (product) =>
-          product.name.toLowerCase().includes(searchTerm.toLowerCase()) ||
-          product.description.toLowerCase().includes(searchTerm.toLowerCase())
-      );
-      setFilteredProducts(filtered);
-    } else {
+    if (!searchTerm.trim()) {
       setFilteredProducts(products);
+      return;
     }
+
+    let cancelled = false;
+    const timer = setTimeout(async () => {
+      try {
+        const response = await api.get("/products/search", {
+          params: { q: searchTerm },
+        });
+        if (!cancelled) setFilteredProducts(response.data.products || []);
+      } catch (err) {
+        if (cancelled) return;
+        const term = searchTerm.toLowerCase();
+        setFilteredProducts(
+          products.filter(
+            (product) =>
+              product.name.toLowerCase().includes(term) ||
+              product.description.toLowerCase().includes(term)
+          )
+        );
+      }
+    }, 300);
+
+    return () => {
+      cancelled = true;
+      clearTimeout(timer);
+    };
   }, [searchTerm, products]);
 
   const fetchProducts = async () => {
@@ -63,6 +86,7 @@ const Products = () => {
       const productsData = response.data.products || [];
       setProducts(productsData);
       setFilteredProducts(productsData);
+      setNextCursor(response.data.pagination?.nextCursor || null);
       setError("");
     } catch (err) {
       console.error("Erro ao buscar produtos:", err);
@@ -72,6 +96,24 @@ const Products = () => {
     }
   };
 
+  // A lista é paginada; o cursor traz a próxima página
+  const fetchMoreProducts = async () => {
+    if (!nextCursor) return;
+    try {
+      setLoadingMore(true);
+      const response = await api.get("/products", {
+        params: { cursor: nextCursor },
+      });
+      setProducts([...products, ...(response.data.products || [])]);
+      setNextCursor(response.data.pagination?.nextCursor || null);
+    } catch (err) {
+      console.error("Erro ao buscar mais produtos:", err);
+      setError("Falha ao carregar mais produtos. Por favor, tente novamente.");
+    } finally {
+      setLoadingMore(false);
+    }
+  };
+
   const handleSearchChange = (event) => {
     setSearchTerm(event.target.value);
   };
@@ -257,6 +299,18 @@ const Products = () => {
         </Grid>
       )}
 
+      {!loading && !searchTerm.trim() && nextCursor && (
+        <Box sx={{ display: "flex", justifyContent: "center", mt: 4 }}>
+          <Button
+            variant="outlined"
+            onClick={fetchMoreProducts}
+            disabled={loadingMore}
+          >
+            {loadingMore ? <CircularProgress size={24} /> : "Carregar mais"}
+          </Button>
+        </Box>
+      )}
+
       {/* Diálogo de confirmação para exclusão */}
       <Dialog
         open={deleteDialogOpen}
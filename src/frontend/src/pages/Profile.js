@@ -20,10 +20,12 @@ import { useAuth } from "../contexts/AuthContext";
 import api from "../services/api";
 
 const Profile = () => {
-  const { user, updateProfile, uploadProfileImage } = useAuth();
+  const { user, updateProfile, changePassword, uploadProfileImage } =
+    useAuth();
   const [formData, setFormData] = useState({
     name: user?.name || "",
     email: user?.email || "",
+    currentPassword: "",
     password: "",
     confirmPassword: "",
   });
@@ -56,25 +58,38 @@ const Profile = () => {
       return setError("As senhas não coincidem");
     }
 
-    // Preparar dados para envio (omitir confirmPassword)
-    const updateData = {
-      name: formData.name,
-    };
-
-    // Só incluir senha se foi preenchida
-    if (formData.password) {
-      updateData.password = formData.password;
+    // Trocar a senha exige confirmar a senha atual
+    if (formData.password && !formData.currentPassword) {
+      setLoading(false);
+      return setError("Informe a senha atual para trocar a senha");
     }
 
     try {
       // Chamar API para atualizar perfil
-      await updateProfile(updateData);
+      await updateProfile({ name: formData.name });
+
+      // A senha tem um endpoint próprio
+      if (formData.password) {
+        await changePassword({
+          currentPassword: formData.currentPassword,
+          newPassword: formData.password,
+        });
+        setFormData({
+          ...formData,
+          currentPassword: "",
+          password: "",
+          confirmPassword: "",
+        });
+      }
+
       setSuccess("Perfil atualizado com sucesso!");
       setOpenSnackbar(true);
     } catch (err) {
       console.error("Erro ao atualizar perfil:", err);
       setError(
-        "Falha ao atualizar perfil. Verifique seus dados e tente novamente."
+        err.passwordErrors?.map((e) => e.message).join(" ") ||
+          (formData.password && err.message) ||
+          "Falha ao atualizar perfil. Verifique seus dados e tente novamente."
       );
     } finally {
       setLoading(false);
@@ -252,6 +267,18 @@ const Profile = () => {
                     atual)
                   </Typography>
                 </Grid>
+                <Grid item xs={12}>
+                  <TextField
+                    fullWidth
+                    name="currentPassword"
+                    label="Senha Atual"
+                    type="password"
+                    id="currentPassword"
+                    autoComplete="current-password"
+                    value={formData.currentPassword}
+                    onChange={handleChange}
+                  />
+                </Grid>
                 <Grid item xs={12} sm={6}>
                   <TextField
                     fullWidth
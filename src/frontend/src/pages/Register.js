@@ -47,6 +47,10 @@ const Register = () => {
       await register(formData.name, formData.email, formData.password);
       navigate("/");
     } catch (err) {
+      if (err.passwordErrors && err.passwordErrors.length > 0) {
+        setError(err.passwordErrors.map((e) => e.message).join(" "));
+        return;
+      }
       setError("Falha ao criar conta. Tente novamente.");
       console.error(err);
     } finally {
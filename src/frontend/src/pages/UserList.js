@@ -28,6 +28,8 @@ import { useAuth } from "../contexts/AuthContext";
 const UserList = () => {
   const [users, setUsers] = useState([]);
   const [loading, setLoading] = useState(true);
+  const [loadingMore, setLoadingMore] = useState(false);
+  const [nextCursor, setNextCursor] = useState(null);
   const [error, setError] = useState("");
   const [deleteDialogOpen, setDeleteDialogOpen] = useState(false);
   const [userToDelete, setUserToDelete] = useState(null);
@@ -42,6 +44,7 @@ const UserList = () => {
       setLoading(true);
       const response = await api.get("/admin/users");
       setUsers(response.data.users || []);
+      setNextCursor(response.data.pagination?.nextCursor || null);
       setError("");
     } catch (err) {
       console.error("Erro ao buscar usuários:", err);
@@ -51,6 +54,24 @@ const UserList = () => {
     }
   };
 
+  // A lista é paginada; o cursor traz a próxima página
+  const fetchMoreUsers = async () => {
+    if (!nextCursor) return;
+    try {
+      setLoadingMore(true);
+      const response = await api.get("/admin/users", {
+        params: { cursor: nextCursor },
+      });
+      setUsers([...users, ...(response.data.users || [])]);
+      setNextCursor(response.data.pagination?.nextCursor || null);
+    } catch (err) {
+      console.error("Erro ao buscar mais usuários:", err);
+      setError("Falha ao carregar mais usuários.");
+    } finally {
+      setLoadingMore(false);
+    }
+  };
+
   const handleDeleteClick = (user) => {
     setUserToDelete(user);
     setDeleteDialogOpen(true);
@@ -149,6 +170,18 @@ const UserList = () => {
         </TableContainer>
       )}
 
+      {nextCursor && (
+        <Box sx={{ display: "flex", justifyContent: "center", mt: 3 }}>
+          <Button
+            variant="outlined"
+            onClick={fetchMoreUsers}
+            disabled={loadingMore}
+          >
+            {loadingMore ? <CircularProgress size={24} /> : "Carregar mais"}
+          </Button>
+        </Box>
+      )}
+
       {/* Diálogo de confirmação para exclusão */}
       <Dialog
         open={deleteDialogOpen}
@@ -19,14 +19,49 @@ api.interceptors.request.use(
   }
 );
 
+// Renovação em andamento, compartilhada entre requisições concorrentes
+let refreshPromise = null;
+
+// Trocar o refresh token por um novo par de tokens
+const refreshTokens = async () => {
+  const refreshToken = localStorage.getItem("refreshToken");
+  if (!refreshToken) {
+    throw new Error("No refresh token");
+  }
+
+  const response = await axios.post("/api/auth/refresh", { refreshToken });
+  localStorage.setItem("token", response.data.token);
+  localStorage.setItem("refreshToken", response.data.refreshToken);
+  return response.data.token;
+};
+
 // Interceptar respostas para tratamento global de erros
 api.interceptors.response.use(
   (response) => response,
-  (error) => {
+  async (error) => {
+    const originalRequest = error.config;
+
     // Tratar erro de autenticação (401)
     if (error.response && error.response.status === 401) {
+      // Tentar renovar o token uma vez antes de deslogar
+      const isAuthRequest = originalRequest?.url?.startsWith("/auth/");
+      if (originalRequest && !originalRequest._retry && !isAuthRequest) {
+        originalRequest._retry = true;
+        try {
+          refreshPromise = refreshPromise || refreshTokens();
+          const newToken = await refreshPromise;
+          originalRequest.headers.Authorization = `Bearer ${newToken}`;
+          return api(originalRequest);
+        } catch (refreshError) {
+          // Renovação falhou, segue para o logout
+        } finally {
+          refreshPromise = null;
+        }
+      }
+
       // Limpar token e redirecionar para login se necessário
       localStorage.removeItem("token");
+      localStorage.removeItem("refreshToken");
       if (window.location.pathname !== "/login") {
         window.location.href = "/login";
       }